@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"log"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"go.uber.org/zap"
@@ -9,8 +14,11 @@ import (
 	"github.com/WPGe/go-yandex-advanced/internal/agent"
 	"github.com/WPGe/go-yandex-advanced/internal/config"
 	"github.com/WPGe/go-yandex-advanced/internal/storage"
+	"github.com/WPGe/go-yandex-advanced/internal/utils"
 )
 
+const shutdownTimeout = 5 * time.Second
+
 func main() {
 	logger, err := zap.NewDevelopment()
 	if err != nil {
@@ -28,12 +36,185 @@ func main() {
 		logger.Error("Init config error", zap.Error(err))
 	}
 
-	logger.Info("Starting agent", zap.String("addr", cfg.Address))
+	logger.Info("Starting agent", zap.String("addr", cfg.Address), zap.String("exporter", cfg.Exporter), zap.Bool("dry-run", cfg.DryRun))
+
+	var tlsConfig *tls.Config
+	if cfg.TLSEnabled() {
+		tlsConfig, err = utils.NewClientTLSConfig(cfg.TLSCA, cfg.TLSCert, cfg.TLSKey, cfg.TLSServerName)
+		if err != nil {
+			logger.Fatal("Init TLS error", zap.Error(err))
+		}
+	}
+
+	instance, err := agent.ResolveInstanceID(cfg.InstanceID, cfg.InstanceSource, cfg.InstanceIDFile)
+	if err != nil {
+		logger.Fatal("Init instance identity error", zap.Error(err))
+	}
+	logger.Info("Agent instance", zap.String("instance", instance), zap.String("template", cfg.MetricTemplate))
+
+	if cfg.SimInstances > 0 {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+		defer stop()
+		simulate(ctx, logger, cfg, instance, tlsConfig)
+		return
+	}
+
+	exporter, err := buildExporter(logger, cfg, cfg.Exporter, cfg.ExportFormat, instance, tlsConfig)
+	if err != nil {
+		logger.Fatal("Init exporter error", zap.Error(err))
+	}
+	if cfg.OTLPEndpoint != "" {
+		otlpExporter, err := buildExporter(logger, cfg, agent.ExporterOTLP, "", instance, tlsConfig)
+		if err != nil {
+			logger.Fatal("Init OTLP exporter error", zap.Error(err))
+		}
+		exporter = agent.MultiExporter{exporter, otlpExporter}
+	}
+
+	agentFile, err := config.LoadAgentFile(cfg.ConfigFile)
+	if err != nil {
+		logger.Fatal("Init agent config file error", zap.Error(err))
+	}
+
+	aggregation, err := agent.ParseAggregation(cfg.Aggregation)
+	if err != nil {
+		logger.Fatal("Init aggregation error", zap.Error(err))
+	}
 
 	memStorage := storage.NewMemStorage(logger)
 
-	stopCh := make(chan struct{})
+	limits := agent.BufferLimits{MaxMetrics: cfg.BufferMetrics, MaxBytes: cfg.BufferBytes, Policy: cfg.DropPolicy}
+	if err := limits.Validate(); err != nil {
+		logger.Fatal("Init buffer limits error", zap.Error(err))
+	}
 
-	agentStruct := agent.NewAgent(logger, memStorage, "http://"+cfg.Address+"/updates")
-	agentStruct.MetricAgent(time.Duration(cfg.ReportInterval), time.Duration(cfg.PollInterval), stopCh)
+	opts := []agent.Option{
+		agent.WithAggregation(aggregation),
+		agent.WithBufferLimits(limits),
+		agent.WithIntervals(time.Duration(cfg.PollInterval)*time.Second, time.Duration(cfg.ReportInterval)*time.Second),
+	}
+	collectorIntervals := make(map[string]time.Duration)
+	for name, collector := range agentFile.Collectors {
+		collectorIntervals[name] = time.Duration(collector.PollInterval) * time.Second
+	}
+	opts = append(opts, agent.WithCollectorIntervals(collectorIntervals))
+	collectors := []agent.Collector{agent.RuntimeCollector{}}
+	if len(agentFile.Probes.Checks) > 0 {
+		probes, err := buildProbes(agentFile.Probes)
+		if err != nil {
+			logger.Fatal("Init probes error", zap.Error(err))
+		}
+		collectors = append(collectors, probes)
+	}
+	if len(agentFile.Expvar.Targets) > 0 {
+		expvar, err := buildExpvar(agentFile.Expvar)
+		if err != nil {
+			logger.Fatal("Init expvar error", zap.Error(err))
+		}
+		collectors = append(collectors, expvar)
+	}
+	if len(agentFile.Postgres.Targets) > 0 {
+		targets := make([]agent.PostgresTarget, 0, len(agentFile.Postgres.Targets))
+		for _, t := range agentFile.Postgres.Targets {
+			targets = append(targets, agent.PostgresTarget{Name: t.Name, DSN: t.DSN})
+		}
+		postgres, err := agent.NewPostgresCollector(targets, time.Duration(agentFile.Postgres.Timeout)*time.Second)
+		if err != nil {
+			logger.Fatal("Init postgres collector error", zap.Error(err))
+		}
+		defer postgres.Close()
+		collectors = append(collectors, postgres)
+	}
+	if len(agentFile.Files.Watches) > 0 {
+		watches := make([]agent.FileWatch, 0, len(agentFile.Files.Watches))
+		for _, w := range agentFile.Files.Watches {
+			watches = append(watches, agent.FileWatch{Name: w.Name, Patterns: w.Paths})
+		}
+		files, err := agent.NewFileCollector(watches, agentFile.Files.Mode)
+		if err != nil {
+			logger.Fatal("Init file collector error", zap.Error(err))
+		}
+		defer files.Close()
+		collectors = append(collectors, files)
+	}
+	opts = append(opts, agent.WithCollectors(collectors...))
+	for _, p := range agentFile.Pipelines {
+		pipelineExporter, err := buildExporter(logger, cfg, p.Exporter, p.Format, instance, tlsConfig)
+		if err != nil {
+			logger.Fatal("Init pipeline exporter error", zap.String("pipeline", p.Name), zap.Error(err))
+		}
+		filter := agent.Filter{Include: p.Include, Exclude: p.Exclude}
+		opts = append(opts, agent.WithPipelines(agent.NewPipeline(logger, p.Name, time.Duration(p.ReportInterval)*time.Second, pipelineExporter, filter)))
+	}
+	if cfg.ConfigPoll > 0 {
+		remote := agent.NewRemoteConfigClient(logger, serverURL(cfg), instance, cfg.AgentGroup, tlsConfig)
+		opts = append(opts, agent.WithRemoteConfig(remote, time.Duration(cfg.ConfigPoll)*time.Second))
+	}
+
+	agentStruct := agent.NewAgent(logger, memStorage, exporter, opts...)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	if err := agentStruct.Run(ctx); err != nil {
+		logger.Error("Agent run error", zap.Error(err))
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := agentStruct.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Agent shutdown error", zap.Error(err))
+	}
+}
+
+func buildProbes(cfg config.ProbesConfig) (*agent.ProbeCollector, error) {
+	checks := make([]agent.ProbeCheck, 0, len(cfg.Checks))
+	for _, c := range cfg.Checks {
+		check, err := agent.NewProbeCheck(c.Name, c.Type, c.Target, c.Method, c.ExpectStatus, c.BodyRegex)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	return agent.NewProbeCollector(checks, time.Duration(cfg.Timeout)*time.Second, cfg.Concurrency), nil
+}
+
+func buildExpvar(cfg config.ExpvarConfig) (*agent.ExpvarCollector, error) {
+	targets := make([]agent.ExpvarTarget, 0, len(cfg.Targets))
+	for _, t := range cfg.Targets {
+		targets = append(targets, agent.ExpvarTarget{Name: t.Name, URL: t.URL})
+	}
+	filter := agent.Filter{Include: cfg.Include, Exclude: cfg.Exclude}
+	return agent.NewExpvarCollector(targets, filter, time.Duration(cfg.Timeout)*time.Second)
+}
+
+// buildExporter собирает цель отправки по спецификации: http, stdout, file:path
+// или otlp[:endpoint]. На сервер метрики уходят с ID по шаблону экземпляра,
+// в OTLP экземпляр передаётся атрибутом ресурса.
+func buildExporter(logger *zap.Logger, cfg config.Config, spec, format, instance string, tlsConfig *tls.Config) (agent.Exporter, error) {
+	if kind, endpoint, _ := strings.Cut(spec, ":"); kind == agent.ExporterOTLP {
+		if endpoint == "" {
+			endpoint = cfg.OTLPEndpoint
+		}
+		return agent.NewOTLPExporter(logger, endpoint, cfg.OTLPProtocol, cfg.OTLPTemporality, instance, tlsConfig, cfg.DryRun)
+	}
+
+	if format == "" {
+		format = cfg.ExportFormat
+	}
+	exporter, err := agent.NewExporter(logger, spec, format, serverURL(cfg)+"/updates", tlsConfig, cfg.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	return agent.NewNamedExporter(agent.NewNamer(instance, cfg.MetricTemplate), exporter), nil
+}
+
+func serverURL(cfg config.Config) string {
+	if strings.HasPrefix(cfg.Address, "http://") || strings.HasPrefix(cfg.Address, "https://") {
+		return strings.TrimSuffix(cfg.Address, "/")
+	}
+	if cfg.TLSEnabled() {
+		return "https://" + cfg.Address
+	}
+	return "http://" + cfg.Address
 }
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"github.com/WPGe/go-yandex-advanced/internal/service"
 	"github.com/WPGe/go-yandex-advanced/internal/utils"
 	"log"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -31,14 +34,37 @@ func TestAgent_MetricAgent(t *testing.T) {
 	server := httptest.NewServer(utils.WithGzip(handler.MetricUpdatesHandler(srv, logger)))
 	defer server.Close()
 
-	stopCh := make(chan struct{})
-	agentStruct := agent.NewAgent(logger, agentStorage, server.URL+"/updates")
-	go agentStruct.MetricAgent(10, 1, stopCh)
+	agentStruct := agent.NewAgent(logger, agentStorage, agent.NewHTTPExporter(logger, server.URL+"/updates", nil),
+		agent.WithIntervals(1*time.Second, 10*time.Second))
 
-	time.Sleep(2 * time.Second)
-	close(stopCh)
+	ctx, cancel := context.WithCancel(context.Background())
+	go agentStruct.Run(ctx)
 
 	time.Sleep(2 * time.Second)
+	cancel()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer shutdownCancel()
+	assert.NoError(t, agentStruct.Shutdown(shutdownCtx))
 
 	assert.Equal(t, agentStorage, serverStorage)
 }
+
+func TestWriterExporter_NDJSON(t *testing.T) {
+	value := 1.5
+	delta := int64(3)
+	metrics := []entity.Metric{
+		{ID: "Alloc", MType: entity.Gauge, Value: &value},
+		{ID: "PollCount", MType: entity.Counter, Delta: &delta},
+	}
+
+	var buf bytes.Buffer
+	exporter := agent.NewWriterExporter(&buf, agent.FormatNDJSON)
+	assert.NoError(t, exporter.Export(metrics))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, []string{
+		`{"id":"Alloc","type":"gauge","value":1.5}`,
+		`{"id":"PollCount","type":"counter","delta":3}`,
+	}, lines)
+}
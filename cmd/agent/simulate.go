@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/agent"
+	"github.com/WPGe/go-yandex-advanced/internal/config"
+	"github.com/WPGe/go-yandex-advanced/internal/storage"
+)
+
+// simulate запускает cfg.SimInstances виртуальных агентов с синтетическими
+// метриками через обычные экспортеры и печатает статистику отправки в конце.
+func simulate(ctx context.Context, logger *zap.Logger, cfg config.Config, instance string, tlsConfig *tls.Config) {
+	if cfg.SimDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.SimDuration)*time.Second)
+		defer cancel()
+	}
+
+	// Виртуальные экземпляры должны давать разные ряды на сервере, иначе нагрузка нереальна.
+	if !strings.Contains(cfg.MetricTemplate, "{instance}") {
+		cfg.MetricTemplate = "{instance}." + cfg.MetricTemplate
+	}
+
+	agents := make([]*agent.Agent, 0, cfg.SimInstances)
+	exporters := make([]*agent.StatsExporter, 0, cfg.SimInstances)
+	for i := 0; i < cfg.SimInstances; i++ {
+		collector, err := agent.NewSimulatedCollector(int64(i), cfg.SimGauges, cfg.SimCounters, cfg.SimGenerator)
+		if err != nil {
+			logger.Fatal("Init simulation error", zap.Error(err))
+		}
+		exporter, err := buildExporter(logger, cfg, cfg.Exporter, cfg.ExportFormat, fmt.Sprintf("%s-sim-%d", instance, i), tlsConfig)
+		if err != nil {
+			logger.Fatal("Init exporter error", zap.Error(err))
+		}
+		stats := agent.NewStatsExporter(exporter)
+		exporters = append(exporters, stats)
+
+		agents = append(agents, agent.NewAgent(logger, storage.NewMemStorage(logger), stats,
+			agent.WithCollectors(collector),
+			agent.WithIntervals(time.Duration(cfg.PollInterval)*time.Second, time.Duration(cfg.ReportInterval)*time.Second)))
+	}
+
+	logger.Info("Starting simulation", zap.Int("instances", cfg.SimInstances),
+		zap.Int("gauges", cfg.SimGauges), zap.Int("counters", cfg.SimCounters), zap.String("generator", cfg.SimGenerator))
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, a := range agents {
+		wg.Add(1)
+		go func(a *agent.Agent) {
+			defer wg.Done()
+			if err := a.Run(ctx); err != nil {
+				logger.Error("Simulated agent run error", zap.Error(err))
+			}
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := a.Shutdown(shutdownCtx); err != nil {
+				logger.Error("Simulated agent shutdown error", zap.Error(err))
+			}
+		}(a)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	var total agent.ExportStats
+	for _, e := range exporters {
+		stats := e.Stats()
+		total.Batches += stats.Batches
+		total.Metrics += stats.Metrics
+		total.Errors += stats.Errors
+		total.Latency += stats.Latency
+	}
+
+	var avgLatency time.Duration
+	if attempts := total.Batches + total.Errors; attempts > 0 {
+		avgLatency = total.Latency / time.Duration(attempts)
+	}
+	fmt.Fprintf(os.Stdout, "simulation: %d instances, %s\n", cfg.SimInstances, elapsed.Round(time.Millisecond))
+	fmt.Fprintf(os.Stdout, "batches: %d (%.1f/s), metrics: %d (%.1f/s)\n",
+		total.Batches, float64(total.Batches)/elapsed.Seconds(), total.Metrics, float64(total.Metrics)/elapsed.Seconds())
+	fmt.Fprintf(os.Stdout, "errors: %d, avg export latency: %s\n", total.Errors, avgLatency)
+}
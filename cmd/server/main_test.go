@@ -1,10 +1,25 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-resty/resty/v2"
@@ -12,9 +27,15 @@ import (
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 
+	"github.com/WPGe/go-yandex-advanced/internal/agent"
+	"github.com/WPGe/go-yandex-advanced/internal/application"
+	"github.com/WPGe/go-yandex-advanced/internal/config"
 	"github.com/WPGe/go-yandex-advanced/internal/entity"
 	"github.com/WPGe/go-yandex-advanced/internal/handler"
+	"github.com/WPGe/go-yandex-advanced/internal/ingest"
+	"github.com/WPGe/go-yandex-advanced/internal/service"
 	"github.com/WPGe/go-yandex-advanced/internal/storage"
+	"github.com/WPGe/go-yandex-advanced/internal/utils"
 )
 
 func float64Ptr(f float64) *float64 {
@@ -201,3 +222,910 @@ func TestMetricUpdateHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestHistogramMetric(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		log.Fatalf("can't initialize zap logger: %v", err)
+	}
+	logger.Sync()
+
+	srv := service.New(storage.NewMemStorage(logger), service.WithHistogramBounds([]float64{1, 2, 4}))
+	r := chi.NewRouter()
+	r.Post("/update/{type}/{name}/{value}", handler.MetricUpdateHandler(srv, logger))
+	r.Post("/updates/", handler.MetricUpdatesHandler(srv, logger))
+	r.Get("/value/{type}/{name}", handler.MetricGetHandler(srv, logger))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	client := resty.New()
+	for _, value := range []string{"0.5", "1.5", "3"} {
+		resp, err := client.R().Post(server.URL + "/update/histogram/latency/" + value)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+	}
+
+	resp, err := client.R().SetBody(`[{"id":"latency","type":"histogram","histogram":{"bounds":[1,2,4],"counts":[1,0,0,0],"sum":0.2,"count":1}}]`).
+		Post(server.URL + "/updates/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+
+	resp, err = client.R().SetBody(`[{"id":"latency","type":"histogram","histogram":{"bounds":[1,5],"counts":[1,0,0],"sum":0.2,"count":1}}]`).
+		Post(server.URL + "/updates/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+	for _, path := range []string{"/update/histogram/latency/NaN", "/update/summary/latency/Inf", "/update/gauge/Alloc/-Inf"} {
+		resp, err := client.R().Post(server.URL + path)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode(), path)
+	}
+	infinite := entity.NewHistogram([]float64{1, 2, 4})
+	infinite.Observe(math.Inf(1))
+	assert.ErrorIs(t, srv.AddMetric(entity.Metric{ID: "latency", MType: entity.Histogram, Histogram: infinite}), entity.ErrInvalidMetric)
+	sketch := entity.NewSummary(entity.DefaultSummaryAccuracy)
+	sketch.Observe(1)
+	sketch.Sum = math.NaN()
+	assert.ErrorIs(t, srv.AddMetrics([]entity.Metric{{ID: "latency", MType: entity.Summary, Summary: sketch}}), entity.ErrInvalidMetric)
+
+	resp, err = client.R().Get(server.URL + "/value/histogram/latency")
+	require.NoError(t, err)
+	assert.Equal(t, "count=4 sum=5.2 p50=1 p90=3.2 p99=3.92", string(resp.Body()))
+}
+
+func TestAddMetricsAtomic(t *testing.T) {
+	repo := storage.NewMemStorage(zap.NewNop())
+	histogram := entity.NewHistogram([]float64{1, 2})
+	histogram.Observe(1)
+	require.NoError(t, repo.AddMetrics([]entity.Metric{
+		{ID: "hits", MType: entity.Counter, Delta: int64Ptr(1)},
+		{ID: "latency", MType: entity.Histogram, Histogram: histogram},
+	}))
+
+	mismatched := entity.NewHistogram([]float64{1, 5})
+	mismatched.Observe(1)
+	err := repo.AddMetrics([]entity.Metric{
+		{ID: "hits", MType: entity.Counter, Delta: int64Ptr(2)},
+		{ID: "temp", MType: entity.Gauge, Value: float64Ptr(20)},
+		{ID: "latency", MType: entity.Histogram, Histogram: mismatched},
+	})
+	require.ErrorIs(t, err, entity.ErrInvalidMetric)
+
+	hits, err := repo.GetMetric("hits", entity.Counter)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *hits.Delta, "a rejected batch must not be applied partially")
+	_, err = repo.GetMetric("temp", entity.Gauge)
+	assert.Error(t, err)
+
+	require.NoError(t, repo.AddMetrics([]entity.Metric{
+		{ID: "hits", MType: entity.Counter, Delta: int64Ptr(2)},
+		{ID: "hits", MType: entity.Counter, Delta: int64Ptr(3)},
+	}))
+	hits, err = repo.GetMetric("hits", entity.Counter)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), *hits.Delta)
+}
+
+func TestSummaryMetric(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		log.Fatalf("can't initialize zap logger: %v", err)
+	}
+	logger.Sync()
+
+	srv := service.New(storage.NewMemStorage(logger))
+	r := chi.NewRouter()
+	r.Post("/updates/", handler.MetricUpdatesHandler(srv, logger))
+	r.Get("/value/{type}/{name}", handler.MetricGetHandler(srv, logger))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	// Два агента присылают скетчи по половине наблюдений, сервер их сливает.
+	for _, start := range []int{1, 501} {
+		sketch := entity.NewSummary(entity.DefaultSummaryAccuracy)
+		for v := start; v < start+500; v++ {
+			sketch.Observe(float64(v))
+		}
+		resp, err := resty.New().R().SetBody([]entity.Metric{{ID: "latency", MType: entity.Summary, Summary: sketch}}).
+			Post(server.URL + "/updates/")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+	}
+
+	resp, err := resty.New().R().Get(server.URL + "/value/summary/latency?q=0.99")
+	require.NoError(t, err)
+	p99, err := strconv.ParseFloat(string(resp.Body()), 64)
+	require.NoError(t, err)
+	assert.InEpsilon(t, 990.0, p99, entity.DefaultSummaryAccuracy)
+
+	stored, err := srv.GetMetric("latency", entity.Summary)
+	require.NoError(t, err)
+	data, err := stored.Summary.MarshalBinary()
+	require.NoError(t, err)
+	var decoded entity.SummaryValue
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, stored.Summary, &decoded)
+
+	tooWide := entity.NewSummary(entity.DefaultSummaryAccuracy)
+	for i := int32(0); i <= 2048; i++ {
+		tooWide.Positive[i] = 1
+	}
+	tooWide.Count, tooWide.Min, tooWide.Max = 2049, 1, 2
+	inverted := entity.NewSummary(entity.DefaultSummaryAccuracy)
+	inverted.Observe(1)
+	inverted.Min, inverted.Max = 5, 1
+	for name, sketch := range map[string]*entity.SummaryValue{"too many bins": tooWide, "min above max": inverted} {
+		resp, err := resty.New().R().SetBody([]entity.Metric{{ID: "latency", MType: entity.Summary, Summary: sketch}}).
+			Post(server.URL + "/updates/")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode(), name)
+	}
+	after, err := srv.GetMetric("latency", entity.Summary)
+	require.NoError(t, err)
+	assert.Equal(t, stored.Summary.Count, after.Summary.Count, "rejected sketches are not merged")
+}
+
+func TestSetMetric(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		log.Fatalf("can't initialize zap logger: %v", err)
+	}
+	logger.Sync()
+
+	repo := storage.NewMemStorage(logger)
+	srv := service.New(repo)
+	r := chi.NewRouter()
+	r.Post("/update/{type}/{name}/{value}", handler.MetricUpdateHandler(srv, logger))
+	r.Post("/updates/", handler.MetricUpdatesHandler(srv, logger))
+	r.Get("/value/{type}/{name}", handler.MetricGetHandler(srv, logger))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	// Агенты видят пересекающиеся множества пользователей: всего 15000 уникальных.
+	for _, start := range []int{0, 5000} {
+		elements := make([]string, 0, 10000)
+		for i := start; i < start+10000; i++ {
+			elements = append(elements, "user-"+strconv.Itoa(i))
+		}
+		resp, err := resty.New().R().SetBody([]entity.Metric{{ID: "users", MType: entity.Set, Elements: elements}}).
+			Post(server.URL + "/updates/")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+	}
+	resp, err := resty.New().R().Post(server.URL + "/update/set/users/user-0")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+
+	resp, err = resty.New().R().Get(server.URL + "/value/set/users")
+	require.NoError(t, err)
+	cardinality, err := strconv.ParseFloat(string(resp.Body()), 64)
+	require.NoError(t, err)
+	assert.InEpsilon(t, 15000.0, cardinality, 0.03)
+
+	// Снимок в файл и восстановление из него: скетч должен пережить их без потерь.
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, agent.SaveMetricsInFileAgent(repo, path, 1, ctx))
+
+	restored, err := storage.NewMemStorageFromFile(path, logger).GetMetric("users", entity.Set)
+	require.NoError(t, err)
+	saved, err := repo.GetMetric("users", entity.Set)
+	require.NoError(t, err)
+	assert.Equal(t, saved.Set.Cardinality(), restored.Set.Cardinality())
+}
+
+// connectTestDB подключается к TEST_DATABASE_DSN с миграциями или пропускает тест.
+func connectTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	// Миграции ищутся относительно корня репозитория.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(filepath.Join("..", "..")))
+	db, err := application.ConnectDB(&config.Config{DatabaseDSN: dsn})
+	require.NoError(t, os.Chdir(wd))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSketchDBStorage(t *testing.T) {
+	db := connectTestDB(t)
+
+	cleanup := func() {
+		_, err := db.Exec("DELETE FROM metrics WHERE id LIKE 'test.sketch.%'")
+		require.NoError(t, err)
+	}
+	cleanup()
+	defer cleanup()
+
+	srv := service.New(storage.NewDBStorage(zap.NewNop(), db))
+	for _, start := range []int{0, 500} {
+		elements := make([]string, 0, 1000)
+		summary := entity.NewSummary(entity.DefaultSummaryAccuracy)
+		for i := start; i < start+1000; i++ {
+			elements = append(elements, "user-"+strconv.Itoa(i))
+			summary.Observe(float64(i + 1))
+		}
+		require.NoError(t, srv.AddMetrics([]entity.Metric{
+			{ID: "test.sketch.users", MType: entity.Set, Elements: elements},
+			{ID: "test.sketch.latency", MType: entity.Summary, Summary: summary},
+		}))
+	}
+
+	users, err := srv.GetMetric("test.sketch.users", entity.Set)
+	require.NoError(t, err)
+	assert.InEpsilon(t, 1500.0, float64(users.Set.Cardinality()), 0.03)
+
+	latency, err := srv.GetMetric("test.sketch.latency", entity.Summary)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2000), latency.Summary.Count)
+	assert.InEpsilon(t, 750.0, latency.Summary.Quantile(0.5), 0.02)
+
+	// Повреждённую строку нельзя молча перезаписать входящим значением.
+	dbStorage := storage.NewDBStorage(zap.NewNop(), db)
+	corrupt := []struct {
+		metric entity.Metric
+		query  string
+	}{
+		{
+			metric: entity.Metric{ID: "test.sketch.corrupt.histogram", MType: entity.Histogram, Histogram: entity.NewHistogram([]float64{1})},
+			query:  `UPDATE metrics SET histogram = '{"bounds":"oops"}' WHERE id = $1`,
+		},
+		{
+			metric: entity.Metric{ID: "test.sketch.corrupt.summary", MType: entity.Summary, Summary: entity.NewSummary(entity.DefaultSummaryAccuracy)},
+			query:  `UPDATE metrics SET sketch = '\x00'::bytea WHERE id = $1`,
+		},
+		{
+			metric: entity.Metric{ID: "test.sketch.corrupt.set", MType: entity.Set, Set: entity.NewSet(entity.DefaultSetPrecision)},
+			query:  `UPDATE metrics SET sketch = '\x00'::bytea WHERE id = $1`,
+		},
+	}
+	for _, tt := range corrupt {
+		t.Run("corrupt "+tt.metric.MType, func(t *testing.T) {
+			require.NoError(t, dbStorage.AddMetric(tt.metric))
+			_, err := db.Exec(tt.query, tt.metric.ID)
+			require.NoError(t, err)
+
+			assert.Error(t, dbStorage.AddMetric(tt.metric), "stored value must not be overwritten")
+			_, err = dbStorage.GetMetric(tt.metric.ID, tt.metric.MType)
+			assert.Error(t, err)
+			_, err = dbStorage.GetAllMetrics()
+			assert.Error(t, err)
+			assert.Error(t, dbStorage.EachMetric(func(entity.Metric) error { return nil }))
+
+			_, err = db.Exec("DELETE FROM metrics WHERE id = $1", tt.metric.ID)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		log.Fatalf("can't initialize zap logger: %v", err)
+	}
+	logger.Sync()
+
+	srv := service.New(storage.NewMemStorage(logger), service.WithHistory(storage.NewMemHistory(3, 0)))
+	r := chi.NewRouter()
+	r.Post("/update/{type}/{name}/{value}", handler.MetricUpdateHandler(srv, logger))
+	r.Get("/history/{type}/{name}", handler.HistoryHandler(srv, logger))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	client := resty.New()
+	for _, update := range []string{"gauge/temp/1", "gauge/temp/2", "gauge/temp/3", "gauge/temp/4", "counter/hits/2", "counter/hits/5"} {
+		resp, err := client.R().Post(server.URL + "/update/" + update)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+	}
+
+	var samples []entity.Sample
+	resp, err := client.R().SetResult(&samples).Get(server.URL + "/history/gauge/temp")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+	require.Len(t, samples, 3)
+	assert.Equal(t, 2.0, *samples[0].Value)
+	assert.Equal(t, 4.0, *samples[2].Value)
+
+	from := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	resp, err = client.R().SetResult(&samples).Get(server.URL + "/history/gauge/temp?step=1h&from=" + from)
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, 3.0, *samples[0].Value)
+
+	resp, err = client.R().SetResult(&samples).Get(server.URL + "/history/counter/hits?step=1h&from=" + from)
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, int64(7), *samples[0].Delta)
+
+	resp, err = client.R().Get(server.URL + "/history/gauge/temp?step=oops")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+	t.Run("compact drops idle series", func(t *testing.T) {
+		history := storage.NewMemHistory(3, time.Hour)
+		now := time.Now()
+		history.Record([]entity.Sample{{ID: "temp", MType: entity.Gauge, Timestamp: now, Value: float64Ptr(1)}})
+		require.NoError(t, history.Compact(now.Add(30*time.Minute)))
+		kept, err := history.Samples("temp", entity.Gauge, now.Add(-time.Minute), now.Add(time.Minute))
+		require.NoError(t, err)
+		assert.Len(t, kept, 1)
+
+		require.NoError(t, history.Compact(now.Add(2*time.Hour)))
+		dropped, err := history.Samples("temp", entity.Gauge, now.Add(-time.Minute), now.Add(time.Minute))
+		require.NoError(t, err)
+		assert.Empty(t, dropped)
+	})
+
+	t.Run("idle series are evicted", func(t *testing.T) {
+		history := storage.NewMemHistory(3, 50*time.Millisecond)
+		now := time.Now()
+		history.Record([]entity.Sample{{ID: "old", MType: entity.Gauge, Timestamp: now, Value: float64Ptr(1)}})
+		time.Sleep(60 * time.Millisecond)
+		history.Record([]entity.Sample{{ID: "new", MType: entity.Gauge, Timestamp: now, Value: float64Ptr(2)}})
+
+		old, err := history.Samples("old", entity.Gauge, now.Add(-time.Minute), now.Add(time.Minute))
+		require.NoError(t, err)
+		assert.Empty(t, old)
+		fresh, err := history.Samples("new", entity.Gauge, now.Add(-time.Minute), now.Add(time.Minute))
+		require.NoError(t, err)
+		assert.Len(t, fresh, 1)
+	})
+}
+
+func TestDBHistory(t *testing.T) {
+	db := connectTestDB(t)
+	cleanup := func() {
+		_, err := db.Exec("DELETE FROM metric_samples WHERE id LIKE 'test.history.%'")
+		require.NoError(t, err)
+	}
+	cleanup()
+	defer cleanup()
+
+	history := storage.NewDBHistory(zap.NewNop(), db, time.Hour, 2)
+	now := time.Now().Truncate(time.Second)
+	var samples []entity.Sample
+	for i := 4; i >= 0; i-- {
+		samples = append(samples, entity.Sample{ID: "test.history.temp", MType: entity.Gauge, Timestamp: now.Add(-time.Duration(i) * time.Hour), Value: float64Ptr(float64(i))})
+	}
+	history.Record(samples)
+
+	latest, err := history.Samples("test.history.temp", entity.Gauge, now.Add(-5*time.Hour), now)
+	require.NoError(t, err)
+	require.Len(t, latest, 2, "query is capped at the limit")
+	assert.Equal(t, 1.0, *latest[0].Value, "latest samples in time order")
+	assert.Equal(t, 0.0, *latest[1].Value)
+
+	require.NoError(t, history.Compact(now))
+	var left int
+	require.NoError(t, db.QueryRow("SELECT count(*) FROM metric_samples WHERE id = 'test.history.temp'").Scan(&left))
+	assert.Equal(t, 2, left, "samples older than retention are deleted")
+}
+
+func TestRollups(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		log.Fatalf("can't initialize zap logger: %v", err)
+	}
+	logger.Sync()
+
+	tiers, err := entity.ParseRollupTiers("1h:24h,1m:2h")
+	require.NoError(t, err)
+	for _, invalid := range []string{"500ms:1h", "1500ms:1h", "1m:1m,1m:2m", "1m"} {
+		_, err := entity.ParseRollupTiers(invalid)
+		assert.Error(t, err, invalid)
+	}
+	srv := service.New(storage.NewMemStorage(logger), service.WithRollups(storage.NewMemRollups(tiers)))
+	r := chi.NewRouter()
+	r.Post("/update/{type}/{name}/{value}", handler.MetricUpdateHandler(srv, logger))
+	r.Get("/rollups/{type}/{name}", handler.RollupsHandler(srv, logger))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	client := resty.New()
+	for _, update := range []string{"gauge/temp/1", "gauge/temp/5", "gauge/temp/3", "counter/hits/2", "counter/hits/5"} {
+		resp, err := client.R().Post(server.URL + "/update/" + update)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+	}
+
+	var result struct {
+		Resolution string          `json:"resolution"`
+		Points     []entity.Rollup `json:"points"`
+	}
+	resp, err := client.R().SetResult(&result).Get(server.URL + "/rollups/gauge/temp")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "1m0s", result.Resolution)
+	require.NotEmpty(t, result.Points)
+	var count int64
+	min, max := *result.Points[0].Min, *result.Points[0].Max
+	for _, p := range result.Points {
+		count += p.Count
+		min = math.Min(min, *p.Min)
+		max = math.Max(max, *p.Max)
+	}
+	assert.Equal(t, int64(3), count)
+	assert.Equal(t, 1.0, min)
+	assert.Equal(t, 5.0, max)
+	assert.Equal(t, 3.0, *result.Points[len(result.Points)-1].Last)
+
+	from := strconv.FormatInt(time.Now().Add(-12*time.Hour).Unix(), 10)
+	resp, err = client.R().SetResult(&result).Get(server.URL + "/rollups/counter/hits?from=" + from)
+	require.NoError(t, err)
+	assert.Equal(t, "1h0m0s", result.Resolution)
+	var sum int64
+	for _, p := range result.Points {
+		sum += *p.Sum
+	}
+	assert.Equal(t, int64(7), sum)
+
+	resp, err = client.R().Get(server.URL + "/rollups/gauge/temp?resolution=5m")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+	require.NoError(t, srv.CompactRollups(time.Now().Add(3*time.Hour)))
+	resp, err = client.R().SetResult(&result).Get(server.URL + "/rollups/gauge/temp?resolution=1m")
+	require.NoError(t, err)
+	assert.Empty(t, result.Points)
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		log.Fatalf("can't initialize zap logger: %v", err)
+	}
+	logger.Sync()
+
+	srv := service.New(storage.NewMemStorage(logger))
+	metadata := map[string]entity.MetricMetadata{
+		"Alloc":    {Help: "Allocated heap\nobjects", Unit: "bytes"},
+		"requests": {Help: "Handled requests"},
+	}
+	r := chi.NewRouter()
+	r.Post("/update/{type}/{name}/{value}", handler.MetricUpdateHandler(srv, logger))
+	r.Get("/metrics", utils.WithGzip(handler.PrometheusHandler(srv, metadata, logger)))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	client := resty.New()
+	for _, update := range []string{"gauge/Alloc/1024", "counter/requests/3", "gauge/1st.load-avg/0.5", "set/visitors/alice", "set/visitors/bob"} {
+		resp, err := client.R().Post(server.URL + "/update/" + update)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+	}
+
+	resp, err := client.R().SetHeader("Accept", "text/plain;version=0.0.4").Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", resp.Header().Get("Content-Type"))
+	assert.Equal(t, `# TYPE _1st_load_avg gauge
+_1st_load_avg 0.5
+# HELP Alloc_bytes Allocated heap\nobjects
+# TYPE Alloc_bytes gauge
+Alloc_bytes 1024
+# HELP requests_total Handled requests
+# TYPE requests_total counter
+requests_total 3
+# TYPE visitors gauge
+visitors 2
+`, string(resp.Body()))
+
+	resp, err = client.R().SetHeader("Accept", "application/openmetrics-text;version=1.0.0").Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	body := string(resp.Body())
+	assert.Contains(t, body, "# TYPE Alloc_bytes gauge\n# UNIT Alloc_bytes bytes\nAlloc_bytes 1024\n")
+	assert.Contains(t, body, "# TYPE requests counter\nrequests_total 3\n")
+	assert.True(t, strings.HasSuffix(body, "# EOF\n"))
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/metrics", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/plain")
+	req.Header.Set("Accept-Encoding", "gzip")
+	raw, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer raw.Body.Close()
+	assert.Equal(t, "gzip", raw.Header.Get("Content-Encoding"))
+	zr, err := gzip.NewReader(raw.Body)
+	require.NoError(t, err)
+	unzipped, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Contains(t, string(unzipped), "requests_total 3\n")
+
+	t.Run("scrape while updating", func(t *testing.T) {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				srv.AddMetrics([]entity.Metric{
+					{ID: "hot." + strconv.Itoa(i%20), MType: entity.Gauge, Value: float64Ptr(float64(i))},
+					{ID: "visitors", MType: entity.Set, Elements: []string{strconv.Itoa(i)}},
+				})
+			}
+		}()
+		for i := 0; i < 20; i++ {
+			resp, err := client.R().Get(server.URL + "/metrics")
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode())
+		}
+		wg.Wait()
+
+		all, err := srv.GetAllMetrics()
+		require.NoError(t, err)
+		*all[entity.Gauge]["Alloc"].Value = 0
+		stored, err := srv.GetMetric("Alloc", entity.Gauge)
+		require.NoError(t, err)
+		assert.Equal(t, 1024.0, *stored.Value, "GetAllMetrics must return a copy")
+	})
+}
+
+func TestRemoteWrite(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		log.Fatalf("can't initialize zap logger: %v", err)
+	}
+	logger.Sync()
+
+	srv := service.New(storage.NewMemStorage(logger))
+	r := chi.NewRouter()
+	r.Post("/api/v1/write", handler.RemoteWriteHandler(srv, ingest.NewRemoteWrite(), logger))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	write := func(body []byte) int {
+		resp, err := resty.New().R().
+			SetHeader("Content-Encoding", "snappy").
+			SetHeader("Content-Type", "application/x-protobuf").
+			SetBody(body).
+			Post(server.URL + "/api/v1/write")
+		require.NoError(t, err)
+		return resp.StatusCode()
+	}
+
+	first := promWriteRequest(
+		[]promTestSeries{
+			{labels: []string{"__name__", "http_requests_total", "method", "get", "code", "200"}, values: []float64{10, 15}},
+			{labels: []string{"__name__", "temperature", "room", "a"}, values: []float64{20.5}},
+			{labels: []string{"__name__", "jobs", "queue", "x"}, values: []float64{3}},
+		},
+		map[string]int{"jobs": 1},
+	)
+	require.Equal(t, http.StatusNoContent, write(snappyLiteral(first)))
+
+	second := promWriteRequest(
+		[]promTestSeries{
+			{labels: []string{"__name__", "http_requests_total", "method", "get", "code", "200"}, values: []float64{4}},
+			{labels: []string{"__name__", "jobs", "queue", "x"}, values: []float64{7}},
+		},
+		nil,
+	)
+	require.Equal(t, http.StatusNoContent, write(snappyLiteral(second)))
+
+	requests, err := srv.GetMetric("http_requests_total;code=200;method=get", entity.Counter)
+	require.NoError(t, err)
+	assert.Equal(t, int64(9), *requests.Delta)
+	temperature, err := srv.GetMetric("temperature;room=a", entity.Gauge)
+	require.NoError(t, err)
+	assert.Equal(t, 20.5, *temperature.Value)
+	jobs, err := srv.GetMetric("jobs;queue=x", entity.Counter)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), *jobs.Delta)
+
+	assert.Equal(t, http.StatusBadRequest, write([]byte("not snappy")))
+}
+
+type promTestSeries struct {
+	labels []string
+	values []float64
+}
+
+func promWriteRequest(series []promTestSeries, metadata map[string]int) []byte {
+	appendBytes := func(b []byte, field int, v []byte) []byte {
+		b = binary.AppendUvarint(b, uint64(field)<<3|2)
+		b = binary.AppendUvarint(b, uint64(len(v)))
+		return append(b, v...)
+	}
+
+	var req []byte
+	for _, s := range series {
+		var ts []byte
+		for i := 0; i < len(s.labels); i += 2 {
+			label := appendBytes(appendBytes(nil, 1, []byte(s.labels[i])), 2, []byte(s.labels[i+1]))
+			ts = appendBytes(ts, 1, label)
+		}
+		for i, v := range s.values {
+			sample := []byte{1<<3 | 1}
+			sample = binary.LittleEndian.AppendUint64(sample, math.Float64bits(v))
+			sample = binary.AppendUvarint(append(sample, 2<<3), uint64(1000+i))
+			ts = appendBytes(ts, 2, sample)
+		}
+		req = appendBytes(req, 1, ts)
+	}
+	for family, t := range metadata {
+		md := binary.AppendUvarint([]byte{1 << 3}, uint64(t))
+		req = appendBytes(req, 3, appendBytes(md, 2, []byte(family)))
+	}
+	return req
+}
+
+// snappyLiteral упаковывает данные в блок snappy одним литералом.
+func snappyLiteral(data []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(len(data)))
+	n := len(data) - 1
+	b = append(b, 61<<2, byte(n), byte(n>>8))
+	return append(b, data...)
+}
+
+func TestGraphiteListener(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		log.Fatalf("can't initialize zap logger: %v", err)
+	}
+	logger.Sync()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	srv := service.New(storage.NewMemStorage(logger))
+	graphite, err := ingest.NewGraphite(logger, srv, addr, []string{"stats.counts.*"}, 2)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- graphite.Run(ctx) }()
+
+	var conn net.Conn
+	require.Eventually(t, func() bool {
+		conn, err = net.Dial("tcp", addr)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = fmt.Fprintf(conn, "servers.web1.cpu 42.5 1700000000\nstats.counts.hits 3 1700000000\nstats.counts.hits 2\ngarbage\n%s\nservers.web1.cpu 43 -1\n", strings.Repeat("a", 5000))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	udp, err := net.Dial("udp", addr)
+	require.NoError(t, err)
+	_, err = udp.Write([]byte("servers.web2.cpu 1\nbad value here now\n"))
+	require.NoError(t, err)
+	require.NoError(t, udp.Close())
+
+	require.Eventually(t, func() bool {
+		malformed, err := srv.GetMetric(ingest.GraphiteMalformedID, entity.Counter)
+		if err != nil || *malformed.Delta != 3 {
+			return false
+		}
+		_, err = srv.GetMetric("servers.web2.cpu", entity.Gauge)
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	cpu, err := srv.GetMetric("servers.web1.cpu", entity.Gauge)
+	require.NoError(t, err)
+	assert.Equal(t, 43.0, *cpu.Value)
+	hits, err := srv.GetMetric("stats.counts.hits", entity.Counter)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *hits.Delta)
+}
+
+func TestInfluxWrite(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		log.Fatalf("can't initialize zap logger: %v", err)
+	}
+	logger.Sync()
+
+	srv := service.New(storage.NewMemStorage(logger))
+	influx, err := ingest.NewInflux(ingest.InfluxIntegersCounter)
+	require.NoError(t, err)
+	r := chi.NewRouter()
+	r.Post("/write", handler.InfluxWriteHandler(srv, influx, logger))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	write := func(body string) int {
+		resp, err := resty.New().R().SetBody(body).Post(server.URL + "/write")
+		require.NoError(t, err)
+		return resp.StatusCode()
+	}
+
+	require.Equal(t, http.StatusNoContent, write(`cpu,host=web1,region=eu usage_idle=92.5,usage_user=3i 1700000000000000000
+net,host=web1 bytes_recv=1000i,status="ok, fine",up=true
+# comment
+weather,location=us\ midwest temperature=82
+`))
+	require.Equal(t, http.StatusNoContent, write("net,host=web1 bytes_recv=1500i\n"))
+
+	idle, err := srv.GetMetric("cpu.usage_idle;host=web1;region=eu", entity.Gauge)
+	require.NoError(t, err)
+	assert.Equal(t, 92.5, *idle.Value)
+	recv, err := srv.GetMetric("net.bytes_recv;host=web1", entity.Counter)
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), *recv.Delta)
+	up, err := srv.GetMetric("net.up;host=web1", entity.Gauge)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, *up.Value)
+	temperature, err := srv.GetMetric("weather.temperature;location=us midwest", entity.Gauge)
+	require.NoError(t, err)
+	assert.Equal(t, 82.0, *temperature.Value)
+	all, err := srv.GetAllMetrics()
+	require.NoError(t, err)
+	assert.NotContains(t, all[entity.Gauge], "net.status;host=web1")
+
+	require.Equal(t, http.StatusBadRequest, write("disk,host=a free=1\ncpu usage=abc\n"))
+	all, err = srv.GetAllMetrics()
+	require.NoError(t, err)
+	assert.NotContains(t, all[entity.Gauge], "disk.free;host=a")
+}
+
+func TestAgentConfigs(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		log.Fatalf("can't initialize zap logger: %v", err)
+	}
+	logger.Sync()
+
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	profiles := []entity.AgentProfile{
+		{Name: "default", Config: entity.AgentConfig{PollInterval: 10}},
+		{Name: "db", Groups: []string{"db"}, Config: entity.AgentConfig{PollInterval: 5}},
+		{Name: "host-1", Instances: []string{"host-1"}, Groups: []string{"db"}, Config: entity.AgentConfig{PollInterval: 1}},
+	}
+	data, err := json.Marshal(profiles)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	configs, err := storage.NewAgentConfigStorage(path, logger)
+	require.NoError(t, err)
+	r := chi.NewRouter()
+	r.Get("/agents/config", handler.AgentConfigHandler(configs, logger))
+	r.Post("/agents/config/applied", handler.AgentConfigAppliedHandler(configs, logger))
+	r.Get("/agents/status", handler.AgentConfigStatusHandler(configs, logger))
+	r.Get("/agents/profiles", handler.AgentProfilesHandler(configs, logger))
+	r.Put("/agents/profiles/{name}", handler.AgentProfileSaveHandler(configs, logger))
+	server := httptest.NewServer(r)
+	defer server.Close()
+	client := resty.New()
+
+	t.Run("profile selection", func(t *testing.T) {
+		tests := []struct {
+			instance string
+			group    string
+			want     int
+		}{
+			{instance: "host-1", group: "web", want: 1},
+			{instance: "host-2", group: "db", want: 5},
+			{instance: "host-3", group: "web", want: 10},
+			{want: 10},
+		}
+		for _, tt := range tests {
+			var cfg entity.AgentConfig
+			resp, err := client.R().
+				SetQueryParams(map[string]string{"instance": tt.instance, "group": tt.group}).
+				SetResult(&cfg).
+				Get(server.URL + "/agents/config")
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode())
+			assert.Equal(t, tt.want, cfg.PollInterval, "instance %q group %q", tt.instance, tt.group)
+			assert.Equal(t, `"`+cfg.Version+`"`, resp.Header().Get("ETag"))
+		}
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		resp, err := client.R().SetQueryParam("instance", "host-1").Get(server.URL + "/agents/config")
+		require.NoError(t, err)
+		etag := resp.Header().Get("ETag")
+
+		resp, err = client.R().SetQueryParam("instance", "host-1").SetHeader("If-None-Match", etag).Get(server.URL + "/agents/config")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode())
+	})
+
+	t.Run("save profile", func(t *testing.T) {
+		var saved entity.AgentProfile
+		resp, err := client.R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(`{"name":"ignored","groups":["web"],"config":{"poll_interval":7}}`).
+			SetResult(&saved).
+			Put(server.URL + "/agents/profiles/web")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Equal(t, "web", saved.Name, "name comes from the path")
+		assert.NotEmpty(t, saved.Config.Version)
+
+		var cfg entity.AgentConfig
+		_, err = client.R().SetQueryParams(map[string]string{"instance": "host-3", "group": "web"}).SetResult(&cfg).Get(server.URL + "/agents/config")
+		require.NoError(t, err)
+		assert.Equal(t, 7, cfg.PollInterval)
+
+		var listed []entity.AgentProfile
+		_, err = client.R().SetResult(&listed).Get(server.URL + "/agents/profiles")
+		require.NoError(t, err)
+		require.Len(t, listed, 4)
+		assert.Equal(t, "web", listed[3].Name)
+
+		reloaded, err := storage.NewAgentConfigStorage(path, logger)
+		require.NoError(t, err)
+		persisted, err := reloaded.Profiles()
+		require.NoError(t, err)
+		assert.Equal(t, listed, persisted)
+
+		resp, err = client.R().SetBody(`{`).Put(server.URL + "/agents/profiles/web")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+		for _, body := range []string{
+			`{"config":{"aggregation":{"runtime":["median"]}}}`,
+			`{"config":{"poll_interval":-1}}`,
+			`{"config":{"report_interval":-5}}`,
+			`{"config":{"collector_intervals":{"runtime":-2}}}`,
+		} {
+			resp, err = client.R().SetBody(body).Put(server.URL + "/agents/profiles/web")
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode(), body)
+		}
+		_, err = client.R().SetResult(&listed).Get(server.URL + "/agents/profiles")
+		require.NoError(t, err)
+		assert.Equal(t, 7, listed[3].Config.PollInterval, "rejected profiles are not stored")
+	})
+
+	t.Run("applied status", func(t *testing.T) {
+		resp, err := client.R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(`{"instance":"host-1","version":"abc"}`).
+			Post(server.URL + "/agents/config/applied")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+
+		resp, err = client.R().SetBody(`{"version":"abc"}`).Post(server.URL + "/agents/config/applied")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+		var statuses []entity.AgentConfigStatus
+		_, err = client.R().SetResult(&statuses).Get(server.URL + "/agents/status")
+		require.NoError(t, err)
+		require.Len(t, statuses, 1)
+		assert.Equal(t, "host-1", statuses[0].Instance)
+		assert.Equal(t, "abc", statuses[0].Version)
+	})
+
+	t.Run("failed persist keeps profiles", func(t *testing.T) {
+		configs, err := storage.NewAgentConfigStorage(filepath.Join(t.TempDir(), "missing", "profiles.json"), logger)
+		require.NoError(t, err)
+		_, err = configs.SaveProfile(entity.AgentProfile{Name: "default"})
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, entity.ErrInvalidAgentProfile)
+
+		_, err = configs.AgentConfig("host-1", "")
+		assert.ErrorIs(t, err, storage.ErrAgentConfigNotFound)
+
+		r := chi.NewRouter()
+		r.Put("/agents/profiles/{name}", handler.AgentProfileSaveHandler(configs, logger))
+		server := httptest.NewServer(r)
+		defer server.Close()
+		resp, err := client.R().SetBody(`{"config":{"poll_interval":1}}`).Put(server.URL + "/agents/profiles/default")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode(), "write errors are server errors")
+	})
+
+	t.Run("no profile", func(t *testing.T) {
+		configs, err := storage.NewAgentConfigStorage("", logger)
+		require.NoError(t, err)
+		server := httptest.NewServer(handler.AgentConfigHandler(configs, logger))
+		defer server.Close()
+
+		resp, err := client.R().Get(server.URL)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+	})
+}
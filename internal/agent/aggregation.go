@@ -0,0 +1,202 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+const (
+	AggregateLast  = entity.AggregateLast
+	AggregateMin   = entity.AggregateMin
+	AggregateMax   = entity.AggregateMax
+	AggregateAvg   = entity.AggregateAvg
+	AggregateCount = entity.AggregateCount
+)
+
+// ParseAggregation разбирает строку вида "runtime=min,max,avg;expvar=last"
+// в режимы агрегации по имени коллектора.
+func ParseAggregation(spec string) (map[string][]string, error) {
+	modes := make(map[string][]string)
+	if strings.TrimSpace(spec) == "" {
+		return modes, nil
+	}
+
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, list, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid aggregation: %s", part)
+		}
+		for _, mode := range strings.Split(list, ",") {
+			modes[name] = append(modes[name], strings.TrimSpace(mode))
+		}
+	}
+
+	if err := entity.ValidateAggregation(modes); err != nil {
+		return nil, err
+	}
+	return modes, nil
+}
+
+type gaugeWindow struct {
+	min   float64
+	max   float64
+	sum   float64
+	last  float64
+	count int64
+	modes []string
+}
+
+// merge добавляет к окну более позднее окно other.
+func (w *gaugeWindow) merge(other *gaugeWindow) {
+	if other.min < w.min {
+		w.min = other.min
+	}
+	if other.max > w.max {
+		w.max = other.max
+	}
+	w.sum += other.sum
+	w.count += other.count
+	w.last = other.last
+	w.modes = other.modes
+}
+
+// aggregator копит значения гейджей между отправками, чтобы не терять
+// промежуточные опросы: Gauge в MemStorage перезаписывается при каждом опросе.
+// Окно, ушедшее в неудачную отправку, остаётся в pending и сливается со
+// следующим, пока commit не подтвердит отправку.
+type aggregator struct {
+	mu      sync.Mutex
+	windows map[string]*gaugeWindow
+	pending map[string]*gaugeWindow
+}
+
+func newAggregator() *aggregator {
+	return &aggregator{
+		windows: make(map[string]*gaugeWindow),
+		pending: make(map[string]*gaugeWindow),
+	}
+}
+
+func (g *aggregator) observe(id string, value float64, modes []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	w, ok := g.windows[id]
+	if !ok {
+		g.windows[id] = &gaugeWindow{
+			min:   value,
+			max:   value,
+			sum:   value,
+			last:  value,
+			count: 1,
+			modes: modes,
+		}
+		return
+	}
+
+	if value < w.min {
+		w.min = value
+	}
+	if value > w.max {
+		w.max = value
+	}
+	w.sum += value
+	w.last = value
+	w.count++
+	w.modes = modes
+}
+
+// drop убирает из окна режим, который порождает метрику с ID id.
+// Окно без режимов удаляется целиком.
+func (g *aggregator) drop(id string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, windows := range []map[string]*gaugeWindow{g.windows, g.pending} {
+		for windowID, w := range windows {
+			modes := w.modes[:0:0]
+			for _, mode := range w.modes {
+				if aggregatedID(windowID, mode) != id {
+					modes = append(modes, mode)
+				}
+			}
+			if len(modes) == 0 {
+				delete(windows, windowID)
+				continue
+			}
+			w.modes = modes
+		}
+	}
+}
+
+// aggregatedID — ID метрики режима mode: last отдаётся под исходным ID,
+// остальные режимы — с суффиксом: HeapAlloc.max.
+func aggregatedID(id, mode string) string {
+	if mode == AggregateLast {
+		return id
+	}
+	return id + "." + mode
+}
+
+// flush сливает текущее окно с неподтверждённым, возвращает производные
+// метрики за оба и начинает новое окно.
+func (g *aggregator) flush() []entity.Metric {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for id, w := range g.windows {
+		if p, ok := g.pending[id]; ok {
+			p.merge(w)
+			continue
+		}
+		g.pending[id] = w
+	}
+	g.windows = make(map[string]*gaugeWindow)
+
+	ids := make([]string, 0, len(g.pending))
+	for id := range g.pending {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var metrics []entity.Metric
+	for _, id := range ids {
+		w := g.pending[id]
+		for _, mode := range w.modes {
+			var value float64
+			switch mode {
+			case AggregateLast:
+				value = w.last
+			case AggregateMin:
+				value = w.min
+			case AggregateMax:
+				value = w.max
+			case AggregateAvg:
+				value = w.sum / float64(w.count)
+			case AggregateCount:
+				value = float64(w.count)
+			default:
+				continue
+			}
+			metrics = append(metrics, gaugeMetric(aggregatedID(id, mode), value))
+		}
+	}
+
+	return metrics
+}
+
+// commit забывает окна, отданные flush: их метрики приняты всеми экспортерами.
+func (g *aggregator) commit() {
+	g.mu.Lock()
+	g.pending = make(map[string]*gaugeWindow)
+	g.mu.Unlock()
+}
@@ -0,0 +1,138 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+func TestParseAggregation(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    map[string][]string
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			spec: "  ",
+			want: map[string][]string{},
+		},
+		{
+			name: "several collectors",
+			spec: "runtime=min, max,avg; expvar=last;",
+			want: map[string][]string{
+				"runtime": {AggregateMin, AggregateMax, AggregateAvg},
+				"expvar":  {AggregateLast},
+			},
+		},
+		{
+			name: "repeated collector",
+			spec: "runtime=min;runtime=count",
+			want: map[string][]string{
+				"runtime": {AggregateMin, AggregateCount},
+			},
+		},
+		{
+			name:    "missing equals",
+			spec:    "runtime",
+			wantErr: true,
+		},
+		{
+			name:    "missing name",
+			spec:    "=min",
+			wantErr: true,
+		},
+		{
+			name:    "unknown mode",
+			spec:    "runtime=median",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAggregation(tt.spec)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAggregatorFlush(t *testing.T) {
+	g := newAggregator()
+	all := []string{AggregateLast, AggregateMin, AggregateMax, AggregateAvg, AggregateCount}
+	for _, v := range []float64{4, 1, 7, 2} {
+		g.observe("HeapAlloc", v, all)
+	}
+	g.observe("NumGC", 3, []string{AggregateMax})
+	g.observe("Removed", 5, []string{AggregateLast, AggregateMax})
+	g.drop("Removed")
+	g.drop("Removed.max")
+	g.observe("Partial", 2, []string{AggregateMin, AggregateMax})
+	g.drop("Partial.min")
+
+	values := make(map[string]float64)
+	for _, m := range g.flush() {
+		require.Equal(t, entity.Gauge, m.MType)
+		require.NotNil(t, m.Value)
+		values[m.ID] = *m.Value
+	}
+	assert.Equal(t, map[string]float64{
+		"HeapAlloc":       2,
+		"HeapAlloc.min":   1,
+		"HeapAlloc.max":   7,
+		"HeapAlloc.avg":   3.5,
+		"HeapAlloc.count": 4,
+		"NumGC.max":       3,
+		"Partial.max":     2,
+	}, values)
+
+	g.commit()
+	assert.Empty(t, g.flush(), "flush must start a new window")
+
+	g.observe("HeapAlloc", 10, []string{AggregateAvg})
+	metrics := g.flush()
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "HeapAlloc.avg", metrics[0].ID)
+	assert.Equal(t, 10.0, *metrics[0].Value)
+}
+
+func TestAggregatorPending(t *testing.T) {
+	g := newAggregator()
+	all := []string{AggregateMin, AggregateMax, AggregateAvg, AggregateCount}
+	for _, v := range []float64{5, 100} {
+		g.observe("HeapAlloc", v, all)
+	}
+	g.flush() // отправка не удалась, commit не вызван
+
+	for _, v := range []float64{3, 4} {
+		g.observe("HeapAlloc", v, all)
+	}
+	values := make(map[string]float64)
+	for _, m := range g.flush() {
+		values[m.ID] = *m.Value
+	}
+	assert.Equal(t, map[string]float64{
+		"HeapAlloc.min":   3,
+		"HeapAlloc.max":   100,
+		"HeapAlloc.avg":   28,
+		"HeapAlloc.count": 4,
+	}, values)
+
+	g.commit()
+	g.observe("HeapAlloc", 1, all)
+	values = make(map[string]float64)
+	for _, m := range g.flush() {
+		values[m.ID] = *m.Value
+	}
+	assert.Equal(t, 1.0, values["HeapAlloc.max"])
+	assert.Equal(t, 1.0, values["HeapAlloc.count"])
+}
@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+const (
+	DropNewest      = "newest"
+	DropOldest      = "oldest"
+	DropGaugesFirst = "gauges-first"
+
+	// DroppedMetricsID — счётчик метрик, которые агент выбросил из-за лимитов буфера.
+	DroppedMetricsID = "AgentDroppedMetrics"
+
+	// metricOverhead — примерный размер метрики в буфере помимо ID и типа.
+	metricOverhead = 48
+)
+
+// BufferLimits ограничивает буфер конвейера по числу разных метрик и по байтам.
+// Нулевой лимит означает отсутствие ограничения.
+type BufferLimits struct {
+	MaxMetrics int
+	MaxBytes   int
+	Policy     string
+}
+
+func (l BufferLimits) Validate() error {
+	switch l.Policy {
+	case "", DropNewest, DropOldest, DropGaugesFirst:
+		return nil
+	default:
+		return fmt.Errorf("unknown drop policy: %s", l.Policy)
+	}
+}
+
+func (l BufferLimits) enabled() bool {
+	return l.MaxMetrics > 0 || l.MaxBytes > 0
+}
+
+type metricKey struct {
+	mType string
+	id    string
+}
+
+// buffer ведёт учёт метрик в буфере конвейера в порядке появления,
+// чтобы при переполнении выбрать, что выбросить. Учитывается каждая метрика,
+// которая уйдёт при отправке, в том числе все производные агрегированного
+// гейджа. Место под счётчик AgentDroppedMetrics занято всегда, чтобы сообщить
+// о потерях можно было и при полном буфере.
+type buffer struct {
+	limits  BufferLimits
+	entries map[metricKey]int
+	order   []metricKey
+	bytes   int
+	dropped int64
+}
+
+func newBuffer() *buffer {
+	return &buffer{
+		entries: make(map[metricKey]int),
+	}
+}
+
+// reserve учитывает метрики keys, которые породит одно наблюдение: все или
+// ни одной. Если места нет, по политике выбрасываются старые метрики через
+// evict или отклоняется само наблюдение.
+func (b *buffer) reserve(keys []metricKey, evict func(key metricKey)) bool {
+	if !b.limits.enabled() {
+		return true
+	}
+
+	var count, size int
+	reserving := make(map[metricKey]bool, len(keys))
+	for _, key := range keys {
+		if _, ok := b.entries[key]; ok || reserving[key] {
+			continue
+		}
+		reserving[key] = true
+		count++
+		size += keySize(key)
+	}
+	if count == 0 {
+		return true
+	}
+
+	for b.full(count, size) {
+		victim, ok := b.victim(reserving)
+		if !ok {
+			b.dropped += int64(count)
+			return false
+		}
+		b.release(victim)
+		evict(victim)
+		b.dropped++
+	}
+
+	for _, key := range keys {
+		if !reserving[key] {
+			continue
+		}
+		delete(reserving, key)
+		b.entries[key] = keySize(key)
+		b.order = append(b.order, key)
+		b.bytes += keySize(key)
+	}
+	return true
+}
+
+func keySize(key metricKey) int {
+	return len(key.id) + len(key.mType) + metricOverhead
+}
+
+var droppedKey = metricKey{mType: entity.Counter, id: DroppedMetricsID}
+
+func (b *buffer) full(count, size int) bool {
+	if b.limits.MaxMetrics > 0 && len(b.entries)+1+count > b.limits.MaxMetrics {
+		return true
+	}
+	return b.limits.MaxBytes > 0 && b.bytes+keySize(droppedKey)+size > b.limits.MaxBytes
+}
+
+func (b *buffer) victim(skip map[metricKey]bool) (metricKey, bool) {
+	switch b.limits.Policy {
+	case DropOldest:
+		for _, key := range b.order {
+			if _, ok := b.entries[key]; ok && !skip[key] {
+				return key, true
+			}
+		}
+	case DropGaugesFirst:
+		for _, key := range b.order {
+			if _, ok := b.entries[key]; ok && !skip[key] && key.mType == entity.Gauge {
+				return key, true
+			}
+		}
+	}
+	return metricKey{}, false
+}
+
+func (b *buffer) release(key metricKey) {
+	b.bytes -= b.entries[key]
+	delete(b.entries, key)
+
+	if len(b.order) > 2*len(b.entries)+16 {
+		order := make([]metricKey, 0, len(b.entries))
+		for _, k := range b.order {
+			if _, ok := b.entries[k]; ok {
+				order = append(order, k)
+			}
+		}
+		b.order = order
+	}
+}
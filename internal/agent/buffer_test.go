@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+func TestBufferLimits(t *testing.T) {
+	aggregated := []string{AggregateMin, AggregateMax, AggregateAvg}
+	tests := []struct {
+		name   string
+		policy string
+		add    []entity.Metric
+		modes  []string
+		want   map[string]float64
+	}{
+		{
+			name:   "newest",
+			policy: DropNewest,
+			add:    []entity.Metric{counterMetric("a", 1), counterMetric("b", 1), counterMetric("c", 1), counterMetric("d", 1), counterMetric("a", 1)},
+			want:   map[string]float64{"a": 2, "b": 1, "c": 1, DroppedMetricsID: 1},
+		},
+		{
+			name:   "oldest",
+			policy: DropOldest,
+			add:    []entity.Metric{counterMetric("a", 1), counterMetric("b", 1), counterMetric("c", 1), counterMetric("d", 1), counterMetric("e", 1)},
+			want:   map[string]float64{"c": 1, "d": 1, "e": 1, DroppedMetricsID: 2},
+		},
+		{
+			name:   "gauges first",
+			policy: DropGaugesFirst,
+			add: []entity.Metric{
+				gaugeMetric("g1", 1), counterMetric("c1", 1), gaugeMetric("g2", 2),
+				counterMetric("c2", 1), counterMetric("c3", 1), counterMetric("c4", 1),
+			},
+			want: map[string]float64{"c1": 1, "c2": 1, "c3": 1, DroppedMetricsID: 3},
+		},
+		{
+			name:   "aggregated gauge takes a slot per mode",
+			policy: DropNewest,
+			add:    []entity.Metric{gaugeMetric("heap", 4), gaugeMetric("heap", 2), gaugeMetric("sys", 1)},
+			modes:  aggregated,
+			want:   map[string]float64{"heap.min": 2, "heap.max": 4, "heap.avg": 3, DroppedMetricsID: 3},
+		},
+		{
+			name:   "oldest evicts a single aggregated mode",
+			policy: DropOldest,
+			add:    []entity.Metric{gaugeMetric("heap", 4), counterMetric("a", 1)},
+			modes:  aggregated,
+			want:   map[string]float64{"heap.max": 4, "heap.avg": 4, "a": 1, DroppedMetricsID: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := &recordingExporter{}
+			p := NewPipeline(zap.NewNop(), "test", 0, exporter, Filter{})
+			p.buffer.limits = BufferLimits{MaxMetrics: 4, Policy: tt.policy}
+
+			for _, metric := range tt.add {
+				var modes []string
+				if metric.MType == entity.Gauge {
+					modes = tt.modes
+				}
+				require.NoError(t, p.add(metric, modes))
+			}
+			_, err := p.send(sendOnce)
+			require.NoError(t, err)
+
+			require.Len(t, exporter.batches, 1)
+			got := make(map[string]float64)
+			for _, m := range exporter.batches[0] {
+				if m.Delta != nil {
+					got[m.ID] = float64(*m.Delta)
+				} else {
+					got[m.ID] = *m.Value
+				}
+			}
+			assert.Equal(t, tt.want, got)
+			assert.LessOrEqual(t, len(got), 4, "dropped counter must fit into the limit")
+		})
+	}
+}
+
+func TestBufferReleasedAfterClear(t *testing.T) {
+	exporter := &recordingExporter{}
+	p := NewPipeline(zap.NewNop(), "test", 0, exporter, Filter{})
+	p.buffer.limits = BufferLimits{MaxMetrics: 3, MaxBytes: 1000, Policy: DropNewest}
+
+	require.NoError(t, p.add(counterMetric("a", 1), nil))
+	require.NoError(t, p.add(counterMetric("b", 1), nil))
+	sent, err := p.send(sendOnce)
+	require.NoError(t, err)
+
+	require.NoError(t, p.add(counterMetric("a", 1), nil))
+	require.NoError(t, p.clear(sent))
+	assert.Len(t, p.buffer.entries, 1, "only the increase of a stays in the buffer")
+
+	require.NoError(t, p.add(counterMetric("c", 1), nil))
+	_, err = p.send(sendOnce)
+	require.NoError(t, err)
+	require.Len(t, exporter.batches, 2)
+	assert.Len(t, exporter.batches[1], 2)
+	assert.Zero(t, p.buffer.dropped)
+}
@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"context"
+	"math/rand"
+	"runtime"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+type Collector interface {
+	Name() string
+	Collect(ctx context.Context) ([]entity.Metric, error)
+}
+
+type RuntimeCollector struct{}
+
+func (c RuntimeCollector) Name() string {
+	return "runtime"
+}
+
+func (c RuntimeCollector) Collect(context.Context) ([]entity.Metric, error) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	return []entity.Metric{
+		gaugeMetric("Alloc", float64(m.Alloc)),
+		gaugeMetric("BuckHashSys", float64(m.BuckHashSys)),
+		gaugeMetric("Frees", float64(m.Frees)),
+		gaugeMetric("GCCPUFraction", float64(m.GCCPUFraction)),
+		gaugeMetric("GCSys", float64(m.GCSys)),
+		gaugeMetric("HeapAlloc", float64(m.HeapAlloc)),
+		gaugeMetric("HeapIdle", float64(m.HeapIdle)),
+		gaugeMetric("HeapInuse", float64(m.HeapInuse)),
+		gaugeMetric("HeapObjects", float64(m.HeapObjects)),
+		gaugeMetric("HeapReleased", float64(m.HeapReleased)),
+		gaugeMetric("HeapSys", float64(m.HeapSys)),
+		gaugeMetric("LastGC", float64(m.LastGC)),
+		gaugeMetric("Lookups", float64(m.Lookups)),
+		gaugeMetric("MCacheInuse", float64(m.MCacheInuse)),
+		gaugeMetric("MCacheSys", float64(m.MCacheSys)),
+		gaugeMetric("MSpanInuse", float64(m.MSpanInuse)),
+		gaugeMetric("MSpanSys", float64(m.MSpanSys)),
+		gaugeMetric("Mallocs", float64(m.Mallocs)),
+		gaugeMetric("NextGC", float64(m.NextGC)),
+		gaugeMetric("NumForcedGC", float64(m.NumForcedGC)),
+		gaugeMetric("NumGC", float64(m.NumGC)),
+		gaugeMetric("OtherSys", float64(m.OtherSys)),
+		gaugeMetric("PauseTotalNs", float64(m.PauseTotalNs)),
+		gaugeMetric("StackInuse", float64(m.StackInuse)),
+		gaugeMetric("StackSys", float64(m.StackSys)),
+		gaugeMetric("Sys", float64(m.Sys)),
+		gaugeMetric("TotalAlloc", float64(m.TotalAlloc)),
+		gaugeMetric("RandomValue", rand.Float64()),
+		counterMetric("PollCount", 1),
+	}, nil
+}
+
+func gaugeMetric(name string, value float64) entity.Metric {
+	return entity.Metric{
+		MType: entity.Gauge,
+		ID:    name,
+		Value: &value,
+	}
+}
+
+func counterMetric(name string, delta int64) entity.Metric {
+	return entity.Metric{
+		MType: entity.Counter,
+		ID:    name,
+		Delta: &delta,
+	}
+}
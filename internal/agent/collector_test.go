@@ -0,0 +1,35 @@
+package agent
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+// collectMetrics опрашивает коллектор и раскладывает метрики по ID.
+func collectMetrics(t *testing.T, collector Collector) map[string]entity.Metric {
+	t.Helper()
+	metrics, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	byID := make(map[string]entity.Metric, len(metrics))
+	for _, metric := range metrics {
+		byID[metric.ID] = metric
+	}
+	return byID
+}
+
+// valueOf возвращает значение гейджа или приращение счётчика, NaN — если значения нет.
+func valueOf(metric entity.Metric) float64 {
+	switch {
+	case metric.Value != nil:
+		return *metric.Value
+	case metric.Delta != nil:
+		return float64(*metric.Delta)
+	}
+	return math.NaN()
+}
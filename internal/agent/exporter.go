@@ -0,0 +1,240 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+const (
+	ExporterHTTP   = "http"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+	ExporterOTLP   = "otlp"
+
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+)
+
+type Exporter interface {
+	Export(metrics []entity.Metric) error
+}
+
+// NewExporter собирает экспортер по спецификации вида http|stdout|file:path.
+// В режиме dry-run http подменяется на stdout, чтобы агент ничего не отправлял на сервер.
+func NewExporter(logger *zap.Logger, spec, format, hookPath string, tlsConfig *tls.Config, dryRun bool) (Exporter, error) {
+	if format == "" {
+		format = FormatJSON
+	}
+	if format != FormatJSON && format != FormatNDJSON {
+		return nil, fmt.Errorf("unknown export format: %s", format)
+	}
+
+	kind, path, _ := strings.Cut(spec, ":")
+	if kind == "" || (dryRun && kind == ExporterHTTP) {
+		if dryRun {
+			kind = ExporterStdout
+		} else {
+			kind = ExporterHTTP
+		}
+	}
+
+	switch kind {
+	case ExporterHTTP:
+		return NewHTTPExporter(logger, hookPath, tlsConfig), nil
+	case ExporterStdout:
+		return NewWriterExporter(os.Stdout, format), nil
+	case ExporterFile:
+		if path == "" {
+			return nil, fmt.Errorf("file exporter requires a path: %s", spec)
+		}
+		return NewFileExporter(path, format), nil
+	default:
+		return nil, fmt.Errorf("unknown exporter: %s", spec)
+	}
+}
+
+// MultiExporter отправляет каждую пачку во все экспортеры, например
+// одновременно на сервер и в OTel-коллектор.
+type MultiExporter []Exporter
+
+func (m MultiExporter) Export(metrics []entity.Metric) error {
+	var errs []error
+	for _, exporter := range m {
+		if err := exporter.Export(metrics); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+type namedExporter struct {
+	namer Namer
+	next  Exporter
+}
+
+// NewNamedExporter переименовывает метрики по шаблону Namer перед экспортом.
+// Шаблон задаётся экспортеру, а не всему агенту: в OTLP экземпляр уходит
+// атрибутом ресурса, и ID метрик там переименовывать нельзя.
+func NewNamedExporter(namer Namer, next Exporter) Exporter {
+	return &namedExporter{
+		namer: namer,
+		next:  next,
+	}
+}
+
+func (e *namedExporter) Export(metrics []entity.Metric) error {
+	named := make([]entity.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		metric.ID = e.namer.Name(metric.ID)
+		named = append(named, metric)
+	}
+
+	return e.next.Export(named)
+}
+
+type HTTPExporter struct {
+	logger   *zap.Logger
+	hookPath string
+	client   *resty.Client
+}
+
+func NewHTTPExporter(logger *zap.Logger, hookPath string, tlsConfig *tls.Config) *HTTPExporter {
+	client := resty.New()
+	if tlsConfig != nil {
+		client.SetTLSClientConfig(tlsConfig)
+	}
+
+	return &HTTPExporter{
+		logger:   logger,
+		hookPath: hookPath,
+		client:   client,
+	}
+}
+
+func (e *HTTPExporter) Export(metrics []entity.Metric) error {
+	jsonMetrics, err := json.Marshal(metrics)
+	if err != nil {
+		e.logger.Error("Marshaling error:", zap.Error(err))
+		return err
+	}
+
+	var gzippedMetric bytes.Buffer
+	zb := gzip.NewWriter(&gzippedMetric)
+
+	_, err = zb.Write(jsonMetrics)
+	if err != nil {
+		e.logger.Error("Failed to gzip metrics:", zap.Error(err))
+		return err
+	}
+	err = zb.Close()
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/", e.hookPath)
+	req := e.client.R()
+	req.Method = http.MethodPost
+	req.URL = url
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.SetBody(gzippedMetric.Bytes())
+
+	res, err := req.Send()
+	if err != nil {
+		e.logger.Error("Failed to send metrics", zap.Error(err))
+		return err
+	}
+	if res.StatusCode() != http.StatusOK {
+		e.logger.Error("Failed to send metric: wrong response code: ", zap.Int("status", res.StatusCode()))
+		return fmt.Errorf("failed to send metrics: status %d", res.StatusCode())
+	}
+
+	return nil
+}
+
+type WriterExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+}
+
+func NewWriterExporter(w io.Writer, format string) *WriterExporter {
+	return &WriterExporter{
+		w:      w,
+		format: format,
+	}
+}
+
+func (e *WriterExporter) Export(metrics []entity.Metric) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return writeMetrics(e.w, metrics, e.format)
+}
+
+type FileExporter struct {
+	mu     sync.Mutex
+	path   string
+	format string
+}
+
+func NewFileExporter(path, format string) *FileExporter {
+	return &FileExporter{
+		path:   path,
+		format: format,
+	}
+}
+
+func (e *FileExporter) Export(metrics []entity.Metric) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	file, err := os.OpenFile(e.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open export file: %w", err)
+	}
+
+	if err := writeMetrics(file, metrics, e.format); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func writeMetrics(w io.Writer, metrics []entity.Metric, format string) error {
+	if format == FormatNDJSON {
+		encoder := json.NewEncoder(w)
+		for _, metric := range metrics {
+			if err := encoder.Encode(metric); err != nil {
+				return fmt.Errorf("failed to encode metric: %w", err)
+			}
+		}
+		return nil
+	}
+
+	if metrics == nil {
+		metrics = []entity.Metric{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(metrics); err != nil {
+		return fmt.Errorf("failed to encode metrics: %w", err)
+	}
+	return nil
+}
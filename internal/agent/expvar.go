@@ -0,0 +1,179 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+// memstatsSkip — массивы runtime.MemStats, которые в метрики не превращаются:
+// они большие и без агрегации бесполезны.
+var memstatsSkip = map[string]bool{
+	"BySize":   true,
+	"PauseNs":  true,
+	"PauseEnd": true,
+}
+
+type ExpvarTarget struct {
+	Name string
+	URL  string
+}
+
+// ExpvarCollector читает /debug/vars Go-сервисов. Числовые листья JSON становятся
+// гейджами expvar.<target>.<путь>, путь отбирается фильтром. Из memstats берутся
+// только скалярные поля и последняя пауза GC как memstats.LastPauseNs.
+// Доступность цели отдаётся гейджем expvar.<target>.up.
+type ExpvarCollector struct {
+	targets []ExpvarTarget
+	filter  Filter
+	client  *http.Client
+}
+
+func NewExpvarCollector(targets []ExpvarTarget, filter Filter, timeout time.Duration) (*ExpvarCollector, error) {
+	names := make(map[string]bool)
+	for _, target := range targets {
+		if target.Name == "" || target.URL == "" {
+			return nil, fmt.Errorf("expvar target requires a name and a url")
+		}
+		if names[target.Name] {
+			return nil, fmt.Errorf("duplicate expvar target: %s", target.Name)
+		}
+		names[target.Name] = true
+	}
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+
+	return &ExpvarCollector{
+		targets: targets,
+		filter:  filter,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (c *ExpvarCollector) Name() string {
+	return "expvar"
+}
+
+func (c *ExpvarCollector) Collect(ctx context.Context) ([]entity.Metric, error) {
+	results := make([][]entity.Metric, len(c.targets))
+
+	var wg sync.WaitGroup
+	for i, target := range c.targets {
+		wg.Add(1)
+		go func(i int, target ExpvarTarget) {
+			defer wg.Done()
+			results[i] = c.scrape(ctx, target)
+		}(i, target)
+	}
+	wg.Wait()
+
+	var metrics []entity.Metric
+	for _, result := range results {
+		metrics = append(metrics, result...)
+	}
+	return metrics, nil
+}
+
+func (c *ExpvarCollector) scrape(ctx context.Context, target ExpvarTarget) []entity.Metric {
+	prefix := "expvar." + target.Name + "."
+
+	vars, err := c.fetch(ctx, target.URL)
+	if err != nil {
+		return []entity.Metric{gaugeMetric(prefix+"up", 0)}
+	}
+
+	values := make(map[string]float64)
+	for key, value := range vars {
+		if key == "memstats" {
+			walkMemstats(value, values)
+			continue
+		}
+		walkJSON(key, value, values)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if c.filter.Match(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	metrics := make([]entity.Metric, 0, len(keys)+1)
+	metrics = append(metrics, gaugeMetric(prefix+"up", 1))
+	for _, key := range keys {
+		metrics = append(metrics, gaugeMetric(prefix+key, values[key]))
+	}
+	return metrics
+}
+
+func (c *ExpvarCollector) fetch(ctx context.Context, url string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %d", res.StatusCode)
+	}
+
+	var vars map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&vars); err != nil {
+		return nil, fmt.Errorf("failed to decode expvar: %w", err)
+	}
+	return vars, nil
+}
+
+func walkJSON(path string, value interface{}, out map[string]float64) {
+	switch v := value.(type) {
+	case float64:
+		out[path] = v
+	case map[string]interface{}:
+		for key, child := range v {
+			walkJSON(path+"."+key, child, out)
+		}
+	case []interface{}:
+		for i, child := range v {
+			walkJSON(path+"."+strconv.Itoa(i), child, out)
+		}
+	}
+}
+
+func walkMemstats(value interface{}, out map[string]float64) {
+	memstats, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for key, child := range memstats {
+		if memstatsSkip[key] {
+			continue
+		}
+		if v, ok := child.(float64); ok {
+			out["memstats."+key] = v
+		}
+	}
+
+	// PauseNs — кольцевой буфер, последняя пауза лежит по индексу (NumGC+255)%256.
+	pauses, _ := memstats["PauseNs"].([]interface{})
+	numGC, _ := memstats["NumGC"].(float64)
+	if len(pauses) > 0 && numGC > 0 {
+		if last, ok := pauses[(int(numGC)+len(pauses)-1)%len(pauses)].(float64); ok {
+			out["memstats.LastPauseNs"] = last
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package agent
+
+import (
+	"expvar"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpvarCollector(t *testing.T) {
+	server := httptest.NewServer(expvar.Handler())
+	defer server.Close()
+	expvar.NewInt("requests").Set(7)
+
+	collector, err := NewExpvarCollector([]ExpvarTarget{{Name: "svc", URL: server.URL}},
+		Filter{Include: []string{"requests", "memstats.*"}}, time.Second)
+	require.NoError(t, err)
+
+	metrics := collectMetrics(t, collector)
+	assert.Equal(t, 1.0, valueOf(metrics["expvar.svc.up"]))
+	assert.Equal(t, 7.0, valueOf(metrics["expvar.svc.requests"]))
+	assert.Contains(t, metrics, "expvar.svc.memstats.HeapAlloc")
+	assert.NotContains(t, metrics, "expvar.svc.memstats.BySize.0.Size")
+	assert.NotContains(t, metrics, "expvar.svc.cmdline.0")
+}
@@ -0,0 +1,189 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+const (
+	FileModeAuto    = ""
+	FileModeInotify = "inotify"
+	FileModeScan    = "scan"
+)
+
+var errNotifyUnsupported = errors.New("file notifications are not supported on this platform")
+
+// FileWatch — набор путей под одним именем, пути могут быть шаблонами filepath.Glob.
+// Путь к каталогу означает все файлы в нём.
+type FileWatch struct {
+	Name     string
+	Patterns []string
+}
+
+// fileNotifier помечает наблюдения изменёнными по событиям файловой системы.
+type fileNotifier interface {
+	watch(dir string, w *fileWatch) error
+	Close() error
+}
+
+type fileWatch struct {
+	FileWatch
+	// poll — каталог нельзя отслеживать событиями, он сканируется при каждом опросе.
+	poll    atomic.Bool
+	dirty   atomic.Bool
+	scanned bool
+	stats   fileStats
+}
+
+type fileStats struct {
+	count  int
+	size   int64
+	oldest time.Time
+	newest time.Time
+}
+
+// FileCollector отдаёт для каждого наблюдения число файлов, их общий размер
+// и возраст самого старого и самого нового файла. С inotify каталог
+// пересканируется только после события в нём, иначе — при каждом опросе.
+type FileCollector struct {
+	watches  []*fileWatch
+	notifier fileNotifier
+}
+
+func NewFileCollector(watches []FileWatch, mode string) (*FileCollector, error) {
+	c := &FileCollector{}
+	for _, w := range watches {
+		if w.Name == "" || len(w.Patterns) == 0 {
+			return nil, fmt.Errorf("file watch requires a name and paths")
+		}
+		patterns := make([]string, 0, len(w.Patterns))
+		for _, pattern := range w.Patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("file watch %s: invalid pattern %s", w.Name, pattern)
+			}
+			patterns = append(patterns, expandDir(pattern))
+		}
+		w.Patterns = patterns
+		c.watches = append(c.watches, &fileWatch{FileWatch: w})
+	}
+
+	switch mode {
+	case FileModeScan:
+		return c, nil
+	case FileModeAuto, FileModeInotify:
+	default:
+		return nil, fmt.Errorf("unknown file watch mode: %s", mode)
+	}
+
+	notifier, err := newFileNotifier()
+	if err != nil {
+		if mode == FileModeInotify {
+			return nil, err
+		}
+		return c, nil
+	}
+	c.notifier = notifier
+
+	for _, w := range c.watches {
+		for _, pattern := range w.Patterns {
+			dir := filepath.Dir(pattern)
+			if hasMeta(dir) {
+				w.poll.Store(true)
+				continue
+			}
+			if err := notifier.watch(dir, w); err != nil {
+				w.poll.Store(true)
+			}
+		}
+	}
+	return c, nil
+}
+
+func (c *FileCollector) Name() string {
+	return "files"
+}
+
+func (c *FileCollector) Collect(context.Context) ([]entity.Metric, error) {
+	now := time.Now()
+
+	var metrics []entity.Metric
+	for _, w := range c.watches {
+		if c.notifier == nil || w.poll.Load() || !w.scanned || w.dirty.Swap(false) {
+			w.stats = scanFiles(w.Patterns)
+			w.scanned = true
+		}
+
+		var oldest, newest float64
+		if w.stats.count > 0 {
+			oldest = now.Sub(w.stats.oldest).Seconds()
+			newest = now.Sub(w.stats.newest).Seconds()
+		}
+		prefix := "files." + w.Name + "."
+		metrics = append(metrics,
+			gaugeMetric(prefix+"count", float64(w.stats.count)),
+			gaugeMetric(prefix+"size_bytes", float64(w.stats.size)),
+			gaugeMetric(prefix+"oldest_age_seconds", oldest),
+			gaugeMetric(prefix+"newest_age_seconds", newest),
+		)
+	}
+	return metrics, nil
+}
+
+func (c *FileCollector) Close() error {
+	if c.notifier == nil {
+		return nil
+	}
+	return c.notifier.Close()
+}
+
+// expandDir превращает путь к каталогу в шаблон его файлов, чтобы сканировались
+// и отслеживались файлы внутри, а не сам каталог в родительском.
+func expandDir(pattern string) string {
+	if hasMeta(pattern) {
+		return pattern
+	}
+	if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+		return filepath.Join(pattern, "*")
+	}
+	return pattern
+}
+
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
+
+func scanFiles(patterns []string) fileStats {
+	var stats fileStats
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			if seen[match] {
+				continue
+			}
+			seen[match] = true
+
+			info, err := os.Stat(match)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			stats.count++
+			stats.size += info.Size()
+			if stats.oldest.IsZero() || info.ModTime().Before(stats.oldest) {
+				stats.oldest = info.ModTime()
+			}
+			if info.ModTime().After(stats.newest) {
+				stats.newest = info.ModTime()
+			}
+		}
+	}
+	return stats
+}
@@ -0,0 +1,102 @@
+//go:build linux
+
+package agent
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+type inotifyNotifier struct {
+	file *os.File
+
+	mu      sync.Mutex
+	watches map[int32][]*fileWatch
+}
+
+func newFileNotifier() (fileNotifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	// Неблокирующий дескриптор попадает в поллер рантайма, поэтому Close прерывает Read.
+	n := &inotifyNotifier{
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		watches: make(map[int32][]*fileWatch),
+	}
+	go n.run()
+	return n, nil
+}
+
+func (n *inotifyNotifier) watch(dir string, w *fileWatch) error {
+	rawConn, err := n.file.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var wd int
+	var watchErr error
+	err = rawConn.Control(func(fd uintptr) {
+		wd, watchErr = syscall.InotifyAddWatch(int(fd), dir, inotifyMask)
+	})
+	if err != nil {
+		return err
+	}
+	if watchErr != nil {
+		return watchErr
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.watches[int32(wd)] = append(n.watches[int32(wd)], w)
+	return nil
+}
+
+func (n *inotifyNotifier) Close() error {
+	return n.file.Close()
+}
+
+func (n *inotifyNotifier) run() {
+	buf := make([]byte, 64*1024)
+	for {
+		size, err := n.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= size; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			n.markDirty(event.Wd, event.Mask)
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+		}
+	}
+}
+
+func (n *inotifyNotifier) markDirty(wd int32, mask uint32) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// При переполнении очереди события потеряны, пересканировать нужно всё.
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		for _, watches := range n.watches {
+			for _, w := range watches {
+				w.dirty.Store(true)
+			}
+		}
+		return
+	}
+
+	for _, w := range n.watches[wd] {
+		w.dirty.Store(true)
+		// Каталог удалён или перемещён: событий больше не будет, переходим на сканирование.
+		if mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF|syscall.IN_IGNORED) != 0 {
+			w.poll.Store(true)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package agent
+
+func newFileNotifier() (fileNotifier, error) {
+	return nil, errNotifyUnsupported
+}
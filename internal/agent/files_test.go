@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileCollector(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.job"), []byte("12345"), 0644))
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "a.job"), old, old))
+
+	collector, err := NewFileCollector([]FileWatch{{Name: "spool", Patterns: []string{filepath.Join(dir, "*.job")}}}, FileModeAuto)
+	require.NoError(t, err)
+	defer collector.Close()
+
+	first := collectMetrics(t, collector)
+	assert.Equal(t, 1.0, valueOf(first["files.spool.count"]))
+	assert.Equal(t, 5.0, valueOf(first["files.spool.size_bytes"]))
+	assert.InDelta(t, time.Hour.Seconds(), valueOf(first["files.spool.oldest_age_seconds"]), 5)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.job"), []byte("123"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "c.txt"), []byte("123"), 0644))
+	assert.Eventually(t, func() bool {
+		second := collectMetrics(t, collector)
+		return valueOf(second["files.spool.count"]) == 2 && valueOf(second["files.spool.size_bytes"]) == 8
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestFileCollectorDirectory(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.log"), []byte("1234"), 0644))
+
+	collector, err := NewFileCollector([]FileWatch{{Name: "logs", Patterns: []string{dir}}}, FileModeAuto)
+	require.NoError(t, err)
+	defer collector.Close()
+
+	first := collectMetrics(t, collector)
+	assert.Equal(t, 1.0, valueOf(first["files.logs.count"]))
+	assert.Equal(t, 4.0, valueOf(first["files.logs.size_bytes"]))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.log"), []byte("12"), 0644))
+	assert.Eventually(t, func() bool {
+		second := collectMetrics(t, collector)
+		return valueOf(second["files.logs.count"]) == 2 && valueOf(second["files.logs.size_bytes"]) == 6
+	}, time.Second, 10*time.Millisecond, "changes inside the directory must be noticed")
+}
@@ -0,0 +1,26 @@
+package agent
+
+import "path"
+
+// Filter отбирает метрики по ID шаблонами path.Match, например Heap* или *.max.
+// Пустой Include пропускает всё, Exclude применяется после Include.
+type Filter struct {
+	Include []string
+	Exclude []string
+}
+
+func (f Filter) Match(id string) bool {
+	if len(f.Include) > 0 && !matchAny(f.Include, id) {
+		return false
+	}
+	return !matchAny(f.Exclude, id)
+}
+
+func matchAny(patterns []string, id string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, id); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	InstanceSourceHostname = "hostname"
+	InstanceSourceUUID     = "uuid"
+
+	DefaultMetricTemplate = "{id}"
+)
+
+// ResolveInstanceID определяет идентичность агента: явно заданное значение,
+// имя хоста или UUID, который сохраняется в idFile и переживает перезапуски.
+func ResolveInstanceID(configured, source, idFile string) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+
+	switch source {
+	case "", InstanceSourceHostname:
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", fmt.Errorf("failed to get hostname: %w", err)
+		}
+		return hostname, nil
+	case InstanceSourceUUID:
+		return loadOrCreateUUID(idFile)
+	default:
+		return "", fmt.Errorf("unknown instance source: %s", source)
+	}
+}
+
+func loadOrCreateUUID(idFile string) (string, error) {
+	if idFile == "" {
+		return "", fmt.Errorf("instance id file is not set")
+	}
+
+	data, err := os.ReadFile(idFile)
+	if err == nil && strings.TrimSpace(string(data)) != "" {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read instance id file: %w", err)
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(idFile), 0755); err != nil {
+		return "", fmt.Errorf("failed to create instance id dir: %w", err)
+	}
+	if err := os.WriteFile(idFile, []byte(id+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("failed to write instance id file: %w", err)
+	}
+
+	return id, nil
+}
+
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate uuid: %w", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// Namer строит ID метрики по шаблону с подстановками {instance} и {id},
+// например "{instance}.{id}" превращает Alloc в host-1.Alloc.
+type Namer struct {
+	pattern  string
+	instance string
+}
+
+func NewNamer(instance, template string) Namer {
+	if template == "" {
+		template = DefaultMetricTemplate
+	}
+
+	return Namer{
+		pattern:  strings.ReplaceAll(template, "{instance}", instance),
+		instance: instance,
+	}
+}
+
+func (n Namer) Instance() string {
+	return n.instance
+}
+
+func (n Namer) Name(id string) string {
+	if n.pattern == "" || n.pattern == DefaultMetricTemplate {
+		return id
+	}
+
+	return strings.ReplaceAll(n.pattern, "{id}", id)
+}
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+func TestResolveInstanceID(t *testing.T) {
+	t.Run("configured", func(t *testing.T) {
+		id, err := ResolveInstanceID("agent-1", InstanceSourceUUID, "")
+		require.NoError(t, err)
+		assert.Equal(t, "agent-1", id)
+	})
+
+	t.Run("hostname", func(t *testing.T) {
+		hostname, err := os.Hostname()
+		require.NoError(t, err)
+		for _, source := range []string{"", InstanceSourceHostname} {
+			id, err := ResolveInstanceID("", source, "")
+			require.NoError(t, err)
+			assert.Equal(t, hostname, id)
+		}
+	})
+
+	t.Run("uuid persisted in id file", func(t *testing.T) {
+		idFile := filepath.Join(t.TempDir(), "state", "instance-id")
+
+		id, err := ResolveInstanceID("", InstanceSourceUUID, idFile)
+		require.NoError(t, err)
+		assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), id)
+
+		data, err := os.ReadFile(idFile)
+		require.NoError(t, err)
+		assert.Equal(t, id, strings.TrimSpace(string(data)))
+
+		again, err := ResolveInstanceID("", InstanceSourceUUID, idFile)
+		require.NoError(t, err)
+		assert.Equal(t, id, again, "uuid must survive restarts")
+	})
+
+	t.Run("uuid from existing file", func(t *testing.T) {
+		idFile := filepath.Join(t.TempDir(), "instance-id")
+		require.NoError(t, os.WriteFile(idFile, []byte("  fixed-id\n"), 0644))
+
+		id, err := ResolveInstanceID("", InstanceSourceUUID, idFile)
+		require.NoError(t, err)
+		assert.Equal(t, "fixed-id", id)
+	})
+
+	t.Run("uuid without id file", func(t *testing.T) {
+		_, err := ResolveInstanceID("", InstanceSourceUUID, "")
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown source", func(t *testing.T) {
+		_, err := ResolveInstanceID("", "mac", "")
+		assert.Error(t, err)
+	})
+}
+
+func TestNamer(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{template: "", want: "Alloc"},
+		{template: DefaultMetricTemplate, want: "Alloc"},
+		{template: "{instance}.{id}", want: "host-1.Alloc"},
+		{template: "agent.{id}.{instance}", want: "agent.Alloc.host-1"},
+		{template: "static", want: "static"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			namer := NewNamer("host-1", tt.template)
+			assert.Equal(t, tt.want, namer.Name("Alloc"))
+			assert.Equal(t, "host-1", namer.Instance())
+		})
+	}
+}
+
+func TestNamedExporter(t *testing.T) {
+	var out bytes.Buffer
+	exporter := NewNamedExporter(NewNamer("host-1", "{instance}.{id}"), NewWriterExporter(&out, FormatJSON))
+
+	value := 1.5
+	metrics := []entity.Metric{{ID: "Alloc", MType: entity.Gauge, Value: &value}}
+	require.NoError(t, exporter.Export(metrics))
+
+	var exported []entity.Metric
+	require.NoError(t, json.Unmarshal(out.Bytes(), &exported))
+	require.Len(t, exported, 1)
+	assert.Equal(t, "host-1.Alloc", exported[0].ID)
+	assert.Equal(t, "Alloc", metrics[0].ID, "source metrics must not be renamed")
+}
@@ -1,21 +1,16 @@
 package agent
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
-	"net/http"
 	"os"
-	"os/signal"
-	"runtime"
-	"syscall"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/go-resty/resty/v2"
 	"go.uber.org/zap"
 
 	"github.com/WPGe/go-yandex-advanced/internal/entity"
@@ -24,61 +19,310 @@ import (
 )
 
 type Agent struct {
-	logger   *zap.Logger
-	storage  *storage.MemStorage
-	hookPath string
+	logger             *zap.Logger
+	collectors         []Collector
+	available          []Collector
+	collectorIntervals map[string]time.Duration
+	schedule           []*scheduledCollector
+	pipelines          []*Pipeline
+	aggregation        map[string][]string
+	filter             Filter
+	remote             *RemoteConfigClient
+	remotePoll         time.Duration
+	configCh           chan entity.AgentConfig
+
+	pollInterval time.Duration
+	limits       BufferLimits
+	collecting   sync.Map
+	inFlight     sync.WaitGroup
+	running      atomic.Bool
+	stop         chan struct{}
+	stopOnce     sync.Once
+	done         chan struct{}
 }
 
-func NewAgent(logger *zap.Logger, storage *storage.MemStorage, hookPath string) *Agent {
-	return &Agent{
-		logger:   logger,
-		storage:  storage,
-		hookPath: hookPath,
+type scheduledCollector struct {
+	collector Collector
+	interval  time.Duration
+	next      time.Time
+}
+
+type Option func(a *Agent)
+
+func WithCollectors(collectors ...Collector) Option {
+	return func(a *Agent) {
+		a.collectors = collectors
+		a.available = collectors
+	}
+}
+
+// WithAggregation задаёт режимы агрегации гейджей по имени коллектора.
+// Коллекторы без режимов пишут гейджи в хранилище как есть (last).
+func WithAggregation(aggregation map[string][]string) Option {
+	return func(a *Agent) {
+		a.aggregation = aggregation
+	}
+}
+
+// WithIntervals задаёт интервал опроса по умолчанию и интервал конвейера default.
+func WithIntervals(pollInterval, reportInterval time.Duration) Option {
+	return func(a *Agent) {
+		a.pollInterval = pollInterval
+		a.pipelines[0].interval = reportInterval
+	}
+}
+
+// WithCollectorIntervals задаёт собственный интервал опроса коллекторам по имени,
+// например CPU раз в секунду, а диск раз в минуту.
+func WithCollectorIntervals(intervals map[string]time.Duration) Option {
+	return func(a *Agent) {
+		for name, interval := range intervals {
+			a.collectorIntervals[name] = interval
+		}
+	}
+}
+
+// WithPipelines добавляет конвейеры отправки к конвейеру default.
+func WithPipelines(pipelines ...*Pipeline) Option {
+	return func(a *Agent) {
+		a.pipelines = append(a.pipelines, pipelines...)
+	}
+}
+
+// WithBufferLimits ограничивает буфер каждого конвейера. Выброшенные метрики
+// агент отправляет счётчиком AgentDroppedMetrics.
+func WithBufferLimits(limits BufferLimits) Option {
+	return func(a *Agent) {
+		a.limits = limits
+	}
+}
+
+// WithRemoteConfig включает опрос конфигурации с сервера. Полученные интервалы,
+// коллекторы и фильтры применяются без перезапуска агента.
+func WithRemoteConfig(client *RemoteConfigClient, interval time.Duration) Option {
+	return func(a *Agent) {
+		a.remote = client
+		a.remotePoll = interval
 	}
 }
 
-func (a *Agent) MetricAgent(reportInterval time.Duration, pollInterval time.Duration, stopCh <-chan struct{}) {
-	pollTicker := time.NewTicker(pollInterval * time.Second)
-	sendTicker := time.NewTicker(reportInterval * time.Second)
+// NewAgent создаёт агента с конвейером default, который копит метрики
+// в storage и отправляет их через exporter.
+func NewAgent(logger *zap.Logger, storage *storage.MemStorage, exporter Exporter, opts ...Option) *Agent {
+	a := &Agent{
+		logger:             logger,
+		collectors:         []Collector{RuntimeCollector{}},
+		available:          []Collector{RuntimeCollector{}},
+		collectorIntervals: make(map[string]time.Duration),
+		pipelines:          []*Pipeline{newPipeline(DefaultPipeline, 10*time.Second, exporter, Filter{}, storage)},
+		aggregation:        make(map[string][]string),
+		configCh:           make(chan entity.AgentConfig, 1),
+
+		pollInterval: 2 * time.Second,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	for _, p := range a.pipelines {
+		p.buffer.limits = a.limits
+	}
+
+	return a
+}
+
+// Run опрашивает коллекторы и отправляет метрики по расписанию, пока не отменён ctx
+// или не вызван Shutdown. Ошибки отправки не останавливают агента:
+// неотправленные метрики остаются в буфере конвейера до следующей отправки.
+// Каждый опрос и каждая отправка идут в своей горутине, чтобы медленный
+// коллектор или повторы отправки не сдвигали расписание остальных. Пока опрос
+// коллектора или отправка конвейера не закончились, следующий запуск пропускается.
+func (a *Agent) Run(ctx context.Context) error {
+	if !a.running.CompareAndSwap(false, true) {
+		return errors.New("agent is already running")
+	}
+	defer close(a.done)
+	defer a.inFlight.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if a.remote != nil {
+		go a.remote.Run(ctx, a.remotePoll, a.ApplyConfig)
+	}
+
+	now := time.Now()
+	a.buildSchedule(now)
+	for _, p := range a.pipelines {
+		p.next = now.Add(p.interval)
+	}
 
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGKILL, syscall.SIGTERM, syscall.SIGINT)
-	defer stop()
+	timer := time.NewTimer(a.untilNext(now))
+	defer timer.Stop()
 
 	for {
 		select {
-		case <-pollTicker.C:
-			a.collectGaugeRuntimeMetrics()
-			a.increasePollIteration()
-		case <-sendTicker.C:
-			err := a.Retry(ctx, 3, func(ctx context.Context) error {
-				err := a.sendMetrics()
-				if err != nil {
-					a.logger.Error("Send error:", zap.Error(err))
-				}
-
-				err = a.storage.ClearMetrics()
-				if err != nil {
-					a.logger.Error("Clear error:", zap.Error(err))
+		case cfg := <-a.configCh:
+			if err := a.applyConfig(cfg); err != nil {
+				a.logger.Error("Apply config error", zap.String("version", cfg.Version), zap.Error(err))
+				continue
+			}
+			a.logger.Info("Config applied", zap.String("version", cfg.Version))
+			if a.remote != nil {
+				a.remote.Applied(cfg.Version)
+				go func(version string) {
+					if err := a.remote.ReportApplied(version); err != nil {
+						a.logger.Error("Report config error", zap.Error(err))
+					}
+				}(cfg.Version)
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
 				}
-				return err
-			})
-			if err != nil {
-				return
 			}
+			timer.Reset(a.untilNext(time.Now()))
+		case <-timer.C:
+			a.runDue(ctx, time.Now())
+			timer.Reset(a.untilNext(time.Now()))
+		case <-a.stop:
+			return nil
 		case <-ctx.Done():
-			a.logger.Error("Send stop:", zap.Error(ctx.Err()))
-			pollTicker.Stop()
-			sendTicker.Stop()
-			return
-		case <-stopCh:
-			err := a.sendMetrics()
-			if err != nil {
-				a.logger.Error("Send error:", zap.Error(err))
+			return nil
+		}
+	}
+}
+
+// Shutdown останавливает Run и делает последнюю отправку всех конвейеров, ограниченную ctx.
+func (a *Agent) Shutdown(ctx context.Context) error {
+	a.stopOnce.Do(func() {
+		close(a.stop)
+	})
+
+	if a.running.Load() {
+		select {
+		case <-a.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		var errs []error
+		for _, p := range a.pipelines {
+			if _, err := p.send(func(export func() error) error { return export() }); err != nil {
+				errs = append(errs, fmt.Errorf("pipeline %s: %w", p.name, err))
 			}
-			pollTicker.Stop()
-			sendTicker.Stop()
-			return
 		}
+		errCh <- errors.Join(errs...)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (a *Agent) runDue(ctx context.Context, now time.Time) {
+	for _, s := range a.schedule {
+		if now.Before(s.next) {
+			continue
+		}
+		s.next = nextRun(s.next, s.interval, now)
+
+		collector := s.collector
+		if _, busy := a.collecting.LoadOrStore(collector.Name(), struct{}{}); busy {
+			a.logger.Warn("Collect skipped, previous run is still in progress", zap.String("collector", collector.Name()))
+			continue
+		}
+		modes, filter := a.aggregation[collector.Name()], a.filter
+		a.inFlight.Add(1)
+		go func() {
+			defer a.inFlight.Done()
+			defer a.collecting.Delete(collector.Name())
+			a.collect(ctx, collector, modes, filter)
+		}()
+	}
+
+	for _, p := range a.pipelines {
+		if now.Before(p.next) {
+			continue
+		}
+		p.next = nextRun(p.next, p.interval, now)
+
+		if !p.sending.CompareAndSwap(false, true) {
+			a.logger.Warn("Send skipped, previous send is still in progress", zap.String("pipeline", p.name))
+			continue
+		}
+		a.inFlight.Add(1)
+		go func(p *Pipeline) {
+			defer a.inFlight.Done()
+			defer p.sending.Store(false)
+			a.sendPipeline(ctx, p)
+		}(p)
+	}
+}
+
+func (a *Agent) untilNext(now time.Time) time.Duration {
+	var next time.Time
+	for _, s := range a.schedule {
+		if next.IsZero() || s.next.Before(next) {
+			next = s.next
+		}
+	}
+	for _, p := range a.pipelines {
+		if next.IsZero() || p.next.Before(next) {
+			next = p.next
+		}
+	}
+
+	if d := next.Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func (a *Agent) buildSchedule(now time.Time) {
+	schedule := make([]*scheduledCollector, 0, len(a.collectors))
+	for _, collector := range a.collectors {
+		interval := a.pollInterval
+		if custom, ok := a.collectorIntervals[collector.Name()]; ok && custom > 0 {
+			interval = custom
+		}
+
+		next := now.Add(interval)
+		for _, s := range a.schedule {
+			if s.collector.Name() == collector.Name() && s.interval == interval {
+				next = s.next
+			}
+		}
+		schedule = append(schedule, &scheduledCollector{
+			collector: collector,
+			interval:  interval,
+			next:      next,
+		})
+	}
+	a.schedule = schedule
+}
+
+// sendPipeline повторяет отправку в каждый экспортер конвейера отдельно.
+func (a *Agent) sendPipeline(ctx context.Context, p *Pipeline) {
+	sent, err := p.send(func(export func() error) error {
+		return a.Retry(ctx, 3, func(ctx context.Context) error {
+			return export()
+		}, 1*time.Second, 3*time.Second, 5*time.Second)
+	})
+	if err != nil {
+		a.logger.Error("Send error, metrics are kept for the next report:", zap.String("pipeline", p.name), zap.Error(err))
+		return
+	}
+	if err := p.clear(sent); err != nil {
+		a.logger.Error("Clear error:", zap.String("pipeline", p.name), zap.Error(err))
 	}
 }
 
@@ -111,120 +355,93 @@ func (a *Agent) Retry(ctx context.Context, maxRetries int, fn func(ctx context.C
 	return err
 }
 
-func (a *Agent) collectGaugeRuntimeMetrics() {
-	var m runtime.MemStats
-	runtime.ReadMemStats(&m)
-
-	a.addGaugeMetricToStorage("Alloc", float64(m.Alloc))
-	a.addGaugeMetricToStorage("BuckHashSys", float64(m.BuckHashSys))
-	a.addGaugeMetricToStorage("Frees", float64(m.Frees))
-	a.addGaugeMetricToStorage("GCCPUFraction", float64(m.GCCPUFraction))
-	a.addGaugeMetricToStorage("GCSys", float64(m.GCSys))
-	a.addGaugeMetricToStorage("HeapAlloc", float64(m.HeapAlloc))
-	a.addGaugeMetricToStorage("HeapIdle", float64(m.HeapIdle))
-	a.addGaugeMetricToStorage("HeapInuse", float64(m.HeapInuse))
-	a.addGaugeMetricToStorage("HeapObjects", float64(m.HeapObjects))
-	a.addGaugeMetricToStorage("HeapReleased", float64(m.HeapReleased))
-	a.addGaugeMetricToStorage("HeapSys", float64(m.HeapSys))
-	a.addGaugeMetricToStorage("LastGC", float64(m.LastGC))
-	a.addGaugeMetricToStorage("Lookups", float64(m.Lookups))
-	a.addGaugeMetricToStorage("MCacheInuse", float64(m.MCacheInuse))
-	a.addGaugeMetricToStorage("MCacheSys", float64(m.MCacheSys))
-	a.addGaugeMetricToStorage("MSpanInuse", float64(m.MSpanInuse))
-	a.addGaugeMetricToStorage("MSpanSys", float64(m.MSpanSys))
-	a.addGaugeMetricToStorage("Mallocs", float64(m.Mallocs))
-	a.addGaugeMetricToStorage("NextGC", float64(m.NextGC))
-	a.addGaugeMetricToStorage("NumForcedGC", float64(m.NumForcedGC))
-	a.addGaugeMetricToStorage("NumGC", float64(m.NumGC))
-	a.addGaugeMetricToStorage("OtherSys", float64(m.OtherSys))
-	a.addGaugeMetricToStorage("PauseTotalNs", float64(m.PauseTotalNs))
-	a.addGaugeMetricToStorage("StackInuse", float64(m.StackInuse))
-	a.addGaugeMetricToStorage("StackSys", float64(m.StackSys))
-	a.addGaugeMetricToStorage("Sys", float64(m.Sys))
-	a.addGaugeMetricToStorage("TotalAlloc", float64(m.TotalAlloc))
-	a.addGaugeMetricToStorage("RandomValue", rand.Float64())
+// ApplyConfig передаёт конфигурацию в цикл агента. Если предыдущая ещё
+// не применена, она заменяется более новой.
+func (a *Agent) ApplyConfig(cfg entity.AgentConfig) {
+	for {
+		select {
+		case a.configCh <- cfg:
+			return
+		default:
+		}
+		select {
+		case <-a.configCh:
+		default:
+		}
+	}
 }
 
-func (a *Agent) addGaugeMetricToStorage(name string, value float64) {
-	metric := entity.Metric{
-		MType: entity.Gauge,
-		ID:    name,
-		Value: &value,
+func (a *Agent) applyConfig(cfg entity.AgentConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
 	}
-
-	err := a.storage.AddMetric(metric)
-	if err != nil {
-		a.logger.Fatal("Add gauge error", zap.Error(err))
+	collectors := a.collectors
+	if cfg.Collectors != nil {
+		collectors = make([]Collector, 0, len(cfg.Collectors))
+		for _, name := range cfg.Collectors {
+			collector := a.findCollector(name)
+			if collector == nil {
+				return fmt.Errorf("unknown collector: %s", name)
+			}
+			collectors = append(collectors, collector)
+		}
 	}
-}
-
-func (a *Agent) addCounterMetricToStorage(name string, value int64) {
-	metric := entity.Metric{
-		MType: entity.Counter,
-		ID:    name,
-		Delta: &value,
+	if cfg.Aggregation != nil {
+		a.aggregation = cfg.Aggregation
 	}
 
-	err := a.storage.AddMetric(metric)
-	if err != nil {
-		a.logger.Fatal("Add counter error", zap.Error(err))
+	now := time.Now()
+	a.collectors = collectors
+	if cfg.Include != nil || cfg.Exclude != nil {
+		a.filter = Filter{Include: cfg.Include, Exclude: cfg.Exclude}
 	}
-}
-
-func (a *Agent) increasePollIteration() {
-	a.addCounterMetricToStorage("PollCount", 1)
-}
-
-func (a *Agent) sendMetrics() error {
-	allMetrics, err := a.storage.GetAllMetrics()
-	if err != nil {
-		a.logger.Error("Get all error:", zap.Error(err))
-		return err
+	if cfg.PollInterval > 0 {
+		a.pollInterval = time.Duration(cfg.PollInterval) * time.Second
 	}
+	for name, seconds := range cfg.CollectorIntervals {
+		a.collectorIntervals[name] = time.Duration(seconds) * time.Second
+	}
+	a.buildSchedule(now)
 
-	var metricsForSend []entity.Metric
-	for _, typedMetrics := range allMetrics {
-		for _, metric := range typedMetrics {
-			metricsForSend = append(metricsForSend, metric)
+	if cfg.ReportInterval > 0 {
+		interval := time.Duration(cfg.ReportInterval) * time.Second
+		if p := a.pipelines[0]; p.interval != interval {
+			p.interval = interval
+			p.next = now.Add(interval)
 		}
 	}
-	jsonMetrics, err := json.Marshal(metricsForSend)
-	if err != nil {
-		a.logger.Error("Marshaling error:", zap.Error(err))
-		return err
-	}
 
-	var gzippedMetric bytes.Buffer
-	zb := gzip.NewWriter(&gzippedMetric)
+	return nil
+}
 
-	_, err = zb.Write(jsonMetrics)
-	if err != nil {
-		a.logger.Error("Failed to gzip metrics:", zap.Error(err))
-		return err
-	}
-	err = zb.Close()
-	if err != nil {
-		return err
+func (a *Agent) findCollector(name string) Collector {
+	for _, collector := range a.available {
+		if collector.Name() == name {
+			return collector
+		}
 	}
+	return nil
+}
 
-	url := fmt.Sprintf("%s/", a.hookPath)
-	req := resty.New().R()
-	req.Method = http.MethodPost
-	req.URL = url
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Content-Encoding", "gzip")
-	req.SetBody(gzippedMetric.Bytes())
-
-	res, err := req.Send()
+// collect опрашивает коллектор. Режимы агрегации и фильтр передаются снимком,
+// потому что цикл агента меняет их при применении конфигурации.
+func (a *Agent) collect(ctx context.Context, collector Collector, modes []string, filter Filter) {
+	metrics, err := collector.Collect(ctx)
 	if err != nil {
-		a.logger.Error("Failed to send metrics", zap.Error(err))
-		return err
-	}
-	if res.StatusCode() != http.StatusOK {
-		a.logger.Error("Failed to send metric: wrong response code: ", zap.Int("status", res.StatusCode()))
+		a.logger.Error("Collect error", zap.String("collector", collector.Name()), zap.Error(err))
+		return
 	}
 
-	return nil
+	for _, metric := range metrics {
+		if !filter.Match(metric.ID) {
+			continue
+		}
+		for _, p := range a.pipelines {
+			if err := p.add(metric, modes); err != nil {
+				a.logger.Error("Add metric error", zap.String("pipeline", p.name), zap.Error(err))
+			}
+		}
+	}
 }
 
 func SaveMetricsInFileAgent(storage service.Repository, fileStoragePath string, storeInterval time.Duration, ctx context.Context) error {
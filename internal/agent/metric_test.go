@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+	"github.com/WPGe/go-yandex-advanced/internal/storage"
+)
+
+// blockingCollector отвечает только после отмены ctx.
+type blockingCollector struct {
+	calls int
+	mu    sync.Mutex
+}
+
+func (c *blockingCollector) Name() string { return "blocking" }
+
+func (c *blockingCollector) Collect(ctx context.Context) ([]entity.Metric, error) {
+	c.mu.Lock()
+	c.calls++
+	c.mu.Unlock()
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+type funcCollector func() []entity.Metric
+
+func (c funcCollector) Name() string { return "func" }
+
+func (c funcCollector) Collect(context.Context) ([]entity.Metric, error) { return c(), nil }
+
+// syncExporter запоминает отправленные счётчики и отказывает, пока fail == true.
+type syncExporter struct {
+	mu    sync.Mutex
+	fail  bool
+	total int64
+}
+
+func (e *syncExporter) Export(metrics []entity.Metric) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.fail {
+		return errors.New("unavailable")
+	}
+	for _, m := range metrics {
+		if m.MType == entity.Counter && m.ID == "ticks" {
+			e.total += *m.Delta
+		}
+	}
+	return nil
+}
+
+func (e *syncExporter) sent() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.total
+}
+
+func TestRunConcurrentCollectAndSend(t *testing.T) {
+	logger := zap.NewNop()
+	blocking := &blockingCollector{}
+	var collected atomic.Int64
+	ticks := funcCollector(func() []entity.Metric {
+		collected.Add(1)
+		return []entity.Metric{counterMetric("ticks", 1)}
+	})
+	exporter := &syncExporter{}
+	a := NewAgent(logger, storage.NewMemStorage(logger), exporter,
+		WithCollectors(blocking, ticks),
+		WithIntervals(10*time.Millisecond, 30*time.Millisecond),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx) }()
+
+	require.Eventually(t, func() bool { return exporter.sent() >= 5 }, 5*time.Second, 10*time.Millisecond,
+		"a blocked collector must not hold back the others")
+	blocking.mu.Lock()
+	assert.Equal(t, 1, blocking.calls, "collector still in progress must be skipped")
+	blocking.mu.Unlock()
+
+	// Отправка с повторами идёт в своей горутине и прерывается отменой ctx.
+	exporter.mu.Lock()
+	exporter.fail = true
+	exporter.mu.Unlock()
+	time.Sleep(100 * time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not stop after cancel")
+	}
+
+	exporter.mu.Lock()
+	exporter.fail = false
+	exporter.mu.Unlock()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second)
+	defer shutdownCancel()
+	require.NoError(t, a.Shutdown(shutdownCtx))
+	assert.Equal(t, collected.Load(), exporter.sent(), "every tick must be sent exactly once")
+}
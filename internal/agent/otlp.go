@@ -0,0 +1,380 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+const (
+	OTLPProtocolProtobuf = "http/protobuf"
+	OTLPProtocolJSON     = "http/json"
+
+	TemporalityCumulative = "cumulative"
+	TemporalityDelta      = "delta"
+
+	otlpTemporalityDelta      = 1
+	otlpTemporalityCumulative = 2
+
+	otlpMetricsPath = "/v1/metrics"
+	otlpScopeName   = "github.com/WPGe/go-yandex-advanced/agent"
+	otlpServiceName = "metrics-agent"
+)
+
+// OTLPExporter отправляет метрики в OpenTelemetry-коллектор по OTLP/HTTP.
+// Гейджи становятся Gauge, счётчики — монотонной Sum. Счётчики агента приходят
+// дельтами за окно отправки, для cumulative экспортер сам копит итог.
+// В режиме dry-run запрос печатается в OTLP/JSON вместо отправки.
+type OTLPExporter struct {
+	logger      *zap.Logger
+	endpoint    string
+	protocol    string
+	temporality string
+	instance    string
+	client      *resty.Client
+	dryRun      io.Writer
+
+	mu         sync.Mutex
+	startTime  time.Time
+	lastExport time.Time
+	totals     map[string]int64
+}
+
+func NewOTLPExporter(logger *zap.Logger, endpoint, protocol, temporality, instance string, tlsConfig *tls.Config, dryRun bool) (*OTLPExporter, error) {
+	if protocol == "" {
+		protocol = OTLPProtocolProtobuf
+	}
+	if protocol != OTLPProtocolProtobuf && protocol != OTLPProtocolJSON {
+		return nil, fmt.Errorf("unknown OTLP protocol: %s", protocol)
+	}
+	if temporality == "" {
+		temporality = TemporalityCumulative
+	}
+	if temporality != TemporalityCumulative && temporality != TemporalityDelta {
+		return nil, fmt.Errorf("unknown OTLP temporality: %s", temporality)
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint: %s", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpMetricsPath
+	}
+
+	client := resty.New()
+	if tlsConfig != nil {
+		client.SetTLSClientConfig(tlsConfig)
+	}
+
+	now := time.Now()
+	e := &OTLPExporter{
+		logger:      logger,
+		endpoint:    u.String(),
+		protocol:    protocol,
+		temporality: temporality,
+		instance:    instance,
+		client:      client,
+		startTime:   now,
+		lastExport:  now,
+		totals:      make(map[string]int64),
+	}
+	if dryRun {
+		e.dryRun = os.Stdout
+	}
+	return e, nil
+}
+
+func (e *OTLPExporter) Export(metrics []entity.Metric) error {
+	now := time.Now()
+	request, totals := e.buildRequest(metrics, now)
+	if e.dryRun != nil {
+		encoder := json.NewEncoder(e.dryRun)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(request); err != nil {
+			return fmt.Errorf("failed to encode OTLP request: %w", err)
+		}
+		e.commit(totals, now)
+		return nil
+	}
+
+	var body []byte
+	contentType := "application/x-protobuf"
+	if e.protocol == OTLPProtocolJSON {
+		contentType = "application/json"
+		var err error
+		if body, err = json.Marshal(request); err != nil {
+			return fmt.Errorf("failed to marshal OTLP request: %w", err)
+		}
+	} else {
+		body = request.appendProto(nil)
+	}
+
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	if _, err := zw.Write(body); err != nil {
+		return fmt.Errorf("failed to gzip OTLP request: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to gzip OTLP request: %w", err)
+	}
+
+	res, err := e.client.R().
+		SetHeader("Content-Type", contentType).
+		SetHeader("Content-Encoding", "gzip").
+		SetBody(gzipped.Bytes()).
+		Post(e.endpoint)
+	if err != nil {
+		e.logger.Error("Failed to export OTLP metrics", zap.Error(err))
+		return err
+	}
+	if res.StatusCode() != http.StatusOK {
+		return fmt.Errorf("OTLP export failed with status %d: %s", res.StatusCode(), res.String())
+	}
+
+	e.commit(totals, now)
+	return nil
+}
+
+// buildRequest собирает запрос и новые итоги счётчиков. Итоги и начало
+// следующего окна запоминаются в commit только после успешной отправки,
+// иначе повтор той же пачки прибавил бы её к итогам ещё раз.
+func (e *OTLPExporter) buildRequest(metrics []entity.Metric, now time.Time) (otlpRequest, map[string]int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	sorted := make([]entity.Metric, len(metrics))
+	copy(sorted, metrics)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].MType != sorted[j].MType {
+			return sorted[i].MType < sorted[j].MType
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	nowNano := uint64(now.UnixNano())
+	totals := make(map[string]int64)
+	var out []otlpMetric
+	for _, metric := range sorted {
+		switch {
+		case metric.MType == entity.Gauge && metric.Value != nil:
+			value := *metric.Value
+			out = append(out, otlpMetric{
+				Name: metric.ID,
+				Gauge: &otlpGauge{DataPoints: []otlpDataPoint{{
+					TimeUnixNano: nowNano,
+					AsDouble:     &value,
+				}}},
+			})
+		case metric.MType == entity.Counter && metric.Delta != nil:
+			value := *metric.Delta
+			start := e.lastExport
+			temporality := otlpTemporalityDelta
+			if e.temporality == TemporalityCumulative {
+				value += e.totals[metric.ID]
+				totals[metric.ID] = value
+				start = e.startTime
+				temporality = otlpTemporalityCumulative
+			}
+			out = append(out, otlpMetric{
+				Name: metric.ID,
+				Sum: &otlpSum{
+					DataPoints: []otlpDataPoint{{
+						StartTimeUnixNano: uint64(start.UnixNano()),
+						TimeUnixNano:      nowNano,
+						AsInt:             &value,
+					}},
+					AggregationTemporality: temporality,
+					IsMonotonic:            true,
+				},
+			})
+		}
+	}
+
+	return otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{Key: "service.name", Value: otlpAnyValue{StringValue: otlpServiceName}},
+			{Key: "service.instance.id", Value: otlpAnyValue{StringValue: e.instance}},
+		}},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: otlpScopeName},
+			Metrics: out,
+		}},
+	}}}, totals
+}
+
+func (e *OTLPExporter) commit(totals map[string]int64, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for id, total := range totals {
+		e.totals[id] = total
+	}
+	e.lastExport = now
+}
+
+// Структуры ниже повторяют opentelemetry/proto/collector/metrics/v1 в объёме,
+// нужном агенту. JSON-теги соответствуют OTLP/JSON, appendProto кодирует
+// те же поля в protobuf по номерам из .proto.
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpDataPoint struct {
+	StartTimeUnixNano uint64   `json:"startTimeUnixNano,string,omitempty"`
+	TimeUnixNano      uint64   `json:"timeUnixNano,string"`
+	AsDouble          *float64 `json:"asDouble,omitempty"`
+	AsInt             *int64   `json:"asInt,string,omitempty"`
+}
+
+func (r otlpRequest) appendProto(b []byte) []byte {
+	for _, rm := range r.ResourceMetrics {
+		b = protoAppendBytes(b, 1, rm.appendProto(nil))
+	}
+	return b
+}
+
+func (r otlpResourceMetrics) appendProto(b []byte) []byte {
+	b = protoAppendBytes(b, 1, r.Resource.appendProto(nil))
+	for _, sm := range r.ScopeMetrics {
+		b = protoAppendBytes(b, 2, sm.appendProto(nil))
+	}
+	return b
+}
+
+func (r otlpResource) appendProto(b []byte) []byte {
+	for _, kv := range r.Attributes {
+		b = protoAppendBytes(b, 1, kv.appendProto(nil))
+	}
+	return b
+}
+
+func (kv otlpKeyValue) appendProto(b []byte) []byte {
+	b = protoAppendBytes(b, 1, []byte(kv.Key))
+	return protoAppendBytes(b, 2, protoAppendBytes(nil, 1, []byte(kv.Value.StringValue)))
+}
+
+func (s otlpScopeMetrics) appendProto(b []byte) []byte {
+	b = protoAppendBytes(b, 1, protoAppendBytes(nil, 1, []byte(s.Scope.Name)))
+	for _, m := range s.Metrics {
+		b = protoAppendBytes(b, 2, m.appendProto(nil))
+	}
+	return b
+}
+
+func (m otlpMetric) appendProto(b []byte) []byte {
+	b = protoAppendBytes(b, 1, []byte(m.Name))
+	if m.Gauge != nil {
+		var gauge []byte
+		for _, dp := range m.Gauge.DataPoints {
+			gauge = protoAppendBytes(gauge, 1, dp.appendProto(nil))
+		}
+		b = protoAppendBytes(b, 5, gauge)
+	}
+	if m.Sum != nil {
+		var sum []byte
+		for _, dp := range m.Sum.DataPoints {
+			sum = protoAppendBytes(sum, 1, dp.appendProto(nil))
+		}
+		sum = protoAppendVarint(sum, 2, uint64(m.Sum.AggregationTemporality))
+		if m.Sum.IsMonotonic {
+			sum = protoAppendVarint(sum, 3, 1)
+		}
+		b = protoAppendBytes(b, 7, sum)
+	}
+	return b
+}
+
+func (dp otlpDataPoint) appendProto(b []byte) []byte {
+	if dp.StartTimeUnixNano != 0 {
+		b = protoAppendFixed64(b, 2, dp.StartTimeUnixNano)
+	}
+	b = protoAppendFixed64(b, 3, dp.TimeUnixNano)
+	if dp.AsDouble != nil {
+		b = protoAppendFixed64(b, 4, math.Float64bits(*dp.AsDouble))
+	}
+	if dp.AsInt != nil {
+		b = protoAppendFixed64(b, 6, uint64(*dp.AsInt))
+	}
+	return b
+}
+
+func protoAppendTag(b []byte, field int, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func protoAppendVarint(b []byte, field int, v uint64) []byte {
+	b = protoAppendTag(b, field, 0)
+	return binary.AppendUvarint(b, v)
+}
+
+func protoAppendFixed64(b []byte, field int, v uint64) []byte {
+	b = protoAppendTag(b, field, 1)
+	return binary.LittleEndian.AppendUint64(b, v)
+}
+
+func protoAppendBytes(b []byte, field int, v []byte) []byte {
+	b = protoAppendTag(b, field, 2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
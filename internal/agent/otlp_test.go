@@ -0,0 +1,333 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+// otlpPoint — точка, прочитанная из запроса независимо от формата.
+type otlpPoint struct {
+	kind        string
+	value       float64
+	start       uint64
+	temporality int
+	monotonic   bool
+}
+
+func otlpTestMetrics(gauge float64, delta int64) []entity.Metric {
+	return []entity.Metric{
+		{ID: "PollCount", MType: entity.Counter, Delta: &delta},
+		{ID: "Alloc", MType: entity.Gauge, Value: &gauge},
+	}
+}
+
+func TestOTLPBuildRequest(t *testing.T) {
+	tests := []struct {
+		temporality string
+		want        []int64
+		wantType    int
+	}{
+		{temporality: TemporalityCumulative, want: []int64{3, 5}, wantType: otlpTemporalityCumulative},
+		{temporality: TemporalityDelta, want: []int64{3, 2}, wantType: otlpTemporalityDelta},
+	}
+
+	for _, tt := range tests {
+		for _, protocol := range []string{OTLPProtocolProtobuf, OTLPProtocolJSON} {
+			t.Run(tt.temporality+" "+protocol, func(t *testing.T) {
+				e, err := NewOTLPExporter(zap.NewNop(), "http://localhost:4318", protocol, tt.temporality, "host-1", nil, false)
+				require.NoError(t, err)
+
+				start := time.Now()
+				for i, delta := range []int64{3, 2} {
+					now := start.Add(time.Duration(i+1) * time.Second)
+					prev := e.lastExport
+					request, totals := e.buildRequest(otlpTestMetrics(1.5, delta), now)
+					e.commit(totals, now)
+
+					var instance string
+					var points map[string]otlpPoint
+					if protocol == OTLPProtocolJSON {
+						instance, points = decodeOTLPJSON(t, request)
+					} else {
+						instance, points = decodeOTLPProto(t, request.appendProto(nil))
+					}
+					assert.Equal(t, "host-1", instance)
+					require.Len(t, points, 2)
+
+					assert.Equal(t, otlpPoint{kind: "gauge", value: 1.5}, points["Alloc"])
+
+					sum := points["PollCount"]
+					assert.Equal(t, "sum", sum.kind)
+					assert.Equal(t, float64(tt.want[i]), sum.value)
+					assert.Equal(t, tt.wantType, sum.temporality)
+					assert.True(t, sum.monotonic)
+					if tt.temporality == TemporalityCumulative {
+						assert.Equal(t, uint64(e.startTime.UnixNano()), sum.start)
+					} else {
+						assert.Equal(t, uint64(prev.UnixNano()), sum.start, "delta starts at the previous export")
+					}
+				}
+			})
+		}
+	}
+}
+
+func decodeOTLPJSON(t *testing.T, request otlpRequest) (string, map[string]otlpPoint) {
+	t.Helper()
+	body, err := json.Marshal(request)
+	require.NoError(t, err)
+
+	var decoded struct {
+		ResourceMetrics []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string `json:"key"`
+					Value struct {
+						StringValue string `json:"stringValue"`
+					} `json:"value"`
+				} `json:"attributes"`
+			} `json:"resource"`
+			ScopeMetrics []struct {
+				Metrics []struct {
+					Name  string `json:"name"`
+					Gauge *struct {
+						DataPoints []struct {
+							AsDouble float64 `json:"asDouble"`
+						} `json:"dataPoints"`
+					} `json:"gauge"`
+					Sum *struct {
+						DataPoints []struct {
+							StartTimeUnixNano string `json:"startTimeUnixNano"`
+							AsInt             string `json:"asInt"`
+						} `json:"dataPoints"`
+						AggregationTemporality int  `json:"aggregationTemporality"`
+						IsMonotonic            bool `json:"isMonotonic"`
+					} `json:"sum"`
+				} `json:"metrics"`
+			} `json:"scopeMetrics"`
+		} `json:"resourceMetrics"`
+	}
+	require.NoError(t, json.Unmarshal(body, &decoded))
+	require.Len(t, decoded.ResourceMetrics, 1)
+	rm := decoded.ResourceMetrics[0]
+
+	var instance string
+	for _, attr := range rm.Resource.Attributes {
+		if attr.Key == "service.instance.id" {
+			instance = attr.Value.StringValue
+		}
+	}
+
+	points := make(map[string]otlpPoint)
+	require.Len(t, rm.ScopeMetrics, 1)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		switch {
+		case m.Gauge != nil:
+			require.Len(t, m.Gauge.DataPoints, 1)
+			points[m.Name] = otlpPoint{kind: "gauge", value: m.Gauge.DataPoints[0].AsDouble}
+		case m.Sum != nil:
+			require.Len(t, m.Sum.DataPoints, 1)
+			var value float64
+			var start uint64
+			require.NoError(t, json.Unmarshal([]byte(m.Sum.DataPoints[0].AsInt), &value))
+			require.NoError(t, json.Unmarshal([]byte(m.Sum.DataPoints[0].StartTimeUnixNano), &start))
+			points[m.Name] = otlpPoint{
+				kind:        "sum",
+				value:       value,
+				start:       start,
+				temporality: m.Sum.AggregationTemporality,
+				monotonic:   m.Sum.IsMonotonic,
+			}
+		}
+	}
+	return instance, points
+}
+
+type protoField struct {
+	num   int
+	value uint64
+	bytes []byte
+}
+
+// parseProto разбирает одно сообщение protobuf на поля верхнего уровня.
+func parseProto(t *testing.T, b []byte) []protoField {
+	t.Helper()
+	var fields []protoField
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		require.Positive(t, n)
+		b = b[n:]
+		field := protoField{num: int(tag >> 3)}
+		switch tag & 7 {
+		case 0:
+			field.value, n = binary.Uvarint(b)
+			require.Positive(t, n)
+			b = b[n:]
+		case 1:
+			require.GreaterOrEqual(t, len(b), 8)
+			field.value = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case 2:
+			size, n := binary.Uvarint(b)
+			require.Positive(t, n)
+			require.GreaterOrEqual(t, uint64(len(b)-n), size)
+			field.bytes = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func protoMessages(t *testing.T, b []byte, num int) [][]byte {
+	t.Helper()
+	var messages [][]byte
+	for _, f := range parseProto(t, b) {
+		if f.num == num {
+			messages = append(messages, f.bytes)
+		}
+	}
+	return messages
+}
+
+func protoValue(t *testing.T, b []byte, num int) uint64 {
+	t.Helper()
+	for _, f := range parseProto(t, b) {
+		if f.num == num {
+			return f.value
+		}
+	}
+	return 0
+}
+
+func decodeOTLPProto(t *testing.T, body []byte) (string, map[string]otlpPoint) {
+	t.Helper()
+	resourceMetrics := protoMessages(t, body, 1)
+	require.Len(t, resourceMetrics, 1)
+
+	var instance string
+	resource := protoMessages(t, resourceMetrics[0], 1)
+	require.Len(t, resource, 1)
+	for _, kv := range protoMessages(t, resource[0], 1) {
+		key := protoMessages(t, kv, 1)
+		value := protoMessages(t, kv, 2)
+		if string(key[0]) == "service.instance.id" {
+			instance = string(protoMessages(t, value[0], 1)[0])
+		}
+	}
+
+	scopeMetrics := protoMessages(t, resourceMetrics[0], 2)
+	require.Len(t, scopeMetrics, 1)
+	points := make(map[string]otlpPoint)
+	for _, m := range protoMessages(t, scopeMetrics[0], 2) {
+		name := string(protoMessages(t, m, 1)[0])
+		if gauge := protoMessages(t, m, 5); len(gauge) > 0 {
+			dps := protoMessages(t, gauge[0], 1)
+			require.Len(t, dps, 1)
+			points[name] = otlpPoint{kind: "gauge", value: math.Float64frombits(protoValue(t, dps[0], 4))}
+		}
+		if sum := protoMessages(t, m, 7); len(sum) > 0 {
+			dps := protoMessages(t, sum[0], 1)
+			require.Len(t, dps, 1)
+			points[name] = otlpPoint{
+				kind:        "sum",
+				value:       float64(int64(protoValue(t, dps[0], 6))),
+				start:       protoValue(t, dps[0], 2),
+				temporality: int(protoValue(t, sum[0], 2)),
+				monotonic:   protoValue(t, sum[0], 3) == 1,
+			}
+		}
+	}
+	return instance, points
+}
+
+func TestOTLPExport(t *testing.T) {
+	var requests int
+	var body []byte
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(status)
+		assert.Equal(t, "/v1/metrics", r.URL.Path)
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		zr, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		body, err = io.ReadAll(zr)
+		require.NoError(t, err)
+	}))
+	defer server.Close()
+
+	e, err := NewOTLPExporter(zap.NewNop(), server.URL, "", "", "host-1", nil, false)
+	require.NoError(t, err)
+	require.NoError(t, e.Export(otlpTestMetrics(2.5, 4)))
+	assert.Equal(t, 1, requests)
+
+	_, points := decodeOTLPProto(t, body)
+	assert.Equal(t, 2.5, points["Alloc"].value)
+	assert.Equal(t, float64(4), points["PollCount"].value)
+
+	t.Run("failed export keeps totals", func(t *testing.T) {
+		status = http.StatusServiceUnavailable
+		require.Error(t, e.Export(otlpTestMetrics(2.5, 1)))
+		require.Error(t, e.Export(otlpTestMetrics(2.5, 1)))
+
+		status = http.StatusOK
+		require.NoError(t, e.Export(otlpTestMetrics(2.5, 1)))
+		_, points := decodeOTLPProto(t, body)
+		assert.Equal(t, float64(5), points["PollCount"].value, "retried batches must be counted once")
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		e, err := NewOTLPExporter(zap.NewNop(), server.URL, OTLPProtocolProtobuf, "", "host-1", nil, true)
+		require.NoError(t, err)
+		var out bytes.Buffer
+		e.dryRun = &out
+
+		sent := requests
+		require.NoError(t, e.Export(otlpTestMetrics(2.5, 4)))
+		assert.Equal(t, sent, requests, "dry run must not send anything")
+
+		var printed otlpRequest
+		require.NoError(t, json.Unmarshal(out.Bytes(), &printed))
+		_, points := decodeOTLPJSON(t, printed)
+		assert.Equal(t, 2.5, points["Alloc"].value)
+		assert.Equal(t, float64(4), points["PollCount"].value)
+	})
+}
+
+func TestOTLPExportTLS(t *testing.T) {
+	var requests int
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	e, err := NewOTLPExporter(zap.NewNop(), server.URL, "", "", "host-1", nil, false)
+	require.NoError(t, err)
+	require.Error(t, e.Export(otlpTestMetrics(2.5, 4)), "server CA is unknown without the agent TLS config")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	e, err = NewOTLPExporter(zap.NewNop(), server.URL, "", "", "host-1", &tls.Config{RootCAs: roots}, false)
+	require.NoError(t, err)
+	require.NoError(t, e.Export(otlpTestMetrics(2.5, 4)))
+	assert.Equal(t, 1, requests)
+}
@@ -0,0 +1,242 @@
+package agent
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+	"github.com/WPGe/go-yandex-advanced/internal/storage"
+)
+
+const DefaultPipeline = "default"
+
+// Pipeline — именованный конвейер отправки со своим интервалом, целью и фильтром.
+// У каждого конвейера свой буфер: отправленное убирается из него только после
+// успешной отправки во все экспортеры конвейера. Коллекторы пишут в буфер,
+// пока идёт отправка, поэтому он защищён mu, а экспорт идёт без блокировки.
+type Pipeline struct {
+	name       string
+	interval   time.Duration
+	targets    []*target
+	filter     Filter
+	storage    *storage.MemStorage
+	aggregator *aggregator
+	next       time.Time
+	sending    atomic.Bool
+
+	mu     sync.Mutex
+	buffer *buffer
+}
+
+// target — экспортер конвейера и то, что он уже принял из текущего буфера.
+// Повторная отправка уносит ему только прирост счётчиков, поэтому экспортер,
+// который принял пачку, не получит её дважды, если отказал другой экспортер.
+type target struct {
+	exporter Exporter
+	sent     map[metricKey]entity.Metric
+}
+
+func NewPipeline(logger *zap.Logger, name string, interval time.Duration, exporter Exporter, filter Filter) *Pipeline {
+	return newPipeline(name, interval, exporter, filter, storage.NewMemStorage(logger))
+}
+
+func newPipeline(name string, interval time.Duration, exporter Exporter, filter Filter, storage *storage.MemStorage) *Pipeline {
+	exporters := []Exporter{exporter}
+	if multi, ok := exporter.(MultiExporter); ok {
+		exporters = multi
+	}
+	targets := make([]*target, 0, len(exporters))
+	for _, e := range exporters {
+		targets = append(targets, &target{exporter: e, sent: make(map[metricKey]entity.Metric)})
+	}
+
+	return &Pipeline{
+		name:       name,
+		interval:   interval,
+		targets:    targets,
+		filter:     filter,
+		storage:    storage,
+		aggregator: newAggregator(),
+		buffer:     newBuffer(),
+	}
+}
+
+func (p *Pipeline) Name() string {
+	return p.name
+}
+
+func (p *Pipeline) add(metric entity.Metric, modes []string) error {
+	if !p.filter.Match(metric.ID) {
+		return nil
+	}
+
+	aggregated := metric.MType == entity.Gauge && metric.Value != nil && len(modes) > 0
+	keys := []metricKey{{mType: metric.MType, id: metric.ID}}
+	if aggregated {
+		keys = keys[:0]
+		for _, mode := range modes {
+			keys = append(keys, metricKey{mType: entity.Gauge, id: aggregatedID(metric.ID, mode)})
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.buffer.reserve(keys, p.evict) {
+		return nil
+	}
+	if aggregated {
+		p.aggregator.observe(metric.ID, *metric.Value, modes)
+		return nil
+	}
+
+	// Хранилище меняет Delta по указателю, поэтому у каждого конвейера своя копия.
+	return p.storage.AddMetric(metric.Clone())
+}
+
+func (p *Pipeline) evict(key metricKey) {
+	if key.mType == entity.Gauge {
+		p.aggregator.drop(key.id)
+	}
+	_ = p.storage.DeleteMetric(key.id, key.mType)
+	for _, t := range p.targets {
+		delete(t.sent, key)
+	}
+}
+
+// send отправляет снимок буфера в каждый экспортер через retry и возвращает
+// этот снимок. Ошибка одного экспортера не мешает остальным, буфер при этом
+// остаётся до следующей отправки.
+func (p *Pipeline) send(retry func(export func() error) error) ([]entity.Metric, error) {
+	p.mu.Lock()
+	metrics, err := p.snapshot()
+	batches := make([][]entity.Metric, len(p.targets))
+	for i, t := range p.targets {
+		batches[i] = t.unsent(metrics)
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for i, t := range p.targets {
+		batch := batches[i]
+		if len(batch) == 0 {
+			continue
+		}
+		if err := retry(func() error { return t.exporter.Export(batch) }); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		p.mu.Lock()
+		for _, metric := range metrics {
+			t.sent[metricKey{mType: metric.MType, id: metric.ID}] = metric
+		}
+		p.mu.Unlock()
+	}
+
+	return metrics, errors.Join(errs...)
+}
+
+// snapshot переносит окно агрегации и счётчик выброшенных метрик в хранилище
+// и возвращает копию содержимого: хранилище меняет Delta по указателю.
+// Окно агрегации подтверждается только в clear, поэтому после неудачной
+// отправки следующее окно сливается с ним, а не затирает его значения.
+func (p *Pipeline) snapshot() ([]entity.Metric, error) {
+	if err := p.storage.AddMetrics(p.aggregator.flush()); err != nil {
+		return nil, err
+	}
+	if p.buffer.dropped > 0 {
+		if err := p.storage.AddMetric(counterMetric(DroppedMetricsID, p.buffer.dropped)); err != nil {
+			return nil, err
+		}
+		p.buffer.dropped = 0
+	}
+
+	allMetrics, err := p.storage.GetAllMetrics()
+	if err != nil {
+		return nil, err
+	}
+
+	var metrics []entity.Metric
+	for _, typedMetrics := range allMetrics {
+		for _, metric := range typedMetrics {
+			metrics = append(metrics, metric.Clone())
+		}
+	}
+	return metrics, nil
+}
+
+// unsent возвращает то, чего экспортер ещё не получил: прирост счётчиков
+// с прошлой успешной отправки и новые метрики. Гейджи отправляются всегда.
+func (t *target) unsent(metrics []entity.Metric) []entity.Metric {
+	if len(t.sent) == 0 {
+		return metrics
+	}
+
+	batch := make([]entity.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		prev, ok := t.sent[metricKey{mType: metric.MType, id: metric.ID}]
+		switch {
+		case !ok, metric.MType == entity.Gauge:
+			batch = append(batch, metric)
+		case metric.MType == entity.Counter && metric.Delta != nil && prev.Delta != nil:
+			if delta := *metric.Delta - *prev.Delta; delta != 0 {
+				metric.Delta = &delta
+				batch = append(batch, metric)
+			}
+		}
+	}
+	return batch
+}
+
+// clear убирает из буфера снимок sent, который приняли все экспортеры.
+// Что пришло после снимка, остаётся: прирост счётчиков и новые значения гейджей.
+func (p *Pipeline) clear(sent []entity.Metric) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, t := range p.targets {
+		t.sent = make(map[metricKey]entity.Metric)
+	}
+	p.aggregator.commit()
+	for _, metric := range sent {
+		current, err := p.storage.GetMetric(metric.ID, metric.MType)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case metric.MType == entity.Counter && metric.Delta != nil && current.Delta != nil && *current.Delta != *metric.Delta:
+			if err := p.storage.DeleteMetric(metric.ID, metric.MType); err != nil {
+				return err
+			}
+			if err := p.storage.AddMetric(counterMetric(metric.ID, *current.Delta-*metric.Delta)); err != nil {
+				return err
+			}
+			continue
+		case metric.MType == entity.Gauge && metric.Value != nil && current.Value != nil && *current.Value != *metric.Value:
+			continue
+		}
+
+		if err := p.storage.DeleteMetric(metric.ID, metric.MType); err != nil {
+			return err
+		}
+		p.buffer.release(metricKey{mType: metric.MType, id: metric.ID})
+	}
+	return nil
+}
+
+// nextRun сдвигает расписание на интервал, пропуская запуски, которые уже опоздали.
+func nextRun(prev time.Time, interval time.Duration, now time.Time) time.Time {
+	next := prev.Add(interval)
+	if next.Before(now) {
+		return now.Add(interval)
+	}
+	return next
+}
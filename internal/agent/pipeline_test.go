@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+// recordingExporter запоминает принятые пачки и отказывает первые fails раз.
+type recordingExporter struct {
+	fails   int
+	batches [][]entity.Metric
+}
+
+func (e *recordingExporter) Export(metrics []entity.Metric) error {
+	if e.fails > 0 {
+		e.fails--
+		return errors.New("unavailable")
+	}
+	e.batches = append(e.batches, metrics)
+	return nil
+}
+
+func (e *recordingExporter) counters() []int64 {
+	var deltas []int64
+	for _, batch := range e.batches {
+		for _, m := range batch {
+			if m.MType == entity.Counter && m.ID == "hits" {
+				deltas = append(deltas, *m.Delta)
+			}
+		}
+	}
+	return deltas
+}
+
+func sendOnce(export func() error) error {
+	return export()
+}
+
+func TestPipelineSendPerExporter(t *testing.T) {
+	server := &recordingExporter{}
+	collector := &recordingExporter{fails: 2}
+	p := NewPipeline(zap.NewNop(), "test", 0, MultiExporter{server, collector}, Filter{})
+
+	require.NoError(t, p.add(counterMetric("hits", 3), nil))
+	_, err := p.send(sendOnce)
+	require.Error(t, err)
+	assert.Equal(t, []int64{3}, server.counters())
+	assert.Empty(t, collector.counters())
+
+	require.NoError(t, p.add(counterMetric("hits", 2), nil))
+	_, err = p.send(sendOnce)
+	require.Error(t, err)
+	assert.Equal(t, []int64{3, 2}, server.counters(), "server gets only the increase")
+
+	// Повтор внутри одной отправки тоже не дублирует пачку экспортеру, который её принял.
+	var attempts int
+	sent, err := p.send(func(export func() error) error {
+		attempts++
+		return export()
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, attempts, "nothing new for the server, one attempt for the collector")
+	assert.Equal(t, []int64{3, 2}, server.counters())
+	assert.Equal(t, []int64{5}, collector.counters())
+	// Прирост после снимка остаётся в буфере и уходит обоим экспортерам.
+	require.NoError(t, p.add(counterMetric("hits", 1), nil))
+	require.NoError(t, p.clear(sent))
+
+	_, err = p.send(sendOnce)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{3, 2, 1}, server.counters())
+	assert.Equal(t, []int64{5, 1}, collector.counters())
+}
+
+func TestPipelineKeepsWindowAfterFailedSend(t *testing.T) {
+	exporter := &recordingExporter{fails: 1}
+	p := NewPipeline(zap.NewNop(), "test", 0, exporter, Filter{})
+	modes := []string{AggregateMax, AggregateCount}
+
+	require.NoError(t, p.add(gaugeMetric("HeapAlloc", 100), modes))
+	_, err := p.send(sendOnce)
+	require.Error(t, err)
+
+	require.NoError(t, p.add(gaugeMetric("HeapAlloc", 3), modes))
+	sent, err := p.send(sendOnce)
+	require.NoError(t, err)
+	require.NoError(t, p.clear(sent))
+
+	values := make(map[string]float64)
+	for _, m := range exporter.batches[0] {
+		values[m.ID] = *m.Value
+	}
+	assert.Equal(t, 100.0, values["HeapAlloc.max"], "spike from the failed send survives")
+	assert.Equal(t, 2.0, values["HeapAlloc.count"])
+
+	require.NoError(t, p.add(gaugeMetric("HeapAlloc", 7), modes))
+	_, err = p.send(sendOnce)
+	require.NoError(t, err)
+	values = make(map[string]float64)
+	for _, m := range exporter.batches[1] {
+		values[m.ID] = *m.Value
+	}
+	assert.Equal(t, 7.0, values["HeapAlloc.max"], "confirmed window is not merged again")
+	assert.Equal(t, 1.0, values["HeapAlloc.count"])
+}
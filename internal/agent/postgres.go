@@ -0,0 +1,260 @@
+package agent
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+// pgGaugeColumns — колонки pg_stat_database, которые показывают текущее
+// состояние, а не накопленный итог.
+var pgGaugeColumns = map[string]bool{
+	"numbackends": true,
+}
+
+// pgFloatColumns — накопленные итоги в миллисекундах с дробной частью. Счётчик
+// целочисленный и потерял бы дробь, поэтому они отдаются гейджами как есть.
+var pgFloatColumns = map[string]bool{
+	"blk_read_time":            true,
+	"blk_write_time":           true,
+	"session_time":             true,
+	"active_time":              true,
+	"idle_in_transaction_time": true,
+	"checkpoint_write_time":    true,
+	"checkpoint_sync_time":     true,
+	"write_time":               true,
+	"sync_time":                true,
+}
+
+// pgConnectionStates перечисляет состояния pg_stat_activity, чтобы пропавшее
+// состояние отдавалось нулём, а не последним значением.
+var pgConnectionStates = []string{
+	"active",
+	"idle",
+	"idle in transaction",
+	"idle in transaction (aborted)",
+	"fastpath function call",
+	"disabled",
+}
+
+type PostgresTarget struct {
+	Name string
+	DSN  string
+}
+
+// PostgresCollector читает статистику Postgres. Накопленные итоги из
+// pg_stat_database, pg_stat_bgwriter и pg_stat_checkpointer превращаются
+// в счётчики по разнице с прошлым опросом, первый опрос только запоминает базу.
+// Дробные итоги (время чтения, записи и сессий) отдаются гейджами.
+// Число подключений по состояниям и отставание реплик отдаются гейджами.
+type PostgresCollector struct {
+	targets []postgresTarget
+	timeout time.Duration
+}
+
+type postgresTarget struct {
+	name string
+	db   *sql.DB
+	prev map[string]int64
+}
+
+func NewPostgresCollector(targets []PostgresTarget, timeout time.Duration) (*PostgresCollector, error) {
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+
+	c := &PostgresCollector{timeout: timeout}
+	for _, target := range targets {
+		if target.Name == "" || target.DSN == "" {
+			return nil, fmt.Errorf("postgres target requires a name and a dsn")
+		}
+		db, err := sql.Open("pgx", target.DSN)
+		if err != nil {
+			return nil, fmt.Errorf("postgres %s: %w", target.Name, err)
+		}
+		db.SetMaxOpenConns(1)
+		c.targets = append(c.targets, postgresTarget{
+			name: target.Name,
+			db:   db,
+			prev: make(map[string]int64),
+		})
+	}
+	return c, nil
+}
+
+func (c *PostgresCollector) Name() string {
+	return "postgres"
+}
+
+func (c *PostgresCollector) Collect(ctx context.Context) ([]entity.Metric, error) {
+	var metrics []entity.Metric
+	for i := range c.targets {
+		metrics = append(metrics, c.collectTarget(ctx, &c.targets[i])...)
+	}
+	return metrics, nil
+}
+
+func (c *PostgresCollector) Close() error {
+	for _, target := range c.targets {
+		if err := target.db.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *PostgresCollector) collectTarget(ctx context.Context, target *postgresTarget) []entity.Metric {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	prefix := "postgres." + target.name + "."
+	totals := make(map[string]float64)
+	gauges := make(map[string]float64)
+
+	databases, err := queryJSONRows(ctx, target.db,
+		"SELECT datname, row_to_json(d) FROM pg_stat_database d WHERE datname IS NOT NULL AND NOT datname LIKE 'template%'")
+	if err != nil {
+		return []entity.Metric{gaugeMetric(prefix+"up", 0)}
+	}
+	for datname, row := range databases {
+		for column, value := range row {
+			id := "database." + datname + "." + column
+			if pgGaugeColumns[column] || pgFloatColumns[column] {
+				gauges[id] = value
+			} else {
+				totals[id] = value
+			}
+		}
+	}
+
+	// С Postgres 17 статистика контрольных точек переехала в pg_stat_checkpointer,
+	// поэтому обе выборки необязательны.
+	for _, view := range []string{"bgwriter", "checkpointer"} {
+		rows, err := queryJSONRows(ctx, target.db, fmt.Sprintf("SELECT '%s', row_to_json(s) FROM pg_stat_%s s", view, view))
+		if err != nil {
+			continue
+		}
+		for column, value := range rows[view] {
+			if pgFloatColumns[column] {
+				gauges[view+"."+column] = value
+			} else {
+				totals[view+"."+column] = value
+			}
+		}
+	}
+
+	for _, state := range pgConnectionStates {
+		gauges["connections."+pgName(state)] = 0
+	}
+	if err := c.queryConnections(ctx, target.db, gauges); err != nil {
+		return []entity.Metric{gaugeMetric(prefix+"up", 0)}
+	}
+	c.queryReplication(ctx, target.db, gauges)
+
+	metrics := []entity.Metric{gaugeMetric(prefix+"up", 1)}
+	for id, value := range gauges {
+		metrics = append(metrics, gaugeMetric(prefix+id, value))
+	}
+	for id, value := range totals {
+		current := int64(value)
+		prev, ok := target.prev[id]
+		target.prev[id] = current
+		if !ok {
+			continue
+		}
+		delta := current - prev
+		if delta < 0 {
+			// Статистику сбросили: всё, что накопилось после сброса, — новое.
+			delta = current
+		}
+		metrics = append(metrics, counterMetric(prefix+id, delta))
+	}
+	return metrics
+}
+
+func (c *PostgresCollector) queryConnections(ctx context.Context, db *sql.DB, gauges map[string]float64) error {
+	rows, err := db.QueryContext(ctx,
+		"SELECT coalesce(state, 'unknown'), count(*) FROM pg_stat_activity WHERE backend_type = 'client backend' GROUP BY 1")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var state string
+		var count float64
+		if err := rows.Scan(&state, &count); err != nil {
+			return err
+		}
+		gauges["connections."+pgName(state)] = count
+	}
+	return rows.Err()
+}
+
+// queryReplication отдаёт отставание каждой реплики на мастере и отставание
+// воспроизведения на самой реплике.
+func (c *PostgresCollector) queryReplication(ctx context.Context, db *sql.DB, gauges map[string]float64) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT coalesce(application_name, client_addr::text, 'unknown'), coalesce(EXTRACT(EPOCH FROM replay_lag), 0) FROM pg_stat_replication")
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			var lag float64
+			if err := rows.Scan(&name, &lag); err != nil {
+				break
+			}
+			gauges["replication."+pgName(name)+".lag_seconds"] = lag
+		}
+	}
+
+	var lag sql.NullFloat64
+	err = db.QueryRowContext(ctx,
+		"SELECT CASE WHEN pg_is_in_recovery() THEN coalesce(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END").Scan(&lag)
+	if err == nil && lag.Valid {
+		gauges["replication.lag_seconds"] = lag.Float64
+	}
+}
+
+// queryJSONRows выполняет запрос вида SELECT key, row_to_json(...) и оставляет
+// числовые поля. row_to_json не зависит от набора колонок в версии Postgres.
+func queryJSONRows(ctx context.Context, db *sql.DB, query string) (map[string]map[string]float64, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]map[string]float64)
+	for rows.Next() {
+		var key string
+		var raw []byte
+		if err := rows.Scan(&key, &raw); err != nil {
+			return nil, err
+		}
+		var row map[string]interface{}
+		if err := json.Unmarshal(raw, &row); err != nil {
+			return nil, err
+		}
+
+		values := make(map[string]float64)
+		for column, value := range row {
+			if v, ok := value.(float64); ok && column != "datid" {
+				values[column] = v
+			}
+		}
+		result[key] = values
+	}
+	return result, rows.Err()
+}
+
+func pgName(s string) string {
+	return strings.NewReplacer(" ", "_", "(", "", ")", "").Replace(s)
+}
@@ -0,0 +1,35 @@
+package agent
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+func TestPostgresCollector(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	collector, err := NewPostgresCollector([]PostgresTarget{{Name: "local", DSN: dsn}}, 5*time.Second)
+	require.NoError(t, err)
+	defer collector.Close()
+
+	collectMetrics(t, collector)
+	metrics := collectMetrics(t, collector)
+	assert.Equal(t, entity.Gauge, metrics["postgres.local.up"].MType)
+	assert.Equal(t, entity.Gauge, metrics["postgres.local.connections.active"].MType)
+	assert.Equal(t, entity.Counter, metrics["postgres.local.bgwriter.buffers_alloc"].MType)
+	for id, metric := range metrics {
+		if strings.HasSuffix(id, "_time") {
+			assert.Equal(t, entity.Gauge, metric.MType, "fractional totals must not be truncated: %s", id)
+		}
+	}
+}
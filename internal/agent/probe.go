@@ -0,0 +1,205 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+const (
+	ProbeHTTP = "http"
+	ProbeTCP  = "tcp"
+
+	defaultProbeTimeout     = 5 * time.Second
+	defaultProbeConcurrency = 4
+
+	// probeBodyLimit ограничивает, сколько тела ответа читается для проверки регулярным выражением.
+	probeBodyLimit = 1 << 20
+)
+
+// ProbeCheck — одна синтетическая проверка: HTTP-запрос к URL или TCP-подключение к host:port.
+type ProbeCheck struct {
+	Name         string
+	Kind         string
+	Target       string
+	Method       string
+	ExpectStatus int
+	BodyRegex    *regexp.Regexp
+}
+
+func NewProbeCheck(name, kind, target, method string, expectStatus int, bodyRegex string) (ProbeCheck, error) {
+	check := ProbeCheck{
+		Name:         name,
+		Kind:         kind,
+		Target:       target,
+		Method:       method,
+		ExpectStatus: expectStatus,
+	}
+	if name == "" || target == "" {
+		return ProbeCheck{}, fmt.Errorf("probe requires a name and a target")
+	}
+
+	switch kind {
+	case ProbeHTTP:
+		if check.Method == "" {
+			check.Method = http.MethodGet
+		}
+		if check.Method != http.MethodGet && check.Method != http.MethodHead {
+			return ProbeCheck{}, fmt.Errorf("probe %s: unsupported method %s", name, method)
+		}
+		if check.ExpectStatus == 0 {
+			check.ExpectStatus = http.StatusOK
+		}
+		if bodyRegex != "" {
+			if check.Method == http.MethodHead {
+				return ProbeCheck{}, fmt.Errorf("probe %s: body_regex requires GET", name)
+			}
+			re, err := regexp.Compile(bodyRegex)
+			if err != nil {
+				return ProbeCheck{}, fmt.Errorf("probe %s: invalid body_regex: %w", name, err)
+			}
+			check.BodyRegex = re
+		}
+	case ProbeTCP:
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return ProbeCheck{}, fmt.Errorf("probe %s: invalid address %s: %w", name, target, err)
+		}
+	default:
+		return ProbeCheck{}, fmt.Errorf("probe %s: unknown type %s", name, kind)
+	}
+
+	return check, nil
+}
+
+// ProbeCollector выполняет проверки параллельно, не больше concurrency одновременно.
+// Для каждой проверки отдаются гейджи probe.<name>.latency (секунды) и
+// probe.<name>.success (0/1), для HTTPS ещё probe.<name>.cert_expiry_days.
+type ProbeCollector struct {
+	checks      []ProbeCheck
+	timeout     time.Duration
+	concurrency int
+	client      *http.Client
+}
+
+func NewProbeCollector(checks []ProbeCheck, timeout time.Duration, concurrency int) *ProbeCollector {
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	if concurrency <= 0 {
+		concurrency = defaultProbeConcurrency
+	}
+
+	return &ProbeCollector{
+		checks:      checks,
+		timeout:     timeout,
+		concurrency: concurrency,
+		client:      &http.Client{Timeout: timeout},
+	}
+}
+
+// SetHTTPClient заменяет HTTP-клиент проверок, например чтобы доверять своему CA.
+func (c *ProbeCollector) SetHTTPClient(client *http.Client) {
+	c.client = client
+}
+
+func (c *ProbeCollector) Name() string {
+	return "probe"
+}
+
+func (c *ProbeCollector) Collect(ctx context.Context) ([]entity.Metric, error) {
+	results := make([][]entity.Metric, len(c.checks))
+	sem := make(chan struct{}, c.concurrency)
+
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, check ProbeCheck) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = c.probe(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	var metrics []entity.Metric
+	for _, result := range results {
+		metrics = append(metrics, result...)
+	}
+	return metrics, nil
+}
+
+func (c *ProbeCollector) probe(ctx context.Context, check ProbeCheck) []entity.Metric {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	var ok bool
+	var expiry *float64
+	if check.Kind == ProbeTCP {
+		ok = c.probeTCP(ctx, check)
+	} else {
+		ok, expiry = c.probeHTTP(ctx, check)
+	}
+	latency := time.Since(start).Seconds()
+
+	success := 0.0
+	if ok {
+		success = 1
+	}
+	prefix := "probe." + check.Name + "."
+	metrics := []entity.Metric{
+		gaugeMetric(prefix+"latency", latency),
+		gaugeMetric(prefix+"success", success),
+	}
+	if expiry != nil {
+		metrics = append(metrics, gaugeMetric(prefix+"cert_expiry_days", *expiry))
+	}
+	return metrics
+}
+
+func (c *ProbeCollector) probeTCP(ctx context.Context, check ProbeCheck) bool {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", check.Target)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func (c *ProbeCollector) probeHTTP(ctx context.Context, check ProbeCheck) (bool, *float64) {
+	req, err := http.NewRequestWithContext(ctx, check.Method, check.Target, nil)
+	if err != nil {
+		return false, nil
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return false, nil
+	}
+	defer res.Body.Close()
+
+	var expiry *float64
+	if res.TLS != nil && len(res.TLS.PeerCertificates) > 0 {
+		days := time.Until(res.TLS.PeerCertificates[0].NotAfter).Hours() / 24
+		expiry = &days
+	}
+
+	if res.StatusCode != check.ExpectStatus {
+		return false, expiry
+	}
+	if check.BodyRegex != nil {
+		body, err := io.ReadAll(io.LimitReader(res.Body, probeBodyLimit))
+		if err != nil || !check.BodyRegex.Match(body) {
+			return false, expiry
+		}
+	}
+	return true, expiry
+}
@@ -0,0 +1,35 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbeCollector(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("status: ok"))
+	}))
+	defer server.Close()
+
+	up, err := NewProbeCheck("up", ProbeHTTP, server.URL, "", 0, "ok$")
+	require.NoError(t, err)
+	wrong, err := NewProbeCheck("wrong", ProbeHTTP, server.URL, "", 0, "fail")
+	require.NoError(t, err)
+	tcp, err := NewProbeCheck("tcp", ProbeTCP, server.Listener.Addr().String(), "", 0, "")
+	require.NoError(t, err)
+
+	collector := NewProbeCollector([]ProbeCheck{up, wrong, tcp}, time.Second, 2)
+	collector.SetHTTPClient(server.Client())
+
+	metrics := collectMetrics(t, collector)
+	assert.Equal(t, 1.0, valueOf(metrics["probe.up.success"]))
+	assert.Equal(t, 0.0, valueOf(metrics["probe.wrong.success"]))
+	assert.Equal(t, 1.0, valueOf(metrics["probe.tcp.success"]))
+	assert.Greater(t, valueOf(metrics["probe.up.cert_expiry_days"]), 0.0)
+	assert.Contains(t, metrics, "probe.tcp.latency")
+}
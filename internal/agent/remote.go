@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+// RemoteConfigClient опрашивает /agents/config с If-None-Match и сообщает
+// серверу, какую версию конфигурации агент применил. ETag запоминается только
+// после применения: конфигурацию, которую агент отверг, сервер отдаст снова.
+type RemoteConfigClient struct {
+	logger    *zap.Logger
+	serverURL string
+	instance  string
+	group     string
+	client    *resty.Client
+
+	mu          sync.Mutex
+	etag        string
+	fetchedETag string
+	fetched     string
+}
+
+func NewRemoteConfigClient(logger *zap.Logger, serverURL, instance, group string, tlsConfig *tls.Config) *RemoteConfigClient {
+	client := resty.New()
+	if tlsConfig != nil {
+		client.SetTLSClientConfig(tlsConfig)
+	}
+
+	return &RemoteConfigClient{
+		logger:    logger,
+		serverURL: serverURL,
+		instance:  instance,
+		group:     group,
+		client:    client,
+	}
+}
+
+// Fetch возвращает nil без ошибки, если конфигурация не менялась или профиля нет.
+func (c *RemoteConfigClient) Fetch() (*entity.AgentConfig, error) {
+	req := c.client.R().SetQueryParams(map[string]string{
+		"instance": c.instance,
+		"group":    c.group,
+	})
+	c.mu.Lock()
+	if c.etag != "" {
+		req.SetHeader("If-None-Match", c.etag)
+	}
+	c.mu.Unlock()
+
+	res, err := req.Get(c.serverURL + "/agents/config")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch agent config: %w", err)
+	}
+
+	switch res.StatusCode() {
+	case http.StatusNotModified, http.StatusNotFound:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("failed to fetch agent config: status %d", res.StatusCode())
+	}
+
+	var cfg entity.AgentConfig
+	if err := json.Unmarshal(res.Body(), &cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode agent config: %w", err)
+	}
+	c.mu.Lock()
+	c.fetched, c.fetchedETag = cfg.Version, res.Header().Get("ETag")
+	c.mu.Unlock()
+
+	return &cfg, nil
+}
+
+// Applied запоминает ETag применённой версии, чтобы больше не скачивать её.
+func (c *RemoteConfigClient) Applied(version string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.fetched == version {
+		c.etag = c.fetchedETag
+	}
+}
+
+func (c *RemoteConfigClient) ReportApplied(version string) error {
+	res, err := c.client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(map[string]string{"instance": c.instance, "version": version}).
+		Post(c.serverURL + "/agents/config/applied")
+	if err != nil {
+		return fmt.Errorf("failed to report applied config: %w", err)
+	}
+	if res.StatusCode() != http.StatusOK {
+		return fmt.Errorf("failed to report applied config: status %d", res.StatusCode())
+	}
+	return nil
+}
+
+func (c *RemoteConfigClient) Run(ctx context.Context, interval time.Duration, apply func(cfg entity.AgentConfig)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		cfg, err := c.Fetch()
+		if err != nil {
+			c.logger.Error("Remote config error", zap.Error(err))
+		} else if cfg != nil {
+			apply(*cfg)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+	"github.com/WPGe/go-yandex-advanced/internal/storage"
+)
+
+func TestRemoteConfigETag(t *testing.T) {
+	cfg := entity.AgentConfig{Version: "v1", PollInterval: 5}
+	var ifNoneMatch []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "host-1", r.URL.Query().Get("instance"))
+		assert.Equal(t, "db", r.URL.Query().Get("group"))
+		ifNoneMatch = append(ifNoneMatch, r.Header.Get("If-None-Match"))
+
+		etag := `"` + cfg.Version + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		json.NewEncoder(w).Encode(cfg)
+	}))
+	defer server.Close()
+
+	client := NewRemoteConfigClient(zap.NewNop(), server.URL, "host-1", "db", nil)
+	fetch := func() *entity.AgentConfig {
+		got, err := client.Fetch()
+		require.NoError(t, err)
+		return got
+	}
+
+	require.NotNil(t, fetch())
+	require.NotNil(t, fetch(), "rejected config must be fetched again")
+
+	client.Applied("v0")
+	require.NotNil(t, fetch(), "applying another version must not save the ETag")
+
+	client.Applied("v1")
+	assert.Nil(t, fetch())
+
+	cfg.Version = "v2"
+	assert.Equal(t, "v2", fetch().Version)
+	assert.Equal(t, []string{"", "", "", `"v1"`, `"v1"`}, ifNoneMatch)
+}
+
+func TestApplyConfigFilter(t *testing.T) {
+	logger := zap.NewNop()
+	a := NewAgent(logger, storage.NewMemStorage(logger), NewWriterExporter(nil, FormatJSON))
+
+	require.NoError(t, a.applyConfig(entity.AgentConfig{Include: []string{"Heap*"}}))
+	assert.Equal(t, Filter{Include: []string{"Heap*"}}, a.filter)
+
+	require.NoError(t, a.applyConfig(entity.AgentConfig{PollInterval: 1}))
+	assert.Equal(t, Filter{Include: []string{"Heap*"}}, a.filter, "config without filters must keep the current one")
+
+	require.NoError(t, a.applyConfig(entity.AgentConfig{Exclude: []string{"*Sys"}}))
+	assert.Equal(t, Filter{Exclude: []string{"*Sys"}}, a.filter)
+}
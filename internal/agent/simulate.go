@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+const (
+	GeneratorRandomWalk = "random-walk"
+	GeneratorSine       = "sine"
+	GeneratorStep       = "step"
+
+	// generatorPeriod — период синуса и длина ступеньки.
+	generatorPeriod = time.Minute
+)
+
+// SimulatedCollector изображает один виртуальный экземпляр: gauges гейджей
+// sim.gauge.<n> по выбранному генератору и counters счётчиков sim.counter.<n>.
+type SimulatedCollector struct {
+	gauges    int
+	counters  int
+	generator string
+	rnd       *rand.Rand
+	start     time.Time
+	values    []float64
+	phases    []float64
+}
+
+func NewSimulatedCollector(seed int64, gauges, counters int, generator string) (*SimulatedCollector, error) {
+	switch generator {
+	case GeneratorRandomWalk, GeneratorSine, GeneratorStep:
+	default:
+		return nil, fmt.Errorf("unknown generator: %s", generator)
+	}
+
+	rnd := rand.New(rand.NewSource(seed))
+	c := &SimulatedCollector{
+		gauges:    gauges,
+		counters:  counters,
+		generator: generator,
+		rnd:       rnd,
+		start:     time.Now(),
+		values:    make([]float64, gauges),
+		phases:    make([]float64, gauges),
+	}
+	for i := range c.values {
+		c.values[i] = rnd.Float64() * 100
+		c.phases[i] = rnd.Float64() * 2 * math.Pi
+	}
+	return c, nil
+}
+
+func (c *SimulatedCollector) Name() string {
+	return "simulated"
+}
+
+func (c *SimulatedCollector) Collect(context.Context) ([]entity.Metric, error) {
+	elapsed := time.Since(c.start)
+	metrics := make([]entity.Metric, 0, c.gauges+c.counters)
+	for i := 0; i < c.gauges; i++ {
+		metrics = append(metrics, gaugeMetric("sim.gauge."+strconv.Itoa(i), c.next(i, elapsed)))
+	}
+	for i := 0; i < c.counters; i++ {
+		metrics = append(metrics, counterMetric("sim.counter."+strconv.Itoa(i), c.rnd.Int63n(10)+1))
+	}
+	return metrics, nil
+}
+
+func (c *SimulatedCollector) next(i int, elapsed time.Duration) float64 {
+	base := c.values[i]
+	switch c.generator {
+	case GeneratorSine:
+		angle := 2*math.Pi*elapsed.Seconds()/generatorPeriod.Seconds() + c.phases[i]
+		return base + base*math.Sin(angle)
+	case GeneratorStep:
+		if int(elapsed/generatorPeriod)%2 == 1 {
+			return base * 2
+		}
+		return base
+	default:
+		c.values[i] += c.rnd.NormFloat64()
+		return c.values[i]
+	}
+}
+
+// StatsExporter считает отправки, метрики и ошибки обёрнутого экспортера.
+type StatsExporter struct {
+	exporter Exporter
+	batches  atomic.Int64
+	metrics  atomic.Int64
+	errors   atomic.Int64
+	latency  atomic.Int64
+}
+
+type ExportStats struct {
+	Batches int64
+	Metrics int64
+	Errors  int64
+	Latency time.Duration
+}
+
+func NewStatsExporter(exporter Exporter) *StatsExporter {
+	return &StatsExporter{exporter: exporter}
+}
+
+func (e *StatsExporter) Export(metrics []entity.Metric) error {
+	start := time.Now()
+	err := e.exporter.Export(metrics)
+	e.latency.Add(int64(time.Since(start)))
+
+	if err != nil {
+		e.errors.Add(1)
+		return err
+	}
+	e.batches.Add(1)
+	e.metrics.Add(int64(len(metrics)))
+	return nil
+}
+
+func (e *StatsExporter) Stats() ExportStats {
+	return ExportStats{
+		Batches: e.batches.Load(),
+		Metrics: e.metrics.Load(),
+		Errors:  e.errors.Load(),
+		Latency: time.Duration(e.latency.Load()),
+	}
+}
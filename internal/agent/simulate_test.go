@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+func TestSimulatedCollector(t *testing.T) {
+	t.Run("unknown generator", func(t *testing.T) {
+		_, err := NewSimulatedCollector(1, 1, 1, "square")
+		assert.Error(t, err)
+	})
+
+	t.Run("random walk is reproducible", func(t *testing.T) {
+		a, err := NewSimulatedCollector(42, 3, 2, GeneratorRandomWalk)
+		require.NoError(t, err)
+		b, err := NewSimulatedCollector(42, 3, 2, GeneratorRandomWalk)
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			first := collectMetrics(t, a)
+			assert.Equal(t, first, collectMetrics(t, b), "same seed must give the same series")
+			require.Len(t, first, 5)
+			assert.Equal(t, entity.Gauge, first["sim.gauge.2"].MType)
+			assert.Equal(t, entity.Counter, first["sim.counter.1"].MType)
+			assert.GreaterOrEqual(t, valueOf(first["sim.counter.0"]), 1.0)
+			assert.LessOrEqual(t, valueOf(first["sim.counter.0"]), 10.0)
+		}
+	})
+
+	t.Run("sine", func(t *testing.T) {
+		c, err := NewSimulatedCollector(7, 1, 0, GeneratorSine)
+		require.NoError(t, err)
+		base, phase := c.values[0], c.phases[0]
+
+		for _, elapsed := range []time.Duration{0, 15 * time.Second, 30 * time.Second, generatorPeriod} {
+			want := base + base*math.Sin(2*math.Pi*elapsed.Seconds()/generatorPeriod.Seconds()+phase)
+			got := c.next(0, elapsed)
+			assert.InDelta(t, want, got, 1e-9)
+			assert.GreaterOrEqual(t, got, 0.0)
+			assert.LessOrEqual(t, got, 2*base)
+		}
+		assert.InDelta(t, c.next(0, 0), c.next(0, generatorPeriod), 1e-9, "sine must repeat every period")
+	})
+
+	t.Run("step", func(t *testing.T) {
+		c, err := NewSimulatedCollector(7, 1, 0, GeneratorStep)
+		require.NoError(t, err)
+		base := c.values[0]
+
+		assert.Equal(t, base, c.next(0, 0))
+		assert.Equal(t, base, c.next(0, generatorPeriod-time.Second))
+		assert.Equal(t, 2*base, c.next(0, generatorPeriod))
+		assert.Equal(t, base, c.next(0, 2*generatorPeriod))
+	})
+}
+
+func TestStatsExporter(t *testing.T) {
+	exporter := &recordingExporter{}
+	stats := NewStatsExporter(exporter)
+
+	require.NoError(t, stats.Export(otlpTestMetrics(1, 1)))
+	require.NoError(t, stats.Export(otlpTestMetrics(1, 1)[:1]))
+	exporter.fails = 1
+	assert.Error(t, stats.Export(otlpTestMetrics(1, 1)))
+
+	got := stats.Stats()
+	assert.Equal(t, int64(2), got.Batches)
+	assert.Equal(t, int64(3), got.Metrics)
+	assert.Equal(t, int64(1), got.Errors)
+}
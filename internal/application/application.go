@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
@@ -22,7 +23,9 @@ import (
 
 	"github.com/WPGe/go-yandex-advanced/internal/agent"
 	"github.com/WPGe/go-yandex-advanced/internal/config"
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
 	"github.com/WPGe/go-yandex-advanced/internal/handler"
+	"github.com/WPGe/go-yandex-advanced/internal/ingest"
 	"github.com/WPGe/go-yandex-advanced/internal/service"
 	"github.com/WPGe/go-yandex-advanced/internal/storage"
 	"github.com/WPGe/go-yandex-advanced/internal/utils"
@@ -30,6 +33,8 @@ import (
 
 var sugar zap.SugaredLogger
 
+const compactInterval = time.Minute
+
 type Server struct {
 	srv    *http.Server
 	logger *zap.Logger
@@ -42,7 +47,7 @@ func NewServer(log *zap.Logger, addr string) *Server {
 	}
 }
 
-func (s *Server) InitHandlers(srv handler.Service, db *sql.DB) {
+func (s *Server) InitHandlers(srv handler.Service, history handler.History, rollups handler.Rollups, configs handler.AgentConfigs, metadata map[string]entity.MetricMetadata, influx *ingest.Influx, db *sql.DB) {
 	r := chi.NewRouter()
 	r.Post("/update/", utils.WithGzip(utils.WithLogging(handler.MetricUpdateHandler(srv, s.logger), sugar)))
 	r.Post("/updates/", utils.WithGzip(utils.WithLogging(handler.MetricUpdatesHandler(srv, s.logger), sugar)))
@@ -51,6 +56,16 @@ func (s *Server) InitHandlers(srv handler.Service, db *sql.DB) {
 	r.Post("/value/", utils.WithGzip(utils.WithLogging(handler.MetricPostHandler(srv, s.logger), sugar)))
 	r.Get("/", utils.WithGzip(utils.WithLogging(handler.MetricGetAllHandler(srv, s.logger), sugar)))
 	r.Get("/ping", handler.PingDB(db, s.logger))
+	r.Post("/api/v1/write", utils.WithLogging(handler.RemoteWriteHandler(srv, ingest.NewRemoteWrite(), s.logger), sugar))
+	r.Post("/write", utils.WithGzip(utils.WithLogging(handler.InfluxWriteHandler(srv, influx, s.logger), sugar)))
+	r.Get("/metrics", utils.WithGzip(utils.WithLogging(handler.PrometheusHandler(srv, metadata, s.logger), sugar)))
+	r.Get("/history/{type}/{name}", utils.WithGzip(utils.WithLogging(handler.HistoryHandler(history, s.logger), sugar)))
+	r.Get("/rollups/{type}/{name}", utils.WithGzip(utils.WithLogging(handler.RollupsHandler(rollups, s.logger), sugar)))
+	r.Get("/agents/config", utils.WithGzip(utils.WithLogging(handler.AgentConfigHandler(configs, s.logger), sugar)))
+	r.Post("/agents/config/applied", utils.WithGzip(utils.WithLogging(handler.AgentConfigAppliedHandler(configs, s.logger), sugar)))
+	r.Get("/agents/status", utils.WithGzip(utils.WithLogging(handler.AgentConfigStatusHandler(configs, s.logger), sugar)))
+	r.Get("/agents/profiles", utils.WithGzip(utils.WithLogging(handler.AgentProfilesHandler(configs, s.logger), sugar)))
+	r.Put("/agents/profiles/{name}", utils.WithGzip(utils.WithLogging(handler.AgentProfileSaveHandler(configs, s.logger), sugar)))
 
 	s.srv.Handler = r
 }
@@ -84,7 +99,19 @@ func Run() {
 	}
 
 	var repo service.Repository
+	var history service.History
+	var rollups service.Rollups
 	var db *sql.DB
+	tiers, err := entity.ParseRollupTiers(cfg.RollupTiers)
+	if err != nil {
+		logger.Error("Rollup tiers init error", zap.Error(err))
+		return
+	}
+	retention, err := time.ParseDuration(cfg.HistoryRetain)
+	if err != nil {
+		logger.Error("History retention init error", zap.Error(err))
+		return
+	}
 	if cfg.DatabaseDSN != "" {
 		db, err = ConnectDB(&cfg)
 		if err != nil {
@@ -98,7 +125,11 @@ func Run() {
 			}
 		}(db)
 		repo = storage.NewDBStorage(logger, db)
+		history = storage.NewDBHistory(logger, db, retention, cfg.HistorySize)
+		rollups = storage.NewDBRollups(logger, db, tiers)
 	} else {
+		history = storage.NewMemHistory(cfg.HistorySize, 0)
+		rollups = storage.NewMemRollups(tiers)
 		if cfg.Restore {
 			repo = storage.NewMemStorageFromFile(filepath.Join(cfg.RootDir, cfg.FileStoragePath), logger)
 		} else {
@@ -106,14 +137,49 @@ func Run() {
 		}
 	}
 
-	srv := service.New(repo)
+	agentConfigs, err := storage.NewAgentConfigStorage(cfg.AgentConfigPath, logger)
+	if err != nil {
+		logger.Error("Agent config init error", zap.Error(err))
+		return
+	}
+
+	bounds, err := entity.ParseHistogramBounds(cfg.HistogramBounds)
+	if err != nil {
+		logger.Error("Histogram buckets init error", zap.Error(err))
+		return
+	}
+
+	metadata, err := config.LoadMetricsMetadata(cfg.MetricsMetadata)
+	if err != nil {
+		logger.Error("Metrics metadata init error", zap.Error(err))
+		return
+	}
+
+	influx, err := ingest.NewInflux(cfg.InfluxIntegers)
+	if err != nil {
+		logger.Error("Influx init error", zap.Error(err))
+		return
+	}
+
+	srv := service.New(repo, service.WithHistogramBounds(bounds), service.WithHistory(history), service.WithRollups(rollups))
 	server := NewServer(logger, cfg.Address)
-	server.InitHandlers(srv, db)
+	server.InitHandlers(srv, srv, srv, agentConfigs, metadata, influx, db)
+	if cfg.TLSCert != "" {
+		tlsConfig, err := utils.NewServerTLSConfig(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA, cfg.TLSRequireCert)
+		if err != nil {
+			logger.Error("TLS init error", zap.Error(err))
+			return
+		}
+		server.srv.TLSConfig = tlsConfig
+	}
 
-	logger.Info("Starting server", zap.String("addr", cfg.Address))
+	logger.Info("Starting server", zap.String("addr", cfg.Address), zap.Bool("tls", server.srv.TLSConfig != nil))
 
 	g, gCtx := errgroup.WithContext(ctx)
 	g.Go(func() error {
+		if server.srv.TLSConfig != nil {
+			return server.srv.ListenAndServeTLS("", "")
+		}
 		return server.srv.ListenAndServe()
 	})
 	g.Go(func() error {
@@ -125,6 +191,57 @@ func Run() {
 		return agent.SaveMetricsInFileAgent(repo, filepath.Join(cfg.RootDir, cfg.FileStoragePath), time.Duration(cfg.StoreInterval), gCtx)
 	})
 
+	g.Go(func() error {
+		ticker := time.NewTicker(compactInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-gCtx.Done():
+				return nil
+			case now := <-ticker.C:
+				if err := srv.CompactRollups(now); err != nil {
+					logger.Error("Rollups compact error", zap.Error(err))
+				}
+				if err := srv.CompactHistory(now); err != nil {
+					logger.Error("History compact error", zap.Error(err))
+				}
+			}
+		}
+	})
+
+	if cfg.GraphiteAddr != "" {
+		var counters []string
+		for _, pattern := range strings.Split(cfg.GraphiteCounts, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				counters = append(counters, pattern)
+			}
+		}
+		graphite, err := ingest.NewGraphite(logger, srv, cfg.GraphiteAddr, counters, cfg.GraphiteConns)
+		if err != nil {
+			logger.Error("Graphite init error", zap.Error(err))
+			return
+		}
+		g.Go(func() error {
+			return graphite.Run(gCtx)
+		})
+	}
+
+	if cfg.StatsdAddr != "" {
+		percentiles, err := ingest.ParseStatsdPercentiles(cfg.StatsdPercents)
+		if err != nil {
+			logger.Error("Statsd init error", zap.Error(err))
+			return
+		}
+		statsd, err := ingest.NewStatsd(logger, srv, cfg.StatsdAddr, time.Duration(cfg.StatsdFlush)*time.Second, percentiles)
+		if err != nil {
+			logger.Error("Statsd init error", zap.Error(err))
+			return
+		}
+		g.Go(func() error {
+			return statsd.Run(gCtx)
+		})
+	}
+
 	if err := g.Wait(); err != nil {
 		logger.Fatal("Exit reason:", zap.Error(err))
 	}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// AgentFile — структурированная часть настроек агента, которую неудобно
+// передавать флагами: расписания коллекторов и конвейеры отправки.
+type AgentFile struct {
+	Collectors map[string]CollectorConfig `json:"collectors"`
+	Pipelines  []PipelineConfig           `json:"pipelines"`
+	Probes     ProbesConfig               `json:"probes"`
+	Expvar     ExpvarConfig               `json:"expvar"`
+	Postgres   PostgresConfig             `json:"postgres"`
+	Files      FilesConfig                `json:"files"`
+}
+
+type CollectorConfig struct {
+	// PollInterval — интервал опроса в секундах, 0 — общий интервал агента.
+	PollInterval int `json:"poll_interval"`
+}
+
+type PipelineConfig struct {
+	Name           string   `json:"name"`
+	ReportInterval int      `json:"report_interval"`
+	Exporter       string   `json:"exporter"`
+	Format         string   `json:"format"`
+	Include        []string `json:"include"`
+	Exclude        []string `json:"exclude"`
+}
+
+type ProbesConfig struct {
+	// Timeout — таймаут одной проверки в секундах.
+	Timeout     int           `json:"timeout"`
+	Concurrency int           `json:"concurrency"`
+	Checks      []ProbeConfig `json:"checks"`
+}
+
+type ProbeConfig struct {
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	Target       string `json:"target"`
+	Method       string `json:"method"`
+	ExpectStatus int    `json:"expect_status"`
+	BodyRegex    string `json:"body_regex"`
+}
+
+type ExpvarConfig struct {
+	Timeout int                  `json:"timeout"`
+	Targets []ExpvarTargetConfig `json:"targets"`
+	// Include и Exclude отбирают переменные по JSON-пути, например memstats.Heap*.
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+type ExpvarTargetConfig struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type PostgresConfig struct {
+	Timeout int                    `json:"timeout"`
+	Targets []PostgresTargetConfig `json:"targets"`
+}
+
+type PostgresTargetConfig struct {
+	Name string `json:"name"`
+	DSN  string `json:"dsn"`
+}
+
+type FilesConfig struct {
+	// Mode — inotify, scan или пусто: inotify, если он доступен.
+	Mode    string            `json:"mode"`
+	Watches []FileWatchConfig `json:"watches"`
+}
+
+type FileWatchConfig struct {
+	Name  string   `json:"name"`
+	Paths []string `json:"paths"`
+}
+
+func LoadAgentFile(path string) (AgentFile, error) {
+	var file AgentFile
+	if path == "" {
+		return file, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return AgentFile{}, fmt.Errorf("failed to read agent config: %w", err)
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return AgentFile{}, fmt.Errorf("failed to decode agent config: %w", err)
+	}
+
+	names := make(map[string]bool)
+	for _, pipeline := range file.Pipelines {
+		if pipeline.Name == "" || pipeline.ReportInterval <= 0 {
+			return AgentFile{}, fmt.Errorf("pipeline requires a name and a positive report_interval")
+		}
+		if names[pipeline.Name] {
+			return AgentFile{}, fmt.Errorf("duplicate pipeline: %s", pipeline.Name)
+		}
+		names[pipeline.Name] = true
+	}
+
+	return file, nil
+}
@@ -18,6 +18,47 @@ type Config struct {
 	DatabaseDSN     string `env:"DATABASE_DSN"`
 	ReportInterval  int    `env:"REPORT_INTERVAL"`
 	PollInterval    int    `env:"POLL_INTERVAL"`
+	Exporter        string `env:"EXPORTER"`
+	ExportFormat    string `env:"EXPORT_FORMAT"`
+	DryRun          bool   `env:"DRY_RUN"`
+	Aggregation     string `env:"AGGREGATION"`
+	InstanceID      string `env:"INSTANCE_ID"`
+	InstanceSource  string `env:"INSTANCE_SOURCE"`
+	InstanceIDFile  string `env:"INSTANCE_ID_FILE"`
+	MetricTemplate  string `env:"METRIC_TEMPLATE"`
+	TLSCert         string `env:"TLS_CERT"`
+	TLSKey          string `env:"TLS_KEY"`
+	TLSCA           string `env:"TLS_CA"`
+	TLSClientCA     string `env:"TLS_CLIENT_CA"`
+	TLSRequireCert  bool   `env:"TLS_REQUIRE_CLIENT_CERT"`
+	TLSServerName   string `env:"TLS_SERVER_NAME"`
+	OTLPEndpoint    string `env:"OTLP_ENDPOINT"`
+	OTLPProtocol    string `env:"OTLP_PROTOCOL"`
+	OTLPTemporality string `env:"OTLP_TEMPORALITY"`
+	AgentConfigPath string `env:"AGENT_CONFIG_PATH"`
+	AgentGroup      string `env:"AGENT_GROUP"`
+	ConfigPoll      int    `env:"CONFIG_POLL_INTERVAL"`
+	ConfigFile      string `env:"CONFIG"`
+	BufferMetrics   int    `env:"BUFFER_MAX_METRICS"`
+	BufferBytes     int    `env:"BUFFER_MAX_BYTES"`
+	DropPolicy      string `env:"DROP_POLICY"`
+	HistogramBounds string `env:"HISTOGRAM_BUCKETS"`
+	HistorySize     int    `env:"HISTORY_SIZE"`
+	HistoryRetain   string `env:"HISTORY_RETENTION"`
+	RollupTiers     string `env:"ROLLUP_TIERS"`
+	MetricsMetadata string `env:"METRICS_METADATA"`
+	GraphiteAddr    string `env:"GRAPHITE_ADDRESS"`
+	GraphiteCounts  string `env:"GRAPHITE_COUNTERS"`
+	GraphiteConns   int    `env:"GRAPHITE_MAX_CONNECTIONS"`
+	InfluxIntegers  string `env:"INFLUX_INTEGERS"`
+	StatsdAddr      string `env:"STATSD_ADDRESS"`
+	StatsdFlush     int    `env:"STATSD_FLUSH_INTERVAL"`
+	StatsdPercents  string `env:"STATSD_PERCENTILES"`
+	SimInstances    int    `env:"SIM_INSTANCES"`
+	SimGauges       int    `env:"SIM_GAUGES"`
+	SimCounters     int    `env:"SIM_COUNTERS"`
+	SimGenerator    string `env:"SIM_GENERATOR"`
+	SimDuration     int    `env:"SIM_DURATION"`
 }
 
 func NewServer() (Config, error) {
@@ -47,6 +88,57 @@ func NewServer() (Config, error) {
 	if config.DatabaseDSN == "" {
 		config.DatabaseDSN = flags.DatabaseDSN
 	}
+	if config.TLSCert == "" {
+		config.TLSCert = flags.TLSCert
+	}
+	if config.TLSKey == "" {
+		config.TLSKey = flags.TLSKey
+	}
+	if config.TLSClientCA == "" {
+		config.TLSClientCA = flags.TLSClientCA
+	}
+	if !config.TLSRequireCert {
+		config.TLSRequireCert = flags.TLSRequireCert
+	}
+	if config.AgentConfigPath == "" {
+		config.AgentConfigPath = flags.AgentConfigPath
+	}
+	if config.HistogramBounds == "" {
+		config.HistogramBounds = flags.HistogramBounds
+	}
+	if config.HistorySize == 0 {
+		config.HistorySize = flags.HistorySize
+	}
+	if config.HistoryRetain == "" {
+		config.HistoryRetain = flags.HistoryRetain
+	}
+	if config.RollupTiers == "" {
+		config.RollupTiers = flags.RollupTiers
+	}
+	if config.MetricsMetadata == "" {
+		config.MetricsMetadata = flags.MetricsMetadata
+	}
+	if config.GraphiteAddr == "" {
+		config.GraphiteAddr = flags.GraphiteAddr
+	}
+	if config.GraphiteCounts == "" {
+		config.GraphiteCounts = flags.GraphiteCounts
+	}
+	if config.GraphiteConns == 0 {
+		config.GraphiteConns = flags.GraphiteConns
+	}
+	if config.InfluxIntegers == "" {
+		config.InfluxIntegers = flags.InfluxIntegers
+	}
+	if config.StatsdAddr == "" {
+		config.StatsdAddr = flags.StatsdAddr
+	}
+	if config.StatsdFlush == 0 {
+		config.StatsdFlush = flags.StatsdFlush
+	}
+	if config.StatsdPercents == "" {
+		config.StatsdPercents = flags.StatsdPercents
+	}
 
 	startDebugLogs()
 
@@ -59,6 +151,23 @@ func parseServerFlags() Config {
 	flagFileStoragePath := flag.String("f", "/tmp/metrics-db.json", "filepath where the current metrics are saved")
 	flagRestore := flag.Bool("r", true, "load previously saved metrics from a file at startup")
 	flagDatabaseDSN := flag.String("d", "", "database DSN")
+	flagTLSCert := flag.String("tls-cert", "", "server TLS certificate file, enables https")
+	flagTLSKey := flag.String("tls-key", "", "server TLS private key file")
+	flagTLSClientCA := flag.String("tls-client-ca", "", "CA bundle used to verify client certificates")
+	flagTLSRequireCert := flag.Bool("tls-require-client-cert", false, "reject clients without a valid certificate")
+	flagAgentConfigPath := flag.String("agent-config", "", "JSON file with agent config profiles")
+	flagHistogramBounds := flag.String("histogram-buckets", "", "comma-separated histogram bucket bounds for single observations")
+	flagHistorySize := flag.Int("history-size", 1000, "samples kept per metric in memory and returned per /history query")
+	flagHistoryRetain := flag.String("history-retention", "168h", "how long the database history keeps samples")
+	flagRollupTiers := flag.String("rollup-tiers", "1m:720h,1h:8760h", "comma-separated rollup tiers as resolution:retention")
+	flagMetricsMetadata := flag.String("metrics-metadata", "", "JSON file with HELP and UNIT metadata for /metrics")
+	flagGraphiteAddr := flag.String("graphite-address", "", "address for the Graphite plaintext listener (TCP and UDP), e.g. :2003")
+	flagGraphiteCounts := flag.String("graphite-counters", "", "comma-separated Graphite path patterns stored as counters")
+	flagGraphiteConns := flag.Int("graphite-max-connections", 100, "maximum concurrent Graphite TCP connections")
+	flagInfluxIntegers := flag.String("influx-integers", "gauge", "how /write stores integer fields: gauge or counter (cumulative totals)")
+	flagStatsdAddr := flag.String("statsd-address", "", "UDP address for the StatsD listener, e.g. :8125")
+	flagStatsdFlush := flag.Int("statsd-flush-interval", 10, "StatsD aggregation window in seconds")
+	flagStatsdPercents := flag.String("statsd-percentiles", "90,99", "comma-separated StatsD timer percentiles")
 	flag.Parse()
 
 	return Config{
@@ -67,6 +176,23 @@ func parseServerFlags() Config {
 		FileStoragePath: *flagFileStoragePath,
 		Restore:         *flagRestore,
 		DatabaseDSN:     *flagDatabaseDSN,
+		TLSCert:         *flagTLSCert,
+		TLSKey:          *flagTLSKey,
+		TLSClientCA:     *flagTLSClientCA,
+		TLSRequireCert:  *flagTLSRequireCert,
+		AgentConfigPath: *flagAgentConfigPath,
+		HistogramBounds: *flagHistogramBounds,
+		HistorySize:     *flagHistorySize,
+		HistoryRetain:   *flagHistoryRetain,
+		RollupTiers:     *flagRollupTiers,
+		MetricsMetadata: *flagMetricsMetadata,
+		GraphiteAddr:    *flagGraphiteAddr,
+		GraphiteCounts:  *flagGraphiteCounts,
+		GraphiteConns:   *flagGraphiteConns,
+		InfluxIntegers:  *flagInfluxIntegers,
+		StatsdAddr:      *flagStatsdAddr,
+		StatsdFlush:     *flagStatsdFlush,
+		StatsdPercents:  *flagStatsdPercents,
 	}
 }
 
@@ -91,6 +217,84 @@ func NewAgent() (Config, error) {
 	if config.PollInterval == 0 {
 		config.PollInterval = flags.PollInterval
 	}
+	if config.Exporter == "" {
+		config.Exporter = flags.Exporter
+	}
+	if config.ExportFormat == "" {
+		config.ExportFormat = flags.ExportFormat
+	}
+	if !config.DryRun {
+		config.DryRun = flags.DryRun
+	}
+	if config.Aggregation == "" {
+		config.Aggregation = flags.Aggregation
+	}
+	if config.InstanceID == "" {
+		config.InstanceID = flags.InstanceID
+	}
+	if config.InstanceSource == "" {
+		config.InstanceSource = flags.InstanceSource
+	}
+	if config.InstanceIDFile == "" {
+		config.InstanceIDFile = flags.InstanceIDFile
+	}
+	if config.MetricTemplate == "" {
+		config.MetricTemplate = flags.MetricTemplate
+	}
+	if config.TLSCA == "" {
+		config.TLSCA = flags.TLSCA
+	}
+	if config.TLSCert == "" {
+		config.TLSCert = flags.TLSCert
+	}
+	if config.TLSKey == "" {
+		config.TLSKey = flags.TLSKey
+	}
+	if config.TLSServerName == "" {
+		config.TLSServerName = flags.TLSServerName
+	}
+	if config.OTLPEndpoint == "" {
+		config.OTLPEndpoint = flags.OTLPEndpoint
+	}
+	if config.OTLPProtocol == "" {
+		config.OTLPProtocol = flags.OTLPProtocol
+	}
+	if config.OTLPTemporality == "" {
+		config.OTLPTemporality = flags.OTLPTemporality
+	}
+	if config.AgentGroup == "" {
+		config.AgentGroup = flags.AgentGroup
+	}
+	if config.ConfigPoll == 0 {
+		config.ConfigPoll = flags.ConfigPoll
+	}
+	if config.ConfigFile == "" {
+		config.ConfigFile = flags.ConfigFile
+	}
+	if config.BufferMetrics == 0 {
+		config.BufferMetrics = flags.BufferMetrics
+	}
+	if config.BufferBytes == 0 {
+		config.BufferBytes = flags.BufferBytes
+	}
+	if config.DropPolicy == "" {
+		config.DropPolicy = flags.DropPolicy
+	}
+	if config.SimInstances == 0 {
+		config.SimInstances = flags.SimInstances
+	}
+	if config.SimGauges == 0 {
+		config.SimGauges = flags.SimGauges
+	}
+	if config.SimCounters == 0 {
+		config.SimCounters = flags.SimCounters
+	}
+	if config.SimGenerator == "" {
+		config.SimGenerator = flags.SimGenerator
+	}
+	if config.SimDuration == 0 {
+		config.SimDuration = flags.SimDuration
+	}
 
 	startDebugLogs()
 
@@ -101,15 +305,72 @@ func parseAgentFlags() Config {
 	flagRunAddr := flag.String("a", "localhost:8080", "address and port to run server")
 	flagReportInterval := flag.Int("r", 10, "report interval")
 	flagPollInterval := flag.Int("p", 2, "poll interval")
+	flagExporter := flag.String("exporter", "http", "where to export metrics: http, stdout or file:path")
+	flagExportFormat := flag.String("format", "json", "export format for stdout and file exporters: json or ndjson")
+	flagDryRun := flag.Bool("dry-run", false, "collect metrics and print them instead of sending to the server")
+	flagAggregation := flag.String("aggregate", "", "gauge aggregation modes per collector, e.g. runtime=min,max,avg,last,count")
+	flagInstanceID := flag.String("instance", "", "instance identity, derived from -instance-source when empty")
+	flagInstanceSource := flag.String("instance-source", "hostname", "how to derive instance identity: hostname or uuid")
+	flagInstanceIDFile := flag.String("instance-id-file", "/tmp/metrics-agent-id", "file where the generated instance uuid is persisted")
+	flagMetricTemplate := flag.String("metric-template", "{id}", "metric id template with {instance} and {id} placeholders")
+	flagTLSCA := flag.String("tls-ca", "", "CA bundle used to verify the server certificate, enables https")
+	flagTLSCert := flag.String("tls-cert", "", "client TLS certificate file")
+	flagTLSKey := flag.String("tls-key", "", "client TLS private key file")
+	flagTLSServerName := flag.String("tls-server-name", "", "server name expected in the server certificate")
+	flagOTLPEndpoint := flag.String("otlp-endpoint", "", "OTLP/HTTP collector endpoint, e.g. http://localhost:4318")
+	flagOTLPProtocol := flag.String("otlp-protocol", "http/protobuf", "OTLP encoding: http/protobuf or http/json")
+	flagOTLPTemporality := flag.String("otlp-temporality", "cumulative", "OTLP counter temporality: cumulative or delta")
+	flagAgentGroup := flag.String("group", "", "agent group used to select a remote config profile")
+	flagConfigPoll := flag.Int("config-poll", 0, "remote config poll interval in seconds, 0 disables remote config")
+	flagConfigFile := flag.String("config", "", "JSON file with collector schedules and report pipelines")
+	flagBufferMetrics := flag.Int("buffer-max-metrics", 0, "max distinct metrics buffered per pipeline, 0 is unlimited")
+	flagBufferBytes := flag.Int("buffer-max-bytes", 0, "max approximate bytes buffered per pipeline, 0 is unlimited")
+	flagDropPolicy := flag.String("drop-policy", "newest", "what to drop when the buffer is full: newest, oldest or gauges-first")
+	flagSimInstances := flag.Int("simulate", 0, "run N simulated agent instances instead of the real collectors")
+	flagSimGauges := flag.Int("sim-gauges", 10, "gauges per simulated instance")
+	flagSimCounters := flag.Int("sim-counters", 5, "counters per simulated instance")
+	flagSimGenerator := flag.String("sim-generator", "random-walk", "simulated gauge generator: random-walk, sine or step")
+	flagSimDuration := flag.Int("sim-duration", 0, "simulation duration in seconds, 0 runs until interrupted")
 	flag.Parse()
 
 	return Config{
-		Address:        *flagRunAddr,
-		ReportInterval: *flagReportInterval,
-		PollInterval:   *flagPollInterval,
+		Address:         *flagRunAddr,
+		ReportInterval:  *flagReportInterval,
+		PollInterval:    *flagPollInterval,
+		Exporter:        *flagExporter,
+		ExportFormat:    *flagExportFormat,
+		DryRun:          *flagDryRun,
+		Aggregation:     *flagAggregation,
+		InstanceID:      *flagInstanceID,
+		InstanceSource:  *flagInstanceSource,
+		InstanceIDFile:  *flagInstanceIDFile,
+		MetricTemplate:  *flagMetricTemplate,
+		TLSCA:           *flagTLSCA,
+		TLSCert:         *flagTLSCert,
+		TLSKey:          *flagTLSKey,
+		TLSServerName:   *flagTLSServerName,
+		OTLPEndpoint:    *flagOTLPEndpoint,
+		OTLPProtocol:    *flagOTLPProtocol,
+		OTLPTemporality: *flagOTLPTemporality,
+		AgentGroup:      *flagAgentGroup,
+		ConfigPoll:      *flagConfigPoll,
+		ConfigFile:      *flagConfigFile,
+		BufferMetrics:   *flagBufferMetrics,
+		BufferBytes:     *flagBufferBytes,
+		DropPolicy:      *flagDropPolicy,
+		SimInstances:    *flagSimInstances,
+		SimGauges:       *flagSimGauges,
+		SimCounters:     *flagSimCounters,
+		SimGenerator:    *flagSimGenerator,
+		SimDuration:     *flagSimDuration,
 	}
 }
 
+// TLSEnabled сообщает, что агенту нужно ходить на сервер по https.
+func (c Config) TLSEnabled() bool {
+	return c.TLSCA != "" || c.TLSCert != "" || c.TLSServerName != ""
+}
+
 func startDebugLogs() {
 	// Открываем файл для записи логов
 	file, err := os.OpenFile("server.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+// LoadMetricsMetadata читает JSON-объект вида {"<id метрики>": {"help": "...", "unit": "..."}}.
+func LoadMetricsMetadata(path string) (map[string]entity.MetricMetadata, error) {
+	metadata := make(map[string]entity.MetricMetadata)
+	if path == "" {
+		return metadata, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metrics metadata: %w", err)
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode metrics metadata: %w", err)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,75 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidAgentProfile — профиль отклонён проверкой, а не ошибкой записи.
+var ErrInvalidAgentProfile = errors.New("invalid agent profile")
+
+// Режимы агрегации гейджей между отправками агента.
+const (
+	AggregateLast  = "last"
+	AggregateMin   = "min"
+	AggregateMax   = "max"
+	AggregateAvg   = "avg"
+	AggregateCount = "count"
+)
+
+// AgentConfig — настройки агента, которые сервер раздаёт удалённо.
+// Нулевые поля не меняют текущие настройки агента.
+type AgentConfig struct {
+	Version        string   `json:"version,omitempty"`
+	PollInterval   int      `json:"poll_interval,omitempty"`
+	ReportInterval int      `json:"report_interval,omitempty"`
+	Collectors     []string `json:"collectors,omitempty"`
+	// CollectorIntervals — интервалы опроса отдельных коллекторов в секундах.
+	CollectorIntervals map[string]int      `json:"collector_intervals,omitempty"`
+	Aggregation        map[string][]string `json:"aggregation,omitempty"`
+	Include            []string            `json:"include,omitempty"`
+	Exclude            []string            `json:"exclude,omitempty"`
+}
+
+// AgentProfile выбирается по идентичности агента или по его группе.
+// Профиль с именем default отдаётся, если ничего не подошло.
+type AgentProfile struct {
+	Name      string      `json:"name"`
+	Instances []string    `json:"instances,omitempty"`
+	Groups    []string    `json:"groups,omitempty"`
+	Config    AgentConfig `json:"config"`
+}
+
+type AgentConfigStatus struct {
+	Instance  string    `json:"instance"`
+	Version   string    `json:"version"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// Validate проверяет то же, что агент перед применением: известные режимы
+// агрегации и неотрицательные интервалы.
+func (c AgentConfig) Validate() error {
+	if c.PollInterval < 0 || c.ReportInterval < 0 {
+		return fmt.Errorf("agent intervals must not be negative: poll %d, report %d", c.PollInterval, c.ReportInterval)
+	}
+	for name, seconds := range c.CollectorIntervals {
+		if seconds < 0 {
+			return fmt.Errorf("interval of collector %s must not be negative: %d", name, seconds)
+		}
+	}
+	return ValidateAggregation(c.Aggregation)
+}
+
+func ValidateAggregation(aggregation map[string][]string) error {
+	for name, modes := range aggregation {
+		for _, mode := range modes {
+			switch mode {
+			case AggregateLast, AggregateMin, AggregateMax, AggregateAvg, AggregateCount:
+			default:
+				return fmt.Errorf("unknown aggregation mode %q for %s", mode, name)
+			}
+		}
+	}
+	return nil
+}
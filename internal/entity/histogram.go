@@ -0,0 +1,144 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultHistogramBounds — границы корзин по умолчанию, подходят для задержек в секундах.
+var DefaultHistogramBounds = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// HistogramValue хранит число наблюдений в корзинах с верхними границами Bounds.
+// Последняя корзина в Counts — всё, что больше последней границы.
+type HistogramValue struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Sum    float64   `json:"sum"`
+	Count  uint64    `json:"count"`
+}
+
+// ParseHistogramBounds разбирает границы вида "0.1,0.5,1". Пустая строка — границы по умолчанию.
+func ParseHistogramBounds(s string) ([]float64, error) {
+	if strings.TrimSpace(s) == "" {
+		return DefaultHistogramBounds, nil
+	}
+
+	parts := strings.Split(s, ",")
+	bounds := make([]float64, 0, len(parts))
+	for _, part := range parts {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid histogram bound %q: %w", part, err)
+		}
+		if len(bounds) > 0 && !(bound > bounds[len(bounds)-1]) {
+			return nil, errors.New("histogram bounds must be strictly increasing")
+		}
+		bounds = append(bounds, bound)
+	}
+	return bounds, nil
+}
+
+func NewHistogram(bounds []float64) *HistogramValue {
+	b := make([]float64, len(bounds))
+	copy(b, bounds)
+	return &HistogramValue{
+		Bounds: b,
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (h *HistogramValue) Validate() error {
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("histogram expects %d counts for %d bounds, got %d", len(h.Bounds)+1, len(h.Bounds), len(h.Counts))
+	}
+	for i := 1; i < len(h.Bounds); i++ {
+		if !(h.Bounds[i] > h.Bounds[i-1]) {
+			return errors.New("histogram bounds must be strictly increasing")
+		}
+	}
+	var count uint64
+	for _, c := range h.Counts {
+		count += c
+	}
+	if count != h.Count {
+		return fmt.Errorf("histogram count %d does not match bucket counts %d", h.Count, count)
+	}
+	if !isFinite(h.Sum) {
+		return errors.New("histogram sum must be finite")
+	}
+	return nil
+}
+
+func (h *HistogramValue) Observe(value float64) {
+	i := 0
+	for i < len(h.Bounds) && value > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Sum += value
+	h.Count++
+}
+
+// Merge добавляет корзины other. Слить можно только гистограммы с одинаковыми границами.
+func (h *HistogramValue) Merge(other *HistogramValue) error {
+	if len(h.Bounds) != len(other.Bounds) {
+		return fmt.Errorf("%w: histogram bounds mismatch", ErrInvalidMetric)
+	}
+	for i := range h.Bounds {
+		if h.Bounds[i] != other.Bounds[i] {
+			return fmt.Errorf("%w: histogram bounds mismatch", ErrInvalidMetric)
+		}
+	}
+	for i := range h.Counts {
+		h.Counts[i] += other.Counts[i]
+	}
+	h.Sum += other.Sum
+	h.Count += other.Count
+	return nil
+}
+
+func (h *HistogramValue) Clone() *HistogramValue {
+	c := NewHistogram(h.Bounds)
+	copy(c.Counts, h.Counts)
+	c.Sum = h.Sum
+	c.Count = h.Count
+	return c
+}
+
+// Quantile оценивает квантиль линейной интерполяцией внутри корзины, как
+// histogram_quantile в Prometheus. Для корзины выше последней границы
+// возвращается последняя граница.
+func (h *HistogramValue) Quantile(q float64) float64 {
+	if h.Count == 0 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+
+	rank := q * float64(h.Count)
+	var cumulative uint64
+	for i, c := range h.Counts {
+		if c == 0 || float64(cumulative+c) < rank {
+			cumulative += c
+			continue
+		}
+		if i == len(h.Bounds) {
+			break
+		}
+
+		lower := 0.0
+		if i > 0 {
+			lower = h.Bounds[i-1]
+		} else if h.Bounds[0] <= 0 {
+			return h.Bounds[0]
+		}
+		upper := h.Bounds[i]
+		return lower + (upper-lower)*(rank-float64(cumulative))/float64(c)
+	}
+
+	if len(h.Bounds) == 0 {
+		return math.NaN()
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
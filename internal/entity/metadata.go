@@ -0,0 +1,7 @@
+package entity
+
+// MetricMetadata — описание и единица измерения метрики для экспозиции Prometheus.
+type MetricMetadata struct {
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
@@ -1,8 +1,16 @@
 package entity
 
+import "errors"
+
+// ErrInvalidMetric — метрика не проходит проверку, повторять запись бессмысленно.
+var ErrInvalidMetric = errors.New("invalid metric")
+
 const (
-	Gauge   string = "gauge"
-	Counter string = "counter"
+	Gauge     string = "gauge"
+	Counter   string = "counter"
+	Histogram string = "histogram"
+	Summary   string = "summary"
+	Set       string = "set"
 )
 
 type Metric struct {
@@ -10,6 +18,43 @@ type Metric struct {
 	MType string   `json:"type"`
 	Delta *int64   `json:"delta,omitempty"`
 	Value *float64 `json:"value,omitempty"`
+	// Histogram заполнен у метрик типа histogram.
+	Histogram *HistogramValue `json:"histogram,omitempty"`
+	// Summary заполнен у метрик типа summary.
+	Summary *SummaryValue `json:"summary,omitempty"`
+	// Set заполнен у метрик типа set. При записи вместо готового скетча можно
+	// прислать сырые элементы в Elements или их 64-битные хеши в Hashes.
+	Set      *SetValue `json:"set,omitempty"`
+	Elements []string  `json:"elements,omitempty"`
+	Hashes   []uint64  `json:"hashes,omitempty"`
 }
 
 type MetricsStore map[string]map[string]Metric
+
+// Clone возвращает копию метрики, которая не разделяет с исходной ни значения, ни скетчи.
+func (m Metric) Clone() Metric {
+	if m.Delta != nil {
+		delta := *m.Delta
+		m.Delta = &delta
+	}
+	if m.Value != nil {
+		value := *m.Value
+		m.Value = &value
+	}
+	if m.Histogram != nil {
+		m.Histogram = m.Histogram.Clone()
+	}
+	if m.Summary != nil {
+		m.Summary = m.Summary.Clone()
+	}
+	if m.Set != nil {
+		m.Set = m.Set.Clone()
+	}
+	if m.Elements != nil {
+		m.Elements = append([]string(nil), m.Elements...)
+	}
+	if m.Hashes != nil {
+		m.Hashes = append([]uint64(nil), m.Hashes...)
+	}
+	return m
+}
@@ -0,0 +1,66 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrUnknownTier — запрошено разрешение, для которого нет уровня агрегатов.
+var ErrUnknownTier = errors.New("unknown rollup tier")
+
+// RollupTier — разрешение корзин и срок их хранения.
+type RollupTier struct {
+	Resolution time.Duration `json:"resolution"`
+	Retention  time.Duration `json:"retention"`
+}
+
+// Rollup — агрегат обновлений метрики за корзину [Start, Start+разрешение).
+// У гейджей заполнены Min, Max, Avg и Last, у счётчиков — Sum приращений.
+type Rollup struct {
+	Start time.Time `json:"start"`
+	Count int64     `json:"count"`
+	Min   *float64  `json:"min,omitempty"`
+	Max   *float64  `json:"max,omitempty"`
+	Avg   *float64  `json:"avg,omitempty"`
+	Last  *float64  `json:"last,omitempty"`
+	Sum   *int64    `json:"sum,omitempty"`
+}
+
+// ParseRollupTiers разбирает уровни вида "1m:720h,1h:8760h" и сортирует их
+// от мелкого разрешения к крупному.
+func ParseRollupTiers(s string) ([]RollupTier, error) {
+	var tiers []RollupTier
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		rawResolution, rawRetention, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("rollup tier %q must look like resolution:retention", part)
+		}
+		resolution, err := time.ParseDuration(rawResolution)
+		if err != nil || resolution <= 0 {
+			return nil, fmt.Errorf("invalid rollup resolution %q", rawResolution)
+		}
+		// Уровень хранится в БД целыми секундами.
+		if resolution%time.Second != 0 {
+			return nil, fmt.Errorf("rollup resolution %q must be a whole number of seconds", rawResolution)
+		}
+		retention, err := time.ParseDuration(rawRetention)
+		if err != nil || retention < resolution {
+			return nil, fmt.Errorf("invalid rollup retention %q", rawRetention)
+		}
+		tiers = append(tiers, RollupTier{Resolution: resolution, Retention: retention})
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].Resolution < tiers[j].Resolution })
+	for i := 1; i < len(tiers); i++ {
+		if tiers[i].Resolution == tiers[i-1].Resolution {
+			return nil, errors.New("duplicate rollup resolution")
+		}
+	}
+	return tiers, nil
+}
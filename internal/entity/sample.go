@@ -0,0 +1,12 @@
+package entity
+
+import "time"
+
+// Sample — одно принятое обновление метрики: значение гейджа или приращение счётчика.
+type Sample struct {
+	ID        string    `json:"id"`
+	MType     string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	Delta     *int64    `json:"delta,omitempty"`
+	Value     *float64  `json:"value,omitempty"`
+}
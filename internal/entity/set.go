@@ -0,0 +1,132 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	// DefaultSetPrecision — 2^14 регистров, стандартная ошибка около 0.8%.
+	DefaultSetPrecision = 14
+
+	setMinPrecision    = 4
+	setMaxPrecision    = 18
+	setEncodingVersion = 1
+)
+
+// SetValue — HyperLogLog: оценка числа уникальных элементов по максимальной
+// длине серии нулей в хешах. Скетчи разных агентов сливаются поэлементным максимумом.
+type SetValue struct {
+	Precision uint8   `json:"precision"`
+	Registers []uint8 `json:"registers"`
+}
+
+func NewSet(precision uint8) *SetValue {
+	return &SetValue{
+		Precision: precision,
+		Registers: make([]uint8, 1<<precision),
+	}
+}
+
+func (s *SetValue) Validate() error {
+	if s.Precision < setMinPrecision || s.Precision > setMaxPrecision {
+		return fmt.Errorf("set precision must be in [%d, %d], got %d", setMinPrecision, setMaxPrecision, s.Precision)
+	}
+	if len(s.Registers) != 1<<s.Precision {
+		return fmt.Errorf("set expects %d registers, got %d", 1<<s.Precision, len(s.Registers))
+	}
+	return nil
+}
+
+// Add добавляет сырой элемент.
+func (s *SetValue) Add(element string) {
+	h := fnv.New64a()
+	h.Write([]byte(element))
+	s.AddHash(h.Sum64())
+}
+
+// AddHash добавляет готовый 64-битный хеш элемента. Хеш дополнительно
+// перемешивается, поэтому подходит любой, даже не очень равномерный.
+func (s *SetValue) AddHash(hash uint64) {
+	hash = mix64(hash)
+	index := hash >> (64 - s.Precision)
+	rank := uint8(bits.LeadingZeros64(hash<<s.Precision|1<<(s.Precision-1))) + 1
+	if rank > s.Registers[index] {
+		s.Registers[index] = rank
+	}
+}
+
+func (s *SetValue) Merge(other *SetValue) error {
+	if s.Precision != other.Precision || len(s.Registers) != len(other.Registers) {
+		return fmt.Errorf("%w: set precision mismatch", ErrInvalidMetric)
+	}
+	for i, r := range other.Registers {
+		if r > s.Registers[i] {
+			s.Registers[i] = r
+		}
+	}
+	return nil
+}
+
+func (s *SetValue) Clone() *SetValue {
+	c := &SetValue{
+		Precision: s.Precision,
+		Registers: make([]uint8, len(s.Registers)),
+	}
+	copy(c.Registers, s.Registers)
+	return c
+}
+
+// Cardinality оценивает число уникальных элементов. На малых множествах,
+// пока есть пустые регистры, используется линейный подсчёт.
+func (s *SetValue) Cardinality() uint64 {
+	m := float64(len(s.Registers))
+	if m == 0 {
+		return 0
+	}
+
+	var sum float64
+	var zeros int
+	for _, r := range s.Registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+func (s *SetValue) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 2+len(s.Registers))
+	b = append(b, setEncodingVersion, s.Precision)
+	return append(b, s.Registers...), nil
+}
+
+func (s *SetValue) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 || data[0] != setEncodingVersion {
+		return errors.New("unsupported set encoding")
+	}
+	s.Precision = data[1]
+	s.Registers = make([]uint8, len(data)-2)
+	copy(s.Registers, data[2:])
+	return s.Validate()
+}
+
+// mix64 — финализатор splitmix64.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
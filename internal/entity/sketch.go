@@ -0,0 +1,54 @@
+package entity
+
+import "fmt"
+
+// IsSketch — значения этих типов не перезаписываются, а сливаются с сохранёнными.
+func IsSketch(mType string) bool {
+	return mType == Histogram || mType == Summary || mType == Set
+}
+
+// MergeSketch возвращает метрику со слитым значением. Сохранённое значение
+// не меняется на месте, чтобы не задеть копии, которые уже отдали читателям.
+func MergeSketch(existing *Metric, incoming Metric) (Metric, error) {
+	switch incoming.MType {
+	case Histogram:
+		if incoming.Histogram == nil {
+			return Metric{}, fmt.Errorf("%w: histogram %s is empty", ErrInvalidMetric, incoming.ID)
+		}
+		merged := incoming.Histogram.Clone()
+		if existing != nil && existing.Histogram != nil {
+			merged = existing.Histogram.Clone()
+			if err := merged.Merge(incoming.Histogram); err != nil {
+				return Metric{}, fmt.Errorf("failed to merge histogram %s: %w", incoming.ID, err)
+			}
+		}
+		incoming.Histogram = merged
+	case Summary:
+		if incoming.Summary == nil {
+			return Metric{}, fmt.Errorf("%w: summary %s is empty", ErrInvalidMetric, incoming.ID)
+		}
+		merged := incoming.Summary.Clone()
+		if existing != nil && existing.Summary != nil {
+			merged = existing.Summary.Clone()
+			if err := merged.Merge(incoming.Summary); err != nil {
+				return Metric{}, fmt.Errorf("failed to merge summary %s: %w", incoming.ID, err)
+			}
+		}
+		incoming.Summary = merged
+	case Set:
+		if incoming.Set == nil {
+			return Metric{}, fmt.Errorf("%w: set %s is empty", ErrInvalidMetric, incoming.ID)
+		}
+		merged := incoming.Set.Clone()
+		if existing != nil && existing.Set != nil {
+			merged = existing.Set.Clone()
+			if err := merged.Merge(incoming.Set); err != nil {
+				return Metric{}, fmt.Errorf("failed to merge set %s: %w", incoming.ID, err)
+			}
+		}
+		incoming.Set = merged
+	default:
+		return Metric{}, fmt.Errorf("%w: %s is not a sketch type", ErrInvalidMetric, incoming.MType)
+	}
+	return incoming, nil
+}
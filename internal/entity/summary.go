@@ -0,0 +1,297 @@
+package entity
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	// DefaultSummaryAccuracy — относительная ошибка квантилей DDSketch.
+	DefaultSummaryAccuracy = 0.01
+
+	// summaryMaxBins ограничивает число корзин на знак, лишние младшие корзины сливаются.
+	summaryMaxBins = 2048
+	// summaryMinValue — значения меньше по модулю считаются нулём.
+	summaryMinValue = 1e-9
+
+	summaryEncodingVersion = 1
+)
+
+// SummaryValue — DDSketch: наблюдения раскладываются по корзинам с
+// геометрически растущими границами, поэтому любой квантиль оценивается
+// с относительной ошибкой Accuracy, а скетчи разных агентов сливаются без потерь.
+type SummaryValue struct {
+	Accuracy float64          `json:"accuracy"`
+	Positive map[int32]uint64 `json:"positive,omitempty"`
+	Negative map[int32]uint64 `json:"negative,omitempty"`
+	Zero     uint64           `json:"zero"`
+	Count    uint64           `json:"count"`
+	Sum      float64          `json:"sum"`
+	Min      float64          `json:"min"`
+	Max      float64          `json:"max"`
+}
+
+func NewSummary(accuracy float64) *SummaryValue {
+	return &SummaryValue{
+		Accuracy: accuracy,
+		Positive: make(map[int32]uint64),
+		Negative: make(map[int32]uint64),
+	}
+}
+
+// Validate проверяет скетч от клиента: число корзин ограничено summaryMaxBins,
+// как у скетчей, которые строит сервер.
+func (s *SummaryValue) Validate() error {
+	if !(s.Accuracy > 0 && s.Accuracy < 1) {
+		return fmt.Errorf("summary accuracy must be in (0, 1), got %g", s.Accuracy)
+	}
+	if len(s.Positive) > summaryMaxBins || len(s.Negative) > summaryMaxBins {
+		return fmt.Errorf("summary has more than %d bins per sign", summaryMaxBins)
+	}
+	count := s.Zero
+	for _, c := range s.Positive {
+		count += c
+	}
+	for _, c := range s.Negative {
+		count += c
+	}
+	if count != s.Count {
+		return fmt.Errorf("summary count %d does not match bucket counts %d", s.Count, count)
+	}
+	if !isFinite(s.Sum) || !isFinite(s.Min) || !isFinite(s.Max) {
+		return errors.New("summary sum, min and max must be finite")
+	}
+	if s.Count > 0 && s.Min > s.Max {
+		return fmt.Errorf("summary min %g is greater than max %g", s.Min, s.Max)
+	}
+	return nil
+}
+
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func (s *SummaryValue) gamma() float64 {
+	return (1 + s.Accuracy) / (1 - s.Accuracy)
+}
+
+func (s *SummaryValue) index(v float64) int32 {
+	return int32(math.Ceil(math.Log(v) / math.Log(s.gamma())))
+}
+
+func (s *SummaryValue) value(index int32) float64 {
+	gamma := s.gamma()
+	return 2 * math.Pow(gamma, float64(index)) / (gamma + 1)
+}
+
+func (s *SummaryValue) Observe(v float64) {
+	if s.Positive == nil {
+		s.Positive = make(map[int32]uint64)
+	}
+	if s.Negative == nil {
+		s.Negative = make(map[int32]uint64)
+	}
+
+	switch {
+	case v > summaryMinValue:
+		s.Positive[s.index(v)]++
+	case v < -summaryMinValue:
+		s.Negative[s.index(-v)]++
+	default:
+		s.Zero++
+	}
+
+	if s.Count == 0 || v < s.Min {
+		s.Min = v
+	}
+	if s.Count == 0 || v > s.Max {
+		s.Max = v
+	}
+	s.Count++
+	s.Sum += v
+	s.collapse()
+}
+
+// Merge добавляет корзины other. Слить можно только скетчи с одинаковой точностью.
+func (s *SummaryValue) Merge(other *SummaryValue) error {
+	if s.Accuracy != other.Accuracy {
+		return fmt.Errorf("%w: summary accuracy mismatch", ErrInvalidMetric)
+	}
+	if other.Count == 0 {
+		return nil
+	}
+	if s.Positive == nil {
+		s.Positive = make(map[int32]uint64)
+	}
+	if s.Negative == nil {
+		s.Negative = make(map[int32]uint64)
+	}
+
+	for i, c := range other.Positive {
+		s.Positive[i] += c
+	}
+	for i, c := range other.Negative {
+		s.Negative[i] += c
+	}
+	if s.Count == 0 || other.Min < s.Min {
+		s.Min = other.Min
+	}
+	if s.Count == 0 || other.Max > s.Max {
+		s.Max = other.Max
+	}
+	s.Zero += other.Zero
+	s.Count += other.Count
+	s.Sum += other.Sum
+	s.collapse()
+	return nil
+}
+
+func (s *SummaryValue) Clone() *SummaryValue {
+	c := *s
+	c.Positive = make(map[int32]uint64, len(s.Positive))
+	for i, v := range s.Positive {
+		c.Positive[i] = v
+	}
+	c.Negative = make(map[int32]uint64, len(s.Negative))
+	for i, v := range s.Negative {
+		c.Negative[i] = v
+	}
+	return &c
+}
+
+// Quantile оценивает квантиль q из [0, 1].
+func (s *SummaryValue) Quantile(q float64) float64 {
+	if s.Count == 0 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+
+	rank := uint64(q * float64(s.Count-1))
+	var cumulative uint64
+	var result float64
+	found := false
+
+	// Отрицательные значения идут от больших по модулю к меньшим.
+	negative := sortedIndexes(s.Negative)
+	for i := len(negative) - 1; i >= 0 && !found; i-- {
+		cumulative += s.Negative[negative[i]]
+		if cumulative > rank {
+			result, found = -s.value(negative[i]), true
+		}
+	}
+	if !found {
+		cumulative += s.Zero
+		if cumulative > rank {
+			result, found = 0, true
+		}
+	}
+	for _, i := range sortedIndexes(s.Positive) {
+		if found {
+			break
+		}
+		cumulative += s.Positive[i]
+		if cumulative > rank {
+			result, found = s.value(i), true
+		}
+	}
+	if !found {
+		result = s.Max
+	}
+
+	return math.Max(s.Min, math.Min(s.Max, result))
+}
+
+// collapse сливает младшие корзины, чтобы скетч не рос без предела.
+func (s *SummaryValue) collapse() {
+	for _, bins := range []map[int32]uint64{s.Positive, s.Negative} {
+		if len(bins) <= summaryMaxBins {
+			continue
+		}
+		indexes := sortedIndexes(bins)
+		excess := len(indexes) - summaryMaxBins
+		target := indexes[excess]
+		for _, i := range indexes[:excess] {
+			bins[target] += bins[i]
+			delete(bins, i)
+		}
+	}
+}
+
+// MarshalBinary кодирует скетч компактно для хранения в базе.
+func (s *SummaryValue) MarshalBinary() ([]byte, error) {
+	b := []byte{summaryEncodingVersion}
+	b = binary.LittleEndian.AppendUint64(b, math.Float64bits(s.Accuracy))
+	b = binary.LittleEndian.AppendUint64(b, math.Float64bits(s.Sum))
+	b = binary.LittleEndian.AppendUint64(b, math.Float64bits(s.Min))
+	b = binary.LittleEndian.AppendUint64(b, math.Float64bits(s.Max))
+	b = binary.AppendUvarint(b, s.Count)
+	b = binary.AppendUvarint(b, s.Zero)
+	for _, bins := range []map[int32]uint64{s.Positive, s.Negative} {
+		b = binary.AppendUvarint(b, uint64(len(bins)))
+		for _, i := range sortedIndexes(bins) {
+			b = binary.AppendVarint(b, int64(i))
+			b = binary.AppendUvarint(b, bins[i])
+		}
+	}
+	return b, nil
+}
+
+func (s *SummaryValue) UnmarshalBinary(data []byte) error {
+	if len(data) < 33 || data[0] != summaryEncodingVersion {
+		return errors.New("unsupported summary encoding")
+	}
+	s.Accuracy = math.Float64frombits(binary.LittleEndian.Uint64(data[1:]))
+	s.Sum = math.Float64frombits(binary.LittleEndian.Uint64(data[9:]))
+	s.Min = math.Float64frombits(binary.LittleEndian.Uint64(data[17:]))
+	s.Max = math.Float64frombits(binary.LittleEndian.Uint64(data[25:]))
+	r := data[33:]
+
+	readUvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(r)
+		if n <= 0 {
+			return 0, errors.New("truncated summary")
+		}
+		r = r[n:]
+		return v, nil
+	}
+
+	var err error
+	if s.Count, err = readUvarint(); err != nil {
+		return err
+	}
+	if s.Zero, err = readUvarint(); err != nil {
+		return err
+	}
+	s.Positive = make(map[int32]uint64)
+	s.Negative = make(map[int32]uint64)
+	for _, bins := range []map[int32]uint64{s.Positive, s.Negative} {
+		n, err := readUvarint()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < n; j++ {
+			i, size := binary.Varint(r)
+			if size <= 0 {
+				return errors.New("truncated summary")
+			}
+			r = r[size:]
+			c, err := readUvarint()
+			if err != nil {
+				return err
+			}
+			bins[int32(i)] = c
+		}
+	}
+	return nil
+}
+
+func sortedIndexes(bins map[int32]uint64) []int32 {
+	indexes := make([]int32, 0, len(bins))
+	for i := range bins {
+		indexes = append(indexes, i)
+	}
+	sort.Slice(indexes, func(a, b int) bool { return indexes[a] < indexes[b] })
+	return indexes
+}
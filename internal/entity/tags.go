@@ -0,0 +1,33 @@
+package entity
+
+import (
+	"sort"
+	"strings"
+)
+
+var (
+	tagKeyReplacer   = strings.NewReplacer(";", "_", "=", "_")
+	tagValueReplacer = strings.NewReplacer(";", "_")
+)
+
+// TaggedID склеивает имя и метки в ID в стиле тегов Graphite: name;a=1;b=2.
+// Метки сортируются по ключу, пустые значения пропускаются.
+func TaggedID(name string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if k != "" && v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	for _, k := range keys {
+		b.WriteByte(';')
+		b.WriteString(tagKeyReplacer.Replace(k))
+		b.WriteByte('=')
+		b.WriteString(tagValueReplacer.Replace(tags[k]))
+	}
+	return b.String()
+}
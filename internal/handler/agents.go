@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+type appliedConfig struct {
+	Instance string `json:"instance"`
+	Version  string `json:"version"`
+}
+
+func AgentConfigHandler(configs AgentConfigs, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		instance := r.URL.Query().Get("instance")
+		group := r.URL.Query().Get("group")
+
+		cfg, err := configs.AgentConfig(instance, group)
+		if err != nil {
+			logger.Info("Agent config: not found", zap.String("instance", instance), zap.String("group", group))
+			http.Error(w, "Agent config not found", http.StatusNotFound)
+			return
+		}
+
+		etag := `"` + cfg.Version + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(cfg); err != nil {
+			logger.Error("Agent config: Error encoding JSON", zap.Error(err))
+		}
+	}
+}
+
+func AgentConfigAppliedHandler(configs AgentConfigs, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var applied appliedConfig
+		if err := json.NewDecoder(r.Body).Decode(&applied); err != nil {
+			logger.Error("Agent config applied: Invalid JSON format", zap.Error(err))
+			http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+			return
+		}
+
+		if err := configs.ReportApplied(applied.Instance, applied.Version); err != nil {
+			logger.Error("Agent config applied: report error", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func AgentConfigStatusHandler(configs AgentConfigs, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		statuses, err := configs.AppliedConfigs()
+		if err != nil {
+			logger.Error("Agent config status: error", zap.Error(err))
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(statuses); err != nil {
+			logger.Error("Agent config status: Error encoding JSON", zap.Error(err))
+		}
+	}
+}
+
+func AgentProfilesHandler(configs AgentConfigs, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		profiles, err := configs.Profiles()
+		if err != nil {
+			logger.Error("Agent profiles: error", zap.Error(err))
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(profiles); err != nil {
+			logger.Error("Agent profiles: Error encoding JSON", zap.Error(err))
+		}
+	}
+}
+
+func AgentProfileSaveHandler(configs AgentConfigs, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var profile entity.AgentProfile
+		if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+			logger.Error("Agent profile: Invalid JSON format", zap.Error(err))
+			http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+			return
+		}
+		profile.Name = chi.URLParam(r, "name")
+
+		saved, err := configs.SaveProfile(profile)
+		if errors.Is(err, entity.ErrInvalidAgentProfile) {
+			logger.Info("Agent profile: invalid", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error("Agent profile: save error", zap.Error(err))
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(saved); err != nil {
+			logger.Error("Agent profile: Error encoding JSON", zap.Error(err))
+		}
+	}
+}
@@ -3,9 +3,11 @@ package handler
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go.uber.org/zap"
 	"io"
+	"math"
 	"net/http"
 	"strconv"
 
@@ -52,6 +54,15 @@ func MetricUpdateHandler(srv Service, logger *zap.Logger) http.HandlerFunc {
 					return
 				}
 				metric.Delta = &delta
+			case entity.Histogram, entity.Summary:
+				var value float64
+				if value, err = strconv.ParseFloat(metricValue, 64); err != nil {
+					http.Error(w, "Incorrect value", http.StatusBadRequest)
+					return
+				}
+				metric.Value = &value
+			case entity.Set:
+				metric.Elements = []string{metricValue}
 			default:
 				logger.Info("Update: Incorrect metric type")
 				http.Error(w, "Incorrect metric type", http.StatusBadRequest)
@@ -63,6 +74,11 @@ func MetricUpdateHandler(srv Service, logger *zap.Logger) http.HandlerFunc {
 		}
 
 		if err := srv.AddMetric(metric); err != nil {
+			if errors.Is(err, entity.ErrInvalidMetric) {
+				logger.Info("Update: invalid metric", zap.Error(err))
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 			logger.Fatal("Update: add error", zap.Error(err))
 		}
 
@@ -84,6 +100,11 @@ func MetricUpdatesHandler(srv Service, logger *zap.Logger) http.HandlerFunc {
 		}
 
 		if err := srv.AddMetrics(metrics); err != nil {
+			if errors.Is(err, entity.ErrInvalidMetric) {
+				logger.Info("Update: invalid metric", zap.Error(err))
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 			logger.Fatal("Update: add error", zap.Error(err))
 		}
 
@@ -118,6 +139,33 @@ func MetricGetHandler(srv Service, logger *zap.Logger) http.HandlerFunc {
 				http.Error(w, "Output error", http.StatusBadRequest)
 				return
 			}
+		case entity.Histogram:
+			if _, err := io.WriteString(w, formatHistogram(resultMetric.Histogram)); err != nil {
+				logger.Error("Get: Output error", zap.Error(err))
+				http.Error(w, "Output error", http.StatusBadRequest)
+				return
+			}
+		case entity.Set:
+			if _, err := io.WriteString(w, fmt.Sprintf("%d", resultMetric.Set.Cardinality())); err != nil {
+				logger.Error("Get: Output error", zap.Error(err))
+				http.Error(w, "Output error", http.StatusBadRequest)
+				return
+			}
+		case entity.Summary:
+			output := formatSummary(resultMetric.Summary)
+			if raw := r.URL.Query().Get("q"); raw != "" {
+				q, err := parseQuantile(raw)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				output = fmt.Sprintf("%g", resultMetric.Summary.Quantile(q))
+			}
+			if _, err := io.WriteString(w, output); err != nil {
+				logger.Error("Get: Output error", zap.Error(err))
+				http.Error(w, "Output error", http.StatusBadRequest)
+				return
+			}
 		}
 		w.WriteHeader(http.StatusOK)
 	}
@@ -140,9 +188,28 @@ func MetricPostHandler(srv Service, logger *zap.Logger) http.HandlerFunc {
 			return
 		}
 
+		var response interface{} = resultMetric
+		switch {
+		case resultMetric.Histogram != nil:
+			response = quantilesResponse{Metric: *resultMetric, Quantiles: quantiles(resultMetric.Histogram.Quantile, defaultQuantiles)}
+		case resultMetric.Summary != nil:
+			requested := defaultQuantiles
+			if raw := r.URL.Query().Get("q"); raw != "" {
+				q, err := parseQuantile(raw)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				requested = map[string]float64{raw: q}
+			}
+			response = quantilesResponse{Metric: *resultMetric, Quantiles: quantiles(resultMetric.Summary.Quantile, requested)}
+		case resultMetric.Set != nil:
+			response = cardinalityResponse{Metric: *resultMetric, Cardinality: resultMetric.Set.Cardinality()}
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(resultMetric); err != nil {
+		if err := json.NewEncoder(w).Encode(response); err != nil {
 			logger.Error("Get: Error encoding JSON", zap.Error(err))
 			http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
 			return
@@ -181,12 +248,89 @@ func MetricGetAllHandler(srv Service, logger *zap.Logger) http.HandlerFunc {
 			}
 		}
 
+		if resultMetrics[entity.Histogram] != nil {
+			for _, metric := range resultMetrics[entity.Histogram] {
+				if _, err := io.WriteString(w, fmt.Sprintf("{{%s}}: {{%s}}: {{%s}}\n", entity.Histogram, metric.ID, formatHistogram(metric.Histogram))); err != nil {
+					logger.Error("Get all: print error", zap.Error(err))
+					http.Error(w, "Output error", http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
+		if resultMetrics[entity.Summary] != nil {
+			for _, metric := range resultMetrics[entity.Summary] {
+				if _, err := io.WriteString(w, fmt.Sprintf("{{%s}}: {{%s}}: {{%s}}\n", entity.Summary, metric.ID, formatSummary(metric.Summary))); err != nil {
+					logger.Error("Get all: print error", zap.Error(err))
+					http.Error(w, "Output error", http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
+		if resultMetrics[entity.Set] != nil {
+			for _, metric := range resultMetrics[entity.Set] {
+				if _, err := io.WriteString(w, fmt.Sprintf("{{%s}}: {{%s}}: {{%d}}\n", entity.Set, metric.ID, metric.Set.Cardinality())); err != nil {
+					logger.Error("Get all: print error", zap.Error(err))
+					http.Error(w, "Output error", http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
 		logger.Info("Get all: end")
 
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
+var defaultQuantiles = map[string]float64{"p50": 0.5, "p90": 0.9, "p99": 0.99}
+
+// quantiles оценивает запрошенные квантили. У пустого значения квантилей нет,
+// а NaN не кодируется в JSON, поэтому такие значения пропускаются.
+func quantiles(estimate func(q float64) float64, requested map[string]float64) map[string]float64 {
+	result := make(map[string]float64)
+	for name, q := range requested {
+		if v := estimate(q); !math.IsNaN(v) {
+			result[name] = v
+		}
+	}
+	return result
+}
+
+type quantilesResponse struct {
+	entity.Metric
+	Quantiles map[string]float64 `json:"quantiles"`
+}
+
+type cardinalityResponse struct {
+	entity.Metric
+	Cardinality uint64 `json:"cardinality"`
+}
+
+func parseQuantile(raw string) (float64, error) {
+	q, err := strconv.ParseFloat(raw, 64)
+	if err != nil || q < 0 || q > 1 {
+		return 0, fmt.Errorf("quantile must be a number in [0, 1], got %q", raw)
+	}
+	return q, nil
+}
+
+func formatHistogram(h *entity.HistogramValue) string {
+	if h == nil {
+		return ""
+	}
+	return fmt.Sprintf("count=%d sum=%g p50=%g p90=%g p99=%g", h.Count, h.Sum, h.Quantile(0.5), h.Quantile(0.9), h.Quantile(0.99))
+}
+
+func formatSummary(s *entity.SummaryValue) string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("count=%d sum=%g min=%g max=%g p50=%g p90=%g p99=%g",
+		s.Count, s.Sum, s.Min, s.Max, s.Quantile(0.5), s.Quantile(0.9), s.Quantile(0.99))
+}
+
 func PingDB(db *sql.DB, logger *zap.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if db == nil {
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+const defaultHistoryRange = time.Hour
+
+// HistoryHandler отдаёт сэмплы метрики за [from, to]. from и to принимаются
+// в RFC 3339 или в unix-секундах, по умолчанию — последний час. step — длительность
+// Go вроде 1m, с ним сэмплы сворачиваются по интервалам.
+func HistoryHandler(history History, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metricType := chi.URLParam(r, "type")
+		metricName := chi.URLParam(r, "name")
+		query := r.URL.Query()
+
+		from, to, err := parseRange(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var step time.Duration
+		if raw := query.Get("step"); raw != "" {
+			if step, err = time.ParseDuration(raw); err != nil || step <= 0 {
+				http.Error(w, fmt.Sprintf("invalid step %q", raw), http.StatusBadRequest)
+				return
+			}
+		}
+
+		samples, err := history.History(metricName, metricType, from, to, step)
+		if err != nil {
+			logger.Error("History: error", zap.Error(err))
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if samples == nil {
+			samples = []entity.Sample{}
+		}
+		if err := json.NewEncoder(w).Encode(samples); err != nil {
+			logger.Error("History: Error encoding JSON", zap.Error(err))
+		}
+	}
+}
+
+// parseRange разбирает from и to запроса. По умолчанию to — сейчас,
+// from — за defaultHistoryRange до to.
+func parseRange(query url.Values) (time.Time, time.Time, error) {
+	to := time.Now()
+	if raw := query.Get("to"); raw != "" {
+		var err error
+		if to, err = parseTime(raw); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	from := to.Add(-defaultHistoryRange)
+	if raw := query.Get("from"); raw != "" {
+		var err error
+		if from, err = parseTime(raw); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, errors.New("from must not be after to")
+	}
+	return from, to, nil
+}
+
+func parseTime(raw string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", raw)
+	}
+	return t, nil
+}
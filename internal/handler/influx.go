@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+	"github.com/WPGe/go-yandex-advanced/internal/ingest"
+)
+
+const maxInfluxBody = 32 << 20
+
+// InfluxWriteHandler принимает InfluxDB line protocol. Запрос пишется одним
+// вызовом AddMetrics: либо все поля, либо ничего.
+func InfluxWriteHandler(srv Service, influx *ingest.Influx, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxInfluxBody))
+		if err != nil {
+			logger.Info("Influx write: read body error", zap.Error(err))
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+
+		batch, err := influx.Decode(body)
+		if err != nil {
+			logger.Info("Influx write: parse error", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if len(batch.Metrics) > 0 {
+			if err := srv.AddMetrics(batch.Metrics); err != nil {
+				if errors.Is(err, entity.ErrInvalidMetric) {
+					logger.Info("Influx write: invalid metric", zap.Error(err))
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				logger.Error("Influx write: add error", zap.Error(err))
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+				return
+			}
+			influx.Commit(batch, batch.Metrics)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
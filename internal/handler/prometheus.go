@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+const (
+	prometheusContentType  = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+var prometheusQuantiles = []float64{0.5, 0.9, 0.99}
+
+// PrometheusHandler отдаёт все метрики в текстовом формате Prometheus, а клиентам,
+// которые просят application/openmetrics-text, — в OpenMetrics. Ответ пишется
+// в поток по мере обхода метрик, без сборки тела целиком.
+func PrometheusHandler(srv Service, metadata map[string]entity.MetricMetadata, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+		contentType := prometheusContentType
+		if openMetrics {
+			contentType = openMetricsContentType
+		}
+
+		bw := bufio.NewWriter(w)
+		e := exposition{
+			w:           bw,
+			openMetrics: openMetrics,
+			metadata:    metadata,
+			seen:        make(map[string]bool),
+			logger:      logger,
+		}
+		// Метрики приходят из хранилища по порядку ID и типа, заголовок
+		// отправляется с первой из них, чтобы ошибку чтения можно было вернуть кодом.
+		started := false
+		start := func() {
+			if !started {
+				w.Header().Set("Content-Type", contentType)
+				w.WriteHeader(http.StatusOK)
+				started = true
+			}
+		}
+		err := srv.EachMetric(func(m entity.Metric) error {
+			start()
+			e.write(m)
+			return r.Context().Err()
+		})
+		if err != nil {
+			logger.Error("Prometheus: iterate metrics error", zap.Error(err))
+			if !started {
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+			}
+			return
+		}
+		start()
+
+		if openMetrics {
+			bw.WriteString("# EOF\n")
+		}
+		if err := bw.Flush(); err != nil {
+			logger.Error("Prometheus: write error", zap.Error(err))
+		}
+	}
+}
+
+type exposition struct {
+	w           *bufio.Writer
+	openMetrics bool
+	metadata    map[string]entity.MetricMetadata
+	seen        map[string]bool
+	logger      *zap.Logger
+}
+
+// write выводит семейство одной метрики. Счётчики получают суффикс _total,
+// единица из метаданных дописывается к имени, если его там ещё нет.
+func (e *exposition) write(m entity.Metric) {
+	md := e.metadata[m.ID]
+	name := sanitizeMetricName(m.ID)
+	if m.MType == entity.Counter {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	unit := sanitizeMetricName(md.Unit)
+	if md.Unit != "" && !strings.HasSuffix(name, "_"+unit) {
+		name += "_" + unit
+	}
+
+	family, promType := name, m.MType
+	switch m.MType {
+	case entity.Counter:
+		if !e.openMetrics {
+			family = name + "_total"
+		}
+	case entity.Set:
+		promType = entity.Gauge
+	case entity.Gauge, entity.Histogram, entity.Summary:
+	default:
+		return
+	}
+	if e.seen[family] {
+		e.logger.Warn("Prometheus: duplicate metric name, skipped", zap.String("id", m.ID), zap.String("type", m.MType), zap.String("name", family))
+		return
+	}
+	e.seen[family] = true
+
+	if md.Help != "" {
+		fmt.Fprintf(e.w, "# HELP %s %s\n", family, escapeHelp(md.Help))
+	}
+	fmt.Fprintf(e.w, "# TYPE %s %s\n", family, promType)
+	if md.Unit != "" && e.openMetrics {
+		fmt.Fprintf(e.w, "# UNIT %s %s\n", family, unit)
+	}
+
+	switch m.MType {
+	case entity.Gauge:
+		if m.Value != nil {
+			e.sample(name, "", *m.Value)
+		}
+	case entity.Counter:
+		if m.Delta != nil {
+			e.sample(name+"_total", "", float64(*m.Delta))
+		}
+	case entity.Set:
+		if m.Set != nil {
+			e.sample(name, "", float64(m.Set.Cardinality()))
+		}
+	case entity.Histogram:
+		if h := m.Histogram; h != nil {
+			var cumulative uint64
+			for i, bound := range h.Bounds {
+				cumulative += h.Counts[i]
+				e.sample(name+"_bucket", `le="`+formatPromFloat(bound)+`"`, float64(cumulative))
+			}
+			e.sample(name+"_bucket", `le="+Inf"`, float64(h.Count))
+			e.sample(name+"_sum", "", h.Sum)
+			e.sample(name+"_count", "", float64(h.Count))
+		}
+	case entity.Summary:
+		if s := m.Summary; s != nil {
+			if s.Count > 0 {
+				for _, q := range prometheusQuantiles {
+					e.sample(name, `quantile="`+formatPromFloat(q)+`"`, s.Quantile(q))
+				}
+			}
+			e.sample(name+"_sum", "", s.Sum)
+			e.sample(name+"_count", "", float64(s.Count))
+		}
+	}
+}
+
+func (e *exposition) sample(name, labels string, value float64) {
+	e.w.WriteString(name)
+	if labels != "" {
+		e.w.WriteString("{" + labels + "}")
+	}
+	e.w.WriteString(" " + formatPromFloat(value) + "\n")
+}
+
+// sanitizeMetricName приводит имя к [a-zA-Z_:][a-zA-Z0-9_:]*, заменяя остальные символы на _.
+func sanitizeMetricName(id string) string {
+	var b strings.Builder
+	for i, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+			b.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func formatPromFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+	"github.com/WPGe/go-yandex-advanced/internal/ingest"
+)
+
+const (
+	maxRemoteWriteBody   = 32 << 20
+	remoteWriteBatchSize = 1000
+)
+
+// RemoteWriteHandler принимает remote_write Prometheus: snappy-сжатый protobuf
+// WriteRequest. Метрики пишутся пакетами по remoteWriteBatchSize. На 5xx
+// Prometheus повторяет запрос, поэтому ошибки записи не роняют сервер.
+func RemoteWriteHandler(srv Service, rw *ingest.RemoteWrite, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRemoteWriteBody))
+		if err != nil {
+			logger.Info("Remote write: read body error", zap.Error(err))
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+
+		batch, err := rw.Decode(body)
+		if err != nil {
+			logger.Info("Remote write: decode error", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for start := 0; start < len(batch.Metrics); start += remoteWriteBatchSize {
+			end := start + remoteWriteBatchSize
+			if end > len(batch.Metrics) {
+				end = len(batch.Metrics)
+			}
+			chunk := batch.Metrics[start:end]
+			if err := srv.AddMetrics(chunk); err != nil {
+				if errors.Is(err, entity.ErrInvalidMetric) {
+					logger.Info("Remote write: invalid metric", zap.Error(err))
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				logger.Error("Remote write: add error", zap.Error(err))
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+				return
+			}
+			rw.Commit(batch, chunk)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
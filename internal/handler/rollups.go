@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+type rollupsResponse struct {
+	Resolution string          `json:"resolution"`
+	Points     []entity.Rollup `json:"points"`
+}
+
+// RollupsHandler отдаёт агрегаты метрики за [from, to]. from и to разбираются как
+// в HistoryHandler, resolution (например 1h) выбирает уровень явно, иначе его
+// подбирает сервис по ширине диапазона.
+func RollupsHandler(rollups Rollups, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metricType := chi.URLParam(r, "type")
+		metricName := chi.URLParam(r, "name")
+		query := r.URL.Query()
+
+		from, to, err := parseRange(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var resolution time.Duration
+		if raw := query.Get("resolution"); raw != "" {
+			if resolution, err = time.ParseDuration(raw); err != nil || resolution <= 0 {
+				http.Error(w, fmt.Sprintf("invalid resolution %q", raw), http.StatusBadRequest)
+				return
+			}
+		}
+
+		points, tier, err := rollups.Rollups(metricName, metricType, from, to, resolution)
+		if errors.Is(err, entity.ErrUnknownTier) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error("Rollups: error", zap.Error(err))
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if points == nil {
+			points = []entity.Rollup{}
+		}
+		if err := json.NewEncoder(w).Encode(rollupsResponse{Resolution: tier.String(), Points: points}); err != nil {
+			logger.Error("Rollups: Error encoding JSON", zap.Error(err))
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"time"
+
 	"github.com/WPGe/go-yandex-advanced/internal/entity"
 )
 
@@ -9,4 +11,21 @@ type Service interface {
 	AddMetrics(metric []entity.Metric) error
 	GetMetric(id, metricType string) (*entity.Metric, error)
 	GetAllMetrics() (entity.MetricsStore, error)
+	EachMetric(fn func(entity.Metric) error) error
+}
+
+type History interface {
+	History(id, mType string, from, to time.Time, step time.Duration) ([]entity.Sample, error)
+}
+
+type Rollups interface {
+	Rollups(id, mType string, from, to time.Time, resolution time.Duration) ([]entity.Rollup, time.Duration, error)
+}
+
+type AgentConfigs interface {
+	AgentConfig(instance, group string) (*entity.AgentConfig, error)
+	Profiles() ([]entity.AgentProfile, error)
+	SaveProfile(profile entity.AgentProfile) (*entity.AgentProfile, error)
+	ReportApplied(instance, version string) error
+	AppliedConfigs() ([]entity.AgentConfigStatus, error)
 }
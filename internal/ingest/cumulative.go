@@ -0,0 +1,106 @@
+package ingest
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+// seriesIdle — сколько помнить ряд без новых значений. Вернувшийся после этого
+// ряд начинается заново, как после перезапуска сервера.
+const seriesIdle = time.Hour
+
+// cumulative помнит последние накопительные значения рядов, чтобы переводить
+// их в приращения счётчиков сервера. Ряды, которые не обновлялись дольше
+// seriesIdle, забываются при очередном commit.
+type cumulative struct {
+	mu     sync.Mutex
+	totals map[string]cumulativeTotal
+	swept  time.Time
+	now    func() time.Time
+}
+
+type cumulativeTotal struct {
+	value   float64
+	updated time.Time
+}
+
+func newCumulative() *cumulative {
+	return &cumulative{
+		totals: make(map[string]cumulativeTotal),
+		swept:  time.Now(),
+		now:    time.Now,
+	}
+}
+
+// Batch — метрики одного запроса. Накопленные значения счётчиков применяются
+// через Commit только после успешной записи, чтобы повтор запроса после
+// ошибки не потерял приращения.
+type Batch struct {
+	Metrics []entity.Metric
+	totals  map[string]float64
+}
+
+func newBatch() *Batch {
+	return &Batch{totals: make(map[string]float64)}
+}
+
+// addCounter переводит значения ряда id в одно приращение. Первое значение ряда
+// только запоминается, уменьшение считается сбросом счётчика. Без единого
+// значения метрика не добавляется.
+func (c *cumulative) addCounter(batch *Batch, id string, values []float64) {
+	last, seen := batch.totals[id]
+	if !seen {
+		c.mu.Lock()
+		var total cumulativeTotal
+		total, seen = c.totals[id]
+		last = total.value
+		c.mu.Unlock()
+	}
+
+	var delta int64
+	var any bool
+	for _, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		switch {
+		case !seen:
+		case v < last:
+			delta += int64(math.Round(v))
+		default:
+			delta += int64(math.Round(v)) - int64(math.Round(last))
+		}
+		last, seen, any = v, true, true
+	}
+	if !any {
+		return
+	}
+	batch.totals[id] = last
+	batch.Metrics = append(batch.Metrics, entity.Metric{ID: id, MType: entity.Counter, Delta: &delta})
+}
+
+// commit запоминает накопленные значения счётчиков, попавших в записанную часть пакета.
+func (c *cumulative) commit(batch *Batch, written []entity.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for _, m := range written {
+		if total, ok := batch.totals[m.ID]; ok && m.MType == entity.Counter {
+			c.totals[m.ID] = cumulativeTotal{value: total, updated: now}
+		}
+	}
+
+	if now.Sub(c.swept) < seriesIdle {
+		return
+	}
+	for id, total := range c.totals {
+		if now.Sub(total.updated) > seriesIdle {
+			delete(c.totals, id)
+		}
+	}
+	c.swept = now
+}
@@ -0,0 +1,345 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+const (
+	// GraphiteMalformedID — счётчик строк, которые не удалось разобрать.
+	GraphiteMalformedID = "GraphiteMalformedLines"
+	// GraphiteDroppedID — счётчик строк, отброшенных из-за переполнения очереди.
+	GraphiteDroppedID = "GraphiteDroppedLines"
+
+	graphiteMaxLineLength = 4096
+	graphiteIdleTimeout   = time.Minute
+	graphiteBatchSize     = 1000
+	// graphiteMaxPending ограничивает очередь, пока запись в хранилище не удаётся.
+	graphiteMaxPending    = 10 * graphiteBatchSize
+	graphiteFlushInterval = time.Second
+	graphiteMaxDatagram   = 64 << 10
+)
+
+// Writer — получатель разобранных метрик, обычно service.Service.
+type Writer interface {
+	AddMetrics(metrics []entity.Metric) error
+}
+
+// Graphite принимает plaintext-протокол Graphite (path value timestamp) по TCP и UDP.
+// Строки становятся гейджами, а пути, подходящие под шаблоны counters, — счётчиками
+// с приращением value. Время из строки проверяется, но не используется: сервер
+// хранит момент приёма. Метрики копятся и пишутся пакетами, неудачный пакет
+// возвращается в очередь. Строки сверх graphiteMaxPending отбрасываются и
+// считаются в GraphiteDroppedID.
+type Graphite struct {
+	addr     string
+	counters []string
+	maxConns int
+	writer   Writer
+	logger   *zap.Logger
+
+	mu        sync.Mutex
+	batch     []entity.Metric
+	malformed int64
+	dropped   int64
+	full      chan struct{}
+
+	connsMu sync.Mutex
+	conns   map[net.Conn]struct{}
+	closing bool
+}
+
+// NewGraphite проверяет шаблоны путей. Шаблон сравнивается с путём по сегментам
+// между точками, как в Graphite: в каждом сегменте действует синтаксис path.Match,
+// так что * не выходит за пределы сегмента. maxConns ограничивает число
+// одновременных TCP-соединений, лишние закрываются сразу.
+func NewGraphite(logger *zap.Logger, writer Writer, addr string, counters []string, maxConns int) (*Graphite, error) {
+	for _, pattern := range counters {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid graphite counter pattern %q: %w", pattern, err)
+		}
+	}
+	if maxConns <= 0 {
+		return nil, errors.New("graphite max connections must be positive")
+	}
+	return &Graphite{
+		addr:     addr,
+		counters: counters,
+		maxConns: maxConns,
+		writer:   writer,
+		logger:   logger,
+		full:     make(chan struct{}, 1),
+		conns:    make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Run слушает addr до отмены ctx, после чего дописывает накопленный пакет.
+func (g *Graphite) Run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", g.addr)
+	if err != nil {
+		return fmt.Errorf("graphite tcp listen: %w", err)
+	}
+	pc, err := net.ListenPacket("udp", g.addr)
+	if err != nil {
+		ln.Close()
+		return fmt.Errorf("graphite udp listen: %w", err)
+	}
+	g.logger.Info("Starting graphite listener", zap.String("addr", g.addr))
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		g.serveTCP(ln, &wg)
+	}()
+	go func() {
+		defer wg.Done()
+		g.serveUDP(pc)
+	}()
+	done := make(chan struct{})
+	go func() {
+		defer wg.Done()
+		g.flushLoop(done)
+	}()
+
+	<-ctx.Done()
+	ln.Close()
+	pc.Close()
+	g.connsMu.Lock()
+	g.closing = true
+	for conn := range g.conns {
+		conn.Close()
+	}
+	g.connsMu.Unlock()
+	close(done)
+	wg.Wait()
+	g.flush()
+	return nil
+}
+
+func (g *Graphite) serveTCP(ln net.Listener, wg *sync.WaitGroup) {
+	slots := make(chan struct{}, g.maxConns)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			g.logger.Warn("Graphite: connection limit reached", zap.String("remote", conn.RemoteAddr().String()))
+			conn.Close()
+			continue
+		}
+
+		g.connsMu.Lock()
+		if g.closing {
+			g.connsMu.Unlock()
+			conn.Close()
+			return
+		}
+		g.conns[conn] = struct{}{}
+		g.connsMu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.handleConn(conn)
+			g.connsMu.Lock()
+			delete(g.conns, conn)
+			g.connsMu.Unlock()
+			conn.Close()
+			<-slots
+		}()
+	}
+}
+
+// handleConn читает строки до EOF или простоя дольше graphiteIdleTimeout.
+// Строка длиннее graphiteMaxLineLength пропускается целиком и считается битой.
+func (g *Graphite) handleConn(conn net.Conn) {
+	r := bufio.NewReaderSize(conn, graphiteMaxLineLength)
+	for {
+		conn.SetReadDeadline(time.Now().Add(graphiteIdleTimeout))
+		line, err := r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			g.addMalformed()
+			for errors.Is(err, bufio.ErrBufferFull) {
+				_, err = r.ReadSlice('\n')
+			}
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if len(line) > 0 {
+			g.handleLine(string(line))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (g *Graphite) serveUDP(pc net.PacketConn) {
+	buf := make([]byte, graphiteMaxDatagram)
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			g.handleLine(line)
+		}
+	}
+}
+
+func (g *Graphite) handleLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	metric, err := g.parseLine(line)
+	if err != nil {
+		g.logger.Debug("Graphite: malformed line", zap.String("line", line), zap.Error(err))
+		g.addMalformed()
+		return
+	}
+
+	g.mu.Lock()
+	if len(g.batch) >= graphiteMaxPending {
+		g.dropped++
+		g.mu.Unlock()
+		return
+	}
+	g.batch = append(g.batch, metric)
+	full := len(g.batch) >= graphiteBatchSize
+	g.mu.Unlock()
+	if full {
+		select {
+		case g.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (g *Graphite) parseLine(line string) (entity.Metric, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 && len(fields) != 3 {
+		return entity.Metric{}, errors.New("expected path, value and optional timestamp")
+	}
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return entity.Metric{}, fmt.Errorf("invalid value %q", fields[1])
+	}
+	if len(fields) == 3 {
+		if _, err := strconv.ParseFloat(fields[2], 64); err != nil {
+			return entity.Metric{}, fmt.Errorf("invalid timestamp %q", fields[2])
+		}
+	}
+
+	id := fields[0]
+	name, _, _ := strings.Cut(id, ";")
+	for _, pattern := range g.counters {
+		if matchGraphitePath(pattern, name) {
+			delta := int64(math.Round(value))
+			return entity.Metric{ID: id, MType: entity.Counter, Delta: &delta}, nil
+		}
+	}
+	return entity.Metric{ID: id, MType: entity.Gauge, Value: &value}, nil
+}
+
+func (g *Graphite) addMalformed() {
+	g.mu.Lock()
+	g.malformed++
+	g.mu.Unlock()
+}
+
+func (g *Graphite) flushLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(graphiteFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			g.flush()
+		case <-g.full:
+			g.flush()
+		}
+	}
+}
+
+func (g *Graphite) flush() {
+	g.mu.Lock()
+	batch := g.batch
+	malformed, dropped := g.malformed, g.dropped
+	g.batch, g.malformed, g.dropped = nil, 0, 0
+	g.mu.Unlock()
+
+	metrics := batch[:len(batch):len(batch)]
+	if malformed > 0 {
+		metrics = append(metrics, entity.Metric{ID: GraphiteMalformedID, MType: entity.Counter, Delta: &malformed})
+	}
+	if dropped > 0 {
+		metrics = append(metrics, entity.Metric{ID: GraphiteDroppedID, MType: entity.Counter, Delta: &dropped})
+	}
+	if len(metrics) == 0 {
+		return
+	}
+	err := g.writer.AddMetrics(metrics)
+	if err == nil {
+		return
+	}
+	g.logger.Error("Graphite: add metrics error", zap.Int("count", len(metrics)), zap.Error(err))
+	if errors.Is(err, entity.ErrInvalidMetric) {
+		// Повтор того же пакета снова будет отклонён.
+		return
+	}
+	g.requeue(batch, malformed, dropped)
+}
+
+// requeue возвращает неудачный пакет в начало очереди вместе со счётчиками.
+// Не поместившиеся старые строки отбрасываются.
+func (g *Graphite) requeue(batch []entity.Metric, malformed, dropped int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	room := graphiteMaxPending - len(g.batch)
+	if room < 0 {
+		room = 0
+	}
+	if len(batch) > room {
+		dropped += int64(len(batch) - room)
+		batch = batch[len(batch)-room:]
+	}
+	g.batch = append(batch, g.batch...)
+	g.malformed += malformed
+	g.dropped += dropped
+}
+
+// matchGraphitePath сравнивает путь с шаблоном по сегментам между точками.
+func matchGraphitePath(pattern, name string) bool {
+	patterns := strings.Split(pattern, ".")
+	segments := strings.Split(name, ".")
+	if len(patterns) != len(segments) {
+		return false
+	}
+	for i := range patterns {
+		if ok, _ := path.Match(patterns[i], segments[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
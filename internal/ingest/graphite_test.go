@@ -0,0 +1,121 @@
+package ingest
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+// failingWriter отказывает, пока err не сброшен, и запоминает принятые пакеты.
+type failingWriter struct {
+	err     error
+	batches [][]entity.Metric
+}
+
+func (w *failingWriter) AddMetrics(metrics []entity.Metric) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.batches = append(w.batches, metrics)
+	return nil
+}
+
+func (w *failingWriter) counter(id string) int64 {
+	var total int64
+	for _, batch := range w.batches {
+		for _, m := range batch {
+			if m.ID == id && m.MType == entity.Counter {
+				total += *m.Delta
+			}
+		}
+	}
+	return total
+}
+
+func TestMatchGraphitePath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{pattern: "stats.counts.*", name: "stats.counts.hits", want: true},
+		{pattern: "stats.counts.*", name: "stats.counts.web.hits", want: false},
+		{pattern: "stats.*.hits", name: "stats.counts.hits", want: true},
+		{pattern: "*", name: "stats.hits", want: false},
+		{pattern: "stats.hit?", name: "stats.hits", want: true},
+		{pattern: "stats.[a-c]*", name: "stats.counts", want: true},
+		{pattern: "stats.[a-c]*", name: "stats.timers", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, matchGraphitePath(tt.pattern, tt.name))
+		})
+	}
+}
+
+func TestGraphiteFlush(t *testing.T) {
+	t.Run("failed flush keeps the batch", func(t *testing.T) {
+		writer := &failingWriter{err: errors.New("storage unavailable")}
+		g, err := NewGraphite(zap.NewNop(), writer, "", []string{"hits"}, 1)
+		require.NoError(t, err)
+
+		g.handleLine("cpu 1")
+		g.handleLine("hits 2")
+		g.handleLine("garbage")
+		g.flush()
+		g.handleLine("cpu 3")
+		g.handleLine("hits 4")
+
+		writer.err = nil
+		g.flush()
+		require.Len(t, writer.batches, 1)
+		assert.Equal(t, int64(6), writer.counter("hits"))
+		assert.Equal(t, int64(1), writer.counter(GraphiteMalformedID))
+
+		var gauges []float64
+		for _, m := range writer.batches[0] {
+			if m.ID == "cpu" {
+				gauges = append(gauges, *m.Value)
+			}
+		}
+		assert.Equal(t, []float64{1, 3}, gauges, "requeued lines go before newer ones")
+	})
+
+	t.Run("rejected batch is not retried", func(t *testing.T) {
+		writer := &failingWriter{err: entity.ErrInvalidMetric}
+		g, err := NewGraphite(zap.NewNop(), writer, "", nil, 1)
+		require.NoError(t, err)
+
+		g.handleLine("cpu 1")
+		g.flush()
+		writer.err = nil
+		g.flush()
+		assert.Empty(t, writer.batches)
+	})
+
+	t.Run("pending lines are capped", func(t *testing.T) {
+		writer := &failingWriter{err: errors.New("storage unavailable")}
+		g, err := NewGraphite(zap.NewNop(), writer, "", nil, 1)
+		require.NoError(t, err)
+
+		for i := 0; i < graphiteMaxPending+5; i++ {
+			g.handleLine("cpu." + strconv.Itoa(i) + " 1")
+		}
+		g.flush()
+		g.handleLine("cpu.last 1")
+		g.flush()
+
+		writer.err = nil
+		g.flush()
+		require.Len(t, writer.batches, 1)
+		assert.Len(t, writer.batches[0], graphiteMaxPending+1)
+		assert.Equal(t, int64(6), writer.counter(GraphiteDroppedID))
+		assert.Equal(t, "cpu.9999", writer.batches[0][graphiteMaxPending-1].ID, "lines over the cap are dropped")
+	})
+}
@@ -0,0 +1,182 @@
+package ingest
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+// Режимы записи целочисленных полей line protocol.
+const (
+	InfluxIntegersGauge   = "gauge"
+	InfluxIntegersCounter = "counter"
+)
+
+var influxUnescaper = strings.NewReplacer(`\,`, ",", `\=`, "=", `\ `, " ")
+
+// Influx переводит InfluxDB line protocol в метрики сервера. Каждое поле
+// становится метрикой с ID measurement.field и тегами строки. Дробные и
+// логические поля — гейджи, строковые пропускаются. Целые поля в режиме
+// counter считаются накопительными, как их отдаёт Telegraf, и переводятся
+// в приращения, в режиме gauge пишутся как есть.
+type Influx struct {
+	integers string
+	counters *cumulative
+}
+
+func NewInflux(integers string) (*Influx, error) {
+	if integers != InfluxIntegersGauge && integers != InfluxIntegersCounter {
+		return nil, fmt.Errorf("unknown influx integers mode %q", integers)
+	}
+	return &Influx{integers: integers, counters: newCumulative()}, nil
+}
+
+// Decode разбирает тело запроса целиком: если хотя бы одна строка битая,
+// возвращается ошибка и не пишется ничего.
+func (in *Influx) Decode(body []byte) (*Batch, error) {
+	batch := newBatch()
+	for i, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := in.decodeLine(batch, line); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", entity.ErrInvalidMetric, i+1, err)
+		}
+	}
+	return batch, nil
+}
+
+// Commit запоминает накопленные значения целых полей из записанного пакета.
+func (in *Influx) Commit(batch *Batch, written []entity.Metric) {
+	in.counters.commit(batch, written)
+}
+
+// decodeLine разбирает measurement[,tag=v...] field=v[,field=v...] [timestamp].
+// Время проверяется, но не используется: сервер хранит момент приёма.
+func (in *Influx) decodeLine(batch *Batch, line string) error {
+	end := indexUnescaped(line, ' ', false)
+	if end <= 0 {
+		return fmt.Errorf("missing fields")
+	}
+	series, rest := line[:end], strings.TrimLeft(line[end:], " ")
+	end = indexUnescaped(rest, ' ', true)
+	if end < 0 {
+		end = len(rest)
+	}
+	fieldSet, timestamp := rest[:end], strings.TrimSpace(rest[end:])
+	if fieldSet == "" {
+		return fmt.Errorf("missing fields")
+	}
+	if timestamp != "" {
+		if _, err := strconv.ParseInt(timestamp, 10, 64); err != nil {
+			return fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+	}
+
+	parts := splitUnescaped(series, ',', false)
+	measurement := influxUnescaper.Replace(parts[0])
+	if measurement == "" {
+		return fmt.Errorf("missing measurement")
+	}
+	tags := make(map[string]string, len(parts)-1)
+	for _, tag := range parts[1:] {
+		eq := indexUnescaped(tag, '=', false)
+		if eq <= 0 {
+			return fmt.Errorf("invalid tag %q", tag)
+		}
+		tags[influxUnescaper.Replace(tag[:eq])] = influxUnescaper.Replace(tag[eq+1:])
+	}
+
+	for _, field := range splitUnescaped(fieldSet, ',', true) {
+		eq := indexUnescaped(field, '=', true)
+		if eq <= 0 {
+			return fmt.Errorf("invalid field %q", field)
+		}
+		key, raw := influxUnescaper.Replace(field[:eq]), field[eq+1:]
+		id := entity.TaggedID(measurement+"."+key, tags)
+
+		value, integer, ok, err := parseInfluxValue(raw)
+		if err != nil {
+			return fmt.Errorf("field %q: %w", key, err)
+		}
+		if !ok {
+			continue
+		}
+		if integer && in.integers == InfluxIntegersCounter {
+			in.counters.addCounter(batch, id, []float64{value})
+			continue
+		}
+		batch.Metrics = append(batch.Metrics, entity.Metric{ID: id, MType: entity.Gauge, Value: &value})
+	}
+	return nil
+}
+
+// parseInfluxValue разбирает значение поля. ok=false у строковых полей,
+// которые не становятся метриками.
+func parseInfluxValue(raw string) (value float64, integer bool, ok bool, err error) {
+	switch {
+	case raw == "":
+		return 0, false, false, fmt.Errorf("empty value")
+	case strings.HasPrefix(raw, `"`):
+		if len(raw) < 2 || !strings.HasSuffix(raw, `"`) {
+			return 0, false, false, fmt.Errorf("unterminated string %s", raw)
+		}
+		return 0, false, false, nil
+	case strings.HasSuffix(raw, "i"):
+		v, err := strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		if err != nil {
+			return 0, false, false, fmt.Errorf("invalid integer %q", raw)
+		}
+		return float64(v), true, true, nil
+	case strings.HasSuffix(raw, "u"):
+		v, err := strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+		if err != nil {
+			return 0, false, false, fmt.Errorf("invalid unsigned integer %q", raw)
+		}
+		return float64(v), true, true, nil
+	}
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, false, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, false, true, nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false, false, fmt.Errorf("invalid float %q", raw)
+	}
+	return v, false, true, nil
+}
+
+// indexUnescaped ищет sep, пропуская экранированные обратной косой чертой
+// символы и, если quotes, содержимое строк в двойных кавычках.
+func indexUnescaped(s string, sep byte, quotes bool) int {
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case quotes && c == '"':
+			inQuotes = !inQuotes
+		case c == sep && !inQuotes:
+			return i
+		}
+	}
+	return -1
+}
+
+func splitUnescaped(s string, sep byte, quotes bool) []string {
+	var parts []string
+	for {
+		i := indexUnescaped(s, sep, quotes)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
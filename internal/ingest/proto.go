@@ -0,0 +1,84 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errCorruptProto = errors.New("protobuf: corrupt input")
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// protoReader читает поля protobuf по порядку, без схемы: что делать
+// с полем, решает вызывающий код по номеру.
+type protoReader struct {
+	b []byte
+}
+
+func (r *protoReader) done() bool {
+	return len(r.b) == 0
+}
+
+func (r *protoReader) next() (field int, wire int, err error) {
+	tag, err := r.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(tag >> 3), int(tag & 7), nil
+}
+
+func (r *protoReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		return 0, errCorruptProto
+	}
+	r.b = r.b[n:]
+	return v, nil
+}
+
+func (r *protoReader) fixed64() (uint64, error) {
+	if len(r.b) < 8 {
+		return 0, errCorruptProto
+	}
+	v := binary.LittleEndian.Uint64(r.b)
+	r.b = r.b[8:]
+	return v, nil
+}
+
+func (r *protoReader) bytes() ([]byte, error) {
+	n, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.b)) {
+		return nil, errCorruptProto
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v, nil
+}
+
+func (r *protoReader) skip(wire int) error {
+	var err error
+	switch wire {
+	case wireVarint:
+		_, err = r.varint()
+	case wireFixed64:
+		_, err = r.fixed64()
+	case wireBytes:
+		_, err = r.bytes()
+	case wireFixed32:
+		if len(r.b) < 4 {
+			return errCorruptProto
+		}
+		r.b = r.b[4:]
+	default:
+		return errCorruptProto
+	}
+	return err
+}
@@ -0,0 +1,103 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appendProtoBytes(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendProtoVarint(b []byte, field int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|wireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+func appendProtoDouble(b []byte, field int, v float64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|wireFixed64)
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+}
+
+func TestProtoReader(t *testing.T) {
+	var b []byte
+	b = appendProtoVarint(b, 1, 300)
+	b = appendProtoDouble(b, 2, 1.5)
+	b = appendProtoBytes(b, 3, []byte("abc"))
+	b = append(binary.AppendUvarint(b, 4<<3|wireFixed32), 1, 2, 3, 4)
+
+	r := protoReader{b: b}
+	field, wire, err := r.next()
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, wireVarint}, []int{field, wire})
+	v, err := r.varint()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(300), v)
+
+	field, wire, err = r.next()
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, wireFixed64}, []int{field, wire})
+	bits, err := r.fixed64()
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, math.Float64frombits(bits))
+
+	field, wire, err = r.next()
+	require.NoError(t, err)
+	assert.Equal(t, []int{3, wireBytes}, []int{field, wire})
+	raw, err := r.bytes()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abc"), raw)
+
+	_, wire, err = r.next()
+	require.NoError(t, err)
+	require.NoError(t, r.skip(wire))
+	assert.True(t, r.done())
+
+	t.Run("corrupt input", func(t *testing.T) {
+		_, err := (&protoReader{b: []byte{0x80}}).varint()
+		assert.ErrorIs(t, err, errCorruptProto)
+		_, err = (&protoReader{b: []byte{1, 2, 3}}).fixed64()
+		assert.ErrorIs(t, err, errCorruptProto)
+		_, err = (&protoReader{b: []byte{0x05, 'a'}}).bytes()
+		assert.ErrorIs(t, err, errCorruptProto)
+		assert.ErrorIs(t, (&protoReader{b: []byte{0}}).skip(3), errCorruptProto, "group wire types are not supported")
+	})
+}
+
+func TestDecodeWriteRequest(t *testing.T) {
+	label := func(name, value string) []byte {
+		return appendProtoBytes(appendProtoBytes(nil, 1, []byte(name)), 2, []byte(value))
+	}
+	sample := func(value float64, timestamp int64) []byte {
+		return appendProtoVarint(appendProtoDouble(nil, 1, value), 2, uint64(timestamp))
+	}
+
+	var series []byte
+	series = appendProtoBytes(series, 1, label("__name__", "up"))
+	series = appendProtoBytes(series, 1, label("job", "node"))
+	series = appendProtoBytes(series, 2, sample(1, 2000))
+	series = appendProtoBytes(series, 2, sample(0, 1000))
+	series = appendProtoBytes(series, 3, []byte("exemplar"))
+
+	var req []byte
+	req = appendProtoBytes(req, 1, series)
+	req = appendProtoBytes(req, 3, appendProtoBytes(appendProtoVarint(nil, 1, promCounter), 2, []byte("requests")))
+	req = appendProtoBytes(req, 3, appendProtoBytes(appendProtoVarint(nil, 1, promUnknown), 2, []byte("other")))
+	req = appendProtoVarint(req, 9, 1)
+
+	got, metadata, err := decodeWriteRequest(req)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, map[string]string{"__name__": "up", "job": "node"}, got[0].labels)
+	assert.Equal(t, []promSample{{value: 1, timestamp: 2000}, {value: 0, timestamp: 1000}}, got[0].samples)
+	assert.Equal(t, map[string]int{"requests": promCounter}, metadata)
+
+	_, _, err = decodeWriteRequest(req[:len(req)-4])
+	assert.Error(t, err)
+}
@@ -0,0 +1,340 @@
+package ingest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+// Типы из prometheus/prompb MetricMetadata.MetricType, которые влияют на выбор
+// между счётчиком и гейджем. Остальные типы отображаются в гейдж.
+const (
+	promUnknown   = 0
+	promCounter   = 1
+	promHistogram = 3
+	promSummary   = 5
+)
+
+// RemoteWrite переводит WriteRequest Prometheus в метрики сервера.
+// Тип ряда берётся из метаданных, которые Prometheus присылает вместе с рядами
+// или отдельными запросами, а без них — по суффиксу имени. Счётчики Prometheus
+// накопительные и переводятся в приращения. Тип семейства, о котором не было
+// ни метаданных, ни рядов дольше seriesIdle, забывается.
+type RemoteWrite struct {
+	mu       sync.Mutex
+	families map[string]promFamily
+	swept    time.Time
+	now      func() time.Time
+	counters *cumulative
+}
+
+type promFamily struct {
+	t       int
+	updated time.Time
+}
+
+func NewRemoteWrite() *RemoteWrite {
+	return &RemoteWrite{
+		families: make(map[string]promFamily),
+		swept:    time.Now(),
+		now:      time.Now,
+		counters: newCumulative(),
+	}
+}
+
+type promSample struct {
+	value     float64
+	timestamp int64
+}
+
+type promSeries struct {
+	labels  map[string]string
+	samples []promSample
+}
+
+// Decode распаковывает тело запроса и собирает метрики. Гейджи попадают
+// в пакет каждым сэмплом по порядку времени, счётчик — одним приращением за запрос.
+func (rw *RemoteWrite) Decode(body []byte) (*Batch, error) {
+	raw, err := snappyDecode(body)
+	if err != nil {
+		return nil, err
+	}
+	series, metadata, err := decodeWriteRequest(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	now := rw.now()
+	for family, t := range metadata {
+		rw.families[family] = promFamily{t: t, updated: now}
+	}
+	defer rw.sweep(now)
+
+	batch := newBatch()
+	for _, s := range series {
+		name := s.labels["__name__"]
+		if name == "" {
+			return nil, fmt.Errorf("%w: series without __name__", entity.ErrInvalidMetric)
+		}
+		delete(s.labels, "__name__")
+		id := entity.TaggedID(name, s.labels)
+		sort.Slice(s.samples, func(i, j int) bool { return s.samples[i].timestamp < s.samples[j].timestamp })
+
+		if rw.metricType(name, now) == entity.Gauge {
+			for _, sample := range s.samples {
+				if math.IsNaN(sample.value) {
+					continue
+				}
+				value := sample.value
+				batch.Metrics = append(batch.Metrics, entity.Metric{ID: id, MType: entity.Gauge, Value: &value})
+			}
+			continue
+		}
+
+		values := make([]float64, 0, len(s.samples))
+		for _, sample := range s.samples {
+			values = append(values, sample.value)
+		}
+		rw.counters.addCounter(batch, id, values)
+	}
+	return batch, nil
+}
+
+// Commit запоминает накопленные значения счётчиков из записанной части пакета.
+func (rw *RemoteWrite) Commit(batch *Batch, written []entity.Metric) {
+	rw.counters.commit(batch, written)
+}
+
+// metricType выбирает тип ряда и отмечает, что семейство ещё используется.
+func (rw *RemoteWrite) metricType(name string, now time.Time) string {
+	family := func(name string) (int, bool) {
+		f, ok := rw.families[name]
+		if ok {
+			f.updated = now
+			rw.families[name] = f
+		}
+		return f.t, ok
+	}
+
+	if t, ok := family(name); ok {
+		if t == promCounter {
+			return entity.Counter
+		}
+		return entity.Gauge
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		if t, ok := family(strings.TrimSuffix(name, suffix)); ok {
+			if t == promHistogram || t == promSummary {
+				return entity.Counter
+			}
+			return entity.Gauge
+		}
+	}
+	if strings.HasSuffix(name, "_total") {
+		if t, ok := family(strings.TrimSuffix(name, "_total")); ok {
+			if t == promCounter {
+				return entity.Counter
+			}
+			return entity.Gauge
+		}
+	}
+	for _, suffix := range []string{"_total", "_bucket", "_sum", "_count"} {
+		if strings.HasSuffix(name, suffix) {
+			return entity.Counter
+		}
+	}
+	return entity.Gauge
+}
+
+// sweep не чаще раза в seriesIdle удаляет давно не встречавшиеся семейства.
+func (rw *RemoteWrite) sweep(now time.Time) {
+	if now.Sub(rw.swept) < seriesIdle {
+		return
+	}
+	for name, f := range rw.families {
+		if now.Sub(f.updated) > seriesIdle {
+			delete(rw.families, name)
+		}
+	}
+	rw.swept = now
+}
+
+// decodeWriteRequest разбирает prometheus.WriteRequest: ряды (поле 1) и метаданные (поле 3).
+func decodeWriteRequest(b []byte) ([]promSeries, map[string]int, error) {
+	var series []promSeries
+	metadata := make(map[string]int)
+
+	r := protoReader{b: b}
+	for !r.done() {
+		field, wire, err := r.next()
+		if err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case field == 1 && wire == wireBytes:
+			raw, err := r.bytes()
+			if err != nil {
+				return nil, nil, err
+			}
+			s, err := decodeTimeSeries(raw)
+			if err != nil {
+				return nil, nil, err
+			}
+			series = append(series, s)
+		case field == 3 && wire == wireBytes:
+			raw, err := r.bytes()
+			if err != nil {
+				return nil, nil, err
+			}
+			family, t, err := decodeMetadata(raw)
+			if err != nil {
+				return nil, nil, err
+			}
+			if family != "" && t != promUnknown {
+				metadata[family] = t
+			}
+		default:
+			if err := r.skip(wire); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return series, metadata, nil
+}
+
+// decodeTimeSeries разбирает TimeSeries: метки (1) и сэмплы (2). Экземпляры
+// и нативные гистограммы пропускаются.
+func decodeTimeSeries(b []byte) (promSeries, error) {
+	s := promSeries{labels: make(map[string]string)}
+	r := protoReader{b: b}
+	for !r.done() {
+		field, wire, err := r.next()
+		if err != nil {
+			return s, err
+		}
+		switch {
+		case field == 1 && wire == wireBytes:
+			raw, err := r.bytes()
+			if err != nil {
+				return s, err
+			}
+			name, value, err := decodeLabel(raw)
+			if err != nil {
+				return s, err
+			}
+			s.labels[name] = value
+		case field == 2 && wire == wireBytes:
+			raw, err := r.bytes()
+			if err != nil {
+				return s, err
+			}
+			sample, err := decodeSample(raw)
+			if err != nil {
+				return s, err
+			}
+			s.samples = append(s.samples, sample)
+		default:
+			if err := r.skip(wire); err != nil {
+				return s, err
+			}
+		}
+	}
+	return s, nil
+}
+
+func decodeLabel(b []byte) (name, value string, err error) {
+	r := protoReader{b: b}
+	for !r.done() {
+		field, wire, err := r.next()
+		if err != nil {
+			return "", "", err
+		}
+		if wire != wireBytes || (field != 1 && field != 2) {
+			if err := r.skip(wire); err != nil {
+				return "", "", err
+			}
+			continue
+		}
+		raw, err := r.bytes()
+		if err != nil {
+			return "", "", err
+		}
+		if field == 1 {
+			name = string(raw)
+		} else {
+			value = string(raw)
+		}
+	}
+	return name, value, nil
+}
+
+func decodeSample(b []byte) (promSample, error) {
+	var s promSample
+	r := protoReader{b: b}
+	for !r.done() {
+		field, wire, err := r.next()
+		if err != nil {
+			return s, err
+		}
+		switch {
+		case field == 1 && wire == wireFixed64:
+			bits, err := r.fixed64()
+			if err != nil {
+				return s, err
+			}
+			s.value = math.Float64frombits(bits)
+		case field == 2 && wire == wireVarint:
+			v, err := r.varint()
+			if err != nil {
+				return s, err
+			}
+			s.timestamp = int64(v)
+		default:
+			if err := r.skip(wire); err != nil {
+				return s, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// decodeMetadata разбирает MetricMetadata: тип (1) и имя семейства (2).
+func decodeMetadata(b []byte) (family string, t int, err error) {
+	r := protoReader{b: b}
+	for !r.done() {
+		field, wire, err := r.next()
+		if err != nil {
+			return "", 0, err
+		}
+		switch {
+		case field == 1 && wire == wireVarint:
+			v, err := r.varint()
+			if err != nil {
+				return "", 0, err
+			}
+			t = int(v)
+		case field == 2 && wire == wireBytes:
+			raw, err := r.bytes()
+			if err != nil {
+				return "", 0, err
+			}
+			family = string(raw)
+		default:
+			if err := r.skip(wire); err != nil {
+				return "", 0, err
+			}
+		}
+	}
+	return family, t, nil
+}
@@ -0,0 +1,73 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+// writeRequest собирает WriteRequest из рядов name → значения и упаковывает
+// его одним литералом snappy.
+func writeRequest(series map[string][]float64, metadata map[string]int) []byte {
+	var req []byte
+	for name, values := range series {
+		ts := appendProtoBytes(nil, 1, appendProtoBytes(appendProtoBytes(nil, 1, []byte("__name__")), 2, []byte(name)))
+		for i, v := range values {
+			ts = appendProtoBytes(ts, 2, appendProtoVarint(appendProtoDouble(nil, 1, v), 2, uint64(1000+i)))
+		}
+		req = appendProtoBytes(req, 1, ts)
+	}
+	for family, t := range metadata {
+		req = appendProtoBytes(req, 3, appendProtoBytes(appendProtoVarint(nil, 1, uint64(t)), 2, []byte(family)))
+	}
+
+	body := binary.AppendUvarint(nil, uint64(len(req)))
+	n := len(req) - 1
+	body = append(body, 62<<2, byte(n), byte(n>>8), byte(n>>16))
+	return append(body, req...)
+}
+
+func decodeAndCommit(t *testing.T, rw *RemoteWrite, series map[string][]float64, metadata map[string]int) map[string]entity.Metric {
+	t.Helper()
+	batch, err := rw.Decode(writeRequest(series, metadata))
+	require.NoError(t, err)
+	rw.Commit(batch, batch.Metrics)
+
+	metrics := make(map[string]entity.Metric)
+	for _, m := range batch.Metrics {
+		metrics[m.ID] = m
+	}
+	return metrics
+}
+
+func TestRemoteWriteEviction(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	rw := NewRemoteWrite()
+	rw.now, rw.counters.now = clock, clock
+
+	metrics := decodeAndCommit(t, rw, map[string][]float64{"jobs": {5}}, map[string]int{"jobs": promCounter})
+	assert.Equal(t, int64(0), *metrics["jobs"].Delta, "the first value of a counter is only remembered")
+	metrics = decodeAndCommit(t, rw, map[string][]float64{"jobs": {8}}, nil)
+	assert.Equal(t, int64(3), *metrics["jobs"].Delta)
+
+	// Пока ряд приходит, ни тип семейства, ни накопленное значение не забываются.
+	for i := 0; i < 3; i++ {
+		now = now.Add(seriesIdle / 2)
+		metrics = decodeAndCommit(t, rw, map[string][]float64{"jobs": {float64(10 + i)}}, nil)
+		require.Equal(t, entity.Counter, metrics["jobs"].MType)
+	}
+
+	now = now.Add(seriesIdle + time.Minute)
+	decodeAndCommit(t, rw, map[string][]float64{"other": {1}}, nil)
+	assert.Empty(t, rw.families)
+	assert.NotContains(t, rw.counters.totals, "jobs")
+
+	metrics = decodeAndCommit(t, rw, map[string][]float64{"jobs": {20}}, nil)
+	assert.Equal(t, entity.Gauge, metrics["jobs"].MType, "the forgotten family falls back to the name")
+}
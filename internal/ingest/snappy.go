@@ -0,0 +1,88 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// maxDecodedSize ограничивает размер распакованного тела, чтобы заявленная
+// в заголовке длина не приводила к огромной аллокации.
+const maxDecodedSize = 32 << 20
+
+var errCorruptSnappy = errors.New("snappy: corrupt input")
+
+// snappyDecode распаковывает блочный формат snappy (без фрейминга),
+// в котором Prometheus отправляет remote_write.
+func snappyDecode(src []byte) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errCorruptSnappy
+	}
+	if size > maxDecodedSize {
+		return nil, fmt.Errorf("snappy: decoded size %d exceeds limit", size)
+	}
+	src = src[n:]
+	dst := make([]byte, 0, size)
+
+	for len(src) > 0 {
+		tag := src[0]
+		var length, offset int
+		switch tag & 3 {
+		case 0:
+			length = int(tag >> 2)
+			src = src[1:]
+			if length >= 60 {
+				extra := length - 59
+				if len(src) < extra {
+					return nil, errCorruptSnappy
+				}
+				length = 0
+				for i := extra - 1; i >= 0; i-- {
+					length = length<<8 | int(src[i])
+				}
+				src = src[extra:]
+			}
+			length++
+			if length <= 0 || length > len(src) || len(dst)+length > int(size) {
+				return nil, errCorruptSnappy
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case 1:
+			if len(src) < 2 {
+				return nil, errCorruptSnappy
+			}
+			length = 4 + int(tag>>2)&7
+			offset = int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+		case 2:
+			if len(src) < 3 {
+				return nil, errCorruptSnappy
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:3]))
+			src = src[3:]
+		case 3:
+			if len(src) < 5 {
+				return nil, errCorruptSnappy
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:5]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) || len(dst)+length > int(size) {
+			return nil, errCorruptSnappy
+		}
+		// Источник копии может перекрываться с результатом, поэтому копируем побайтово.
+		start := len(dst) - offset
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+	if len(dst) != int(size) {
+		return nil, errCorruptSnappy
+	}
+	return dst, nil
+}
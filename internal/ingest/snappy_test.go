@@ -0,0 +1,108 @@
+package ingest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnappyDecode(t *testing.T) {
+	long := bytes.Repeat([]byte("0123456789"), 30)
+
+	tests := []struct {
+		name    string
+		src     []byte
+		want    []byte
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			src:  []byte{0x00},
+			want: []byte{},
+		},
+		{
+			name: "short literal",
+			src:  append([]byte{0x05, 0x10}, "hello"...),
+			want: []byte("hello"),
+		},
+		{
+			name: "literal with one length byte",
+			src:  append([]byte{0x64, 60 << 2, 99}, bytes.Repeat([]byte{'x'}, 100)...),
+			want: bytes.Repeat([]byte{'x'}, 100),
+		},
+		{
+			name: "copy with 1-byte offset",
+			src:  append(append([]byte{0x0c, 0x0c}, "abcd"...), 0x11, 0x04),
+			want: []byte("abcdabcdabcd"),
+		},
+		{
+			name: "copy with 1-byte offset above 255",
+			src:  append(append([]byte{0xb0, 0x02, 61 << 2, 0x2b, 0x01}, long...), 0x21, 0x2c),
+			want: append(append([]byte{}, long...), "0123"...),
+		},
+		{
+			name: "overlapping copy with 2-byte offset",
+			src:  append(append([]byte{0x09, 0x08}, "xyz"...), 0x16, 0x03, 0x00),
+			want: []byte("xyzxyzxyz"),
+		},
+		{
+			name: "copy with 4-byte offset",
+			src:  append(append([]byte{0x06, 0x04}, "ab"...), 0x0f, 0x02, 0x00, 0x00, 0x00),
+			want: []byte("ababab"),
+		},
+		{
+			name:    "missing length",
+			src:     []byte{},
+			wantErr: true,
+		},
+		{
+			name:    "literal past the input",
+			src:     append([]byte{0x05, 0x10}, "hel"...),
+			wantErr: true,
+		},
+		{
+			name:    "copy before any output",
+			src:     []byte{0x04, 0x01, 0x01},
+			wantErr: true,
+		},
+		{
+			name:    "offset past the output",
+			src:     append(append([]byte{0x08, 0x0c}, "abcd"...), 0x01, 0x05),
+			wantErr: true,
+		},
+		{
+			name:    "output longer than declared",
+			src:     append(append([]byte{0x06, 0x0c}, "abcd"...), 0x01, 0x04),
+			wantErr: true,
+		},
+		{
+			name:    "output shorter than declared",
+			src:     append([]byte{0x06, 0x0c}, "abcd"...),
+			wantErr: true,
+		},
+		{
+			name:    "declared size over the limit",
+			src:     []byte{0x80, 0x80, 0x80, 0x80, 0x7f},
+			wantErr: true,
+		},
+		{
+			name:    "truncated 4-byte offset",
+			src:     append(append([]byte{0x06, 0x04}, "ab"...), 0x0f, 0x02),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := snappyDecode(tt.src)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
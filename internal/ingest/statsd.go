@@ -0,0 +1,370 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+const (
+	// StatsdMalformedID — счётчик строк StatsD, которые не удалось разобрать.
+	StatsdMalformedID = "StatsdMalformedLines"
+
+	statsdMaxDatagram = 64 << 10
+	// statsdGaugeIdleWindows — через столько окон без обновлений гейдж забывается,
+	// и следующее относительное изменение отсчитывается от нуля.
+	statsdGaugeIdleWindows = 360
+)
+
+// DefaultStatsdPercentiles — перцентили таймеров по умолчанию.
+var DefaultStatsdPercentiles = []float64{90, 99}
+
+// Statsd принимает StatsD по UDP (name:value|type[|@rate][|#tag:v,...]) и копит
+// значения в окне flush. По окончании окна всё накопленное пишется одним
+// вызовом AddMetrics:
+//   - c — счётчик с суммой приращений, поделённых на частоту выборки;
+//   - g — гейдж с последним значением, +n и -n меняют предыдущее значение;
+//   - ms и h — таймер: счётчик name.count и гейджи name.mean, name.upper
+//     и name.upper_<p> для каждого перцентиля p;
+//   - s — set с уникальными значениями окна.
+type Statsd struct {
+	addr        string
+	flush       time.Duration
+	percentiles []float64
+	writer      Writer
+	logger      *zap.Logger
+
+	mu        sync.Mutex
+	counters  map[string]float64
+	gauges    map[string]*statsdGauge
+	touched   map[string]bool
+	timers    map[string]*statsdTimer
+	sets      map[string]map[string]struct{}
+	malformed int64
+}
+
+// statsdGauge — последнее значение гейджа и число окон подряд без обновлений.
+type statsdGauge struct {
+	value float64
+	idle  int
+}
+
+type statsdTimer struct {
+	name   string
+	tags   map[string]string
+	values []float64
+	count  float64
+}
+
+func NewStatsd(logger *zap.Logger, writer Writer, addr string, flush time.Duration, percentiles []float64) (*Statsd, error) {
+	if flush <= 0 {
+		return nil, errors.New("statsd flush interval must be positive")
+	}
+	for _, p := range percentiles {
+		if p <= 0 || p > 100 {
+			return nil, fmt.Errorf("statsd percentile %g must be in (0, 100]", p)
+		}
+	}
+	return &Statsd{
+		addr:        addr,
+		flush:       flush,
+		percentiles: percentiles,
+		writer:      writer,
+		logger:      logger,
+		counters:    make(map[string]float64),
+		gauges:      make(map[string]*statsdGauge),
+		touched:     make(map[string]bool),
+		timers:      make(map[string]*statsdTimer),
+		sets:        make(map[string]map[string]struct{}),
+	}, nil
+}
+
+// ParseStatsdPercentiles разбирает перцентили вида "90,99.9". Пустая строка — значения по умолчанию.
+func ParseStatsdPercentiles(s string) ([]float64, error) {
+	if strings.TrimSpace(s) == "" {
+		return DefaultStatsdPercentiles, nil
+	}
+	var percentiles []float64
+	for _, part := range strings.Split(s, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid statsd percentile %q", part)
+		}
+		percentiles = append(percentiles, p)
+	}
+	return percentiles, nil
+}
+
+// Run слушает addr до отмены ctx, после чего пишет последнее окно.
+// Ошибка чтения, не вызванная остановкой, завершает Run с этой ошибкой.
+func (s *Statsd) Run(ctx context.Context) error {
+	pc, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return fmt.Errorf("statsd udp listen: %w", err)
+	}
+	s.logger.Info("Starting statsd listener", zap.String("addr", s.addr), zap.Duration("flush", s.flush))
+	return s.serve(ctx, pc)
+}
+
+func (s *Statsd) serve(ctx context.Context, pc net.PacketConn) error {
+	done := make(chan error, 1)
+	go func() {
+		buf := make([]byte, statsdMaxDatagram)
+		for {
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				done <- err
+				return
+			}
+			for _, line := range strings.Split(string(buf[:n]), "\n") {
+				s.handleLine(line)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(s.flush)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			pc.Close()
+			<-done
+			s.Flush()
+			return nil
+		case err := <-done:
+			pc.Close()
+			s.Flush()
+			return fmt.Errorf("statsd read: %w", err)
+		case <-ticker.C:
+			s.Flush()
+		}
+	}
+}
+
+func (s *Statsd) handleLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	if err := s.parseLine(line); err != nil {
+		s.logger.Debug("Statsd: malformed line", zap.String("line", line), zap.Error(err))
+		s.mu.Lock()
+		s.malformed++
+		s.mu.Unlock()
+	}
+}
+
+func (s *Statsd) parseLine(line string) error {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return errors.New("expected name:value|type")
+	}
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return errors.New("missing type")
+	}
+	raw, kind := parts[0], parts[1]
+
+	rate := 1.0
+	tags := make(map[string]string)
+	for _, option := range parts[2:] {
+		switch {
+		case strings.HasPrefix(option, "@"):
+			r, err := strconv.ParseFloat(option[1:], 64)
+			if err != nil || r <= 0 || r > 1 {
+				return fmt.Errorf("invalid sample rate %q", option)
+			}
+			rate = r
+		case strings.HasPrefix(option, "#"):
+			for _, tag := range strings.Split(option[1:], ",") {
+				k, v, _ := strings.Cut(tag, ":")
+				if k == "" {
+					return fmt.Errorf("empty tag key in %q", option)
+				}
+				tags[k] = v
+			}
+		default:
+			return fmt.Errorf("unknown option %q", option)
+		}
+	}
+	id := func(suffix string) string {
+		return entity.TaggedID(name+suffix, tags)
+	}
+
+	if kind == "s" {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		key := id("")
+		if s.sets[key] == nil {
+			s.sets[key] = make(map[string]struct{})
+		}
+		s.sets[key][raw] = struct{}{}
+		return nil
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("invalid value %q", raw)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch kind {
+	case "c":
+		s.counters[id("")] += value / rate
+	case "g":
+		key := id("")
+		g, ok := s.gauges[key]
+		if !ok {
+			g = &statsdGauge{}
+			s.gauges[key] = g
+		}
+		if strings.HasPrefix(raw, "+") || strings.HasPrefix(raw, "-") {
+			value += g.value
+		}
+		g.value, g.idle = value, 0
+		s.touched[key] = true
+	case "ms", "h":
+		key := id("")
+		t, ok := s.timers[key]
+		if !ok {
+			t = &statsdTimer{name: name, tags: tags}
+			s.timers[key] = t
+		}
+		t.values = append(t.values, value)
+		t.count += 1 / rate
+	default:
+		return fmt.Errorf("unknown type %q", kind)
+	}
+	return nil
+}
+
+// Flush пишет накопленное за окно одним вызовом AddMetrics и начинает новое окно.
+// Последние значения гейджей сохраняются для относительных изменений, пока гейдж
+// обновляется хотя бы раз в statsdGaugeIdleWindows окон. Если запись
+// не удалась, окно возвращается и будет записано вместе со следующим; пакет,
+// который хранилище отклонило как некорректный, отбрасывается.
+func (s *Statsd) Flush() {
+	s.mu.Lock()
+	counters, timers, sets, touched, malformed := s.counters, s.timers, s.sets, s.touched, s.malformed
+	s.counters = make(map[string]float64)
+	s.timers = make(map[string]*statsdTimer)
+	s.sets = make(map[string]map[string]struct{})
+	s.touched = make(map[string]bool)
+	s.malformed = 0
+	var metrics []entity.Metric
+	for key := range touched {
+		value := s.gauges[key].value
+		metrics = append(metrics, entity.Metric{ID: key, MType: entity.Gauge, Value: &value})
+	}
+	for key, g := range s.gauges {
+		if touched[key] {
+			continue
+		}
+		if g.idle++; g.idle >= statsdGaugeIdleWindows {
+			delete(s.gauges, key)
+		}
+	}
+	s.mu.Unlock()
+
+	for key, sum := range counters {
+		delta := int64(math.Round(sum))
+		metrics = append(metrics, entity.Metric{ID: key, MType: entity.Counter, Delta: &delta})
+	}
+	for _, t := range timers {
+		metrics = append(metrics, s.timerMetrics(t)...)
+	}
+	for key, elements := range sets {
+		m := entity.Metric{ID: key, MType: entity.Set}
+		for element := range elements {
+			m.Elements = append(m.Elements, element)
+		}
+		metrics = append(metrics, m)
+	}
+	if malformed > 0 {
+		metrics = append(metrics, entity.Metric{ID: StatsdMalformedID, MType: entity.Counter, Delta: &malformed})
+	}
+	if len(metrics) == 0 {
+		return
+	}
+
+	err := s.writer.AddMetrics(metrics)
+	if err == nil {
+		return
+	}
+	s.logger.Error("Statsd: add metrics error", zap.Int("count", len(metrics)), zap.Error(err))
+	if errors.Is(err, entity.ErrInvalidMetric) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, sum := range counters {
+		s.counters[key] += sum
+	}
+	for key, t := range timers {
+		if current, ok := s.timers[key]; ok {
+			t.values = append(t.values, current.values...)
+			t.count += current.count
+		}
+		s.timers[key] = t
+	}
+	for key, elements := range sets {
+		if s.sets[key] == nil {
+			s.sets[key] = elements
+			continue
+		}
+		for element := range elements {
+			s.sets[key][element] = struct{}{}
+		}
+	}
+	for key := range touched {
+		s.touched[key] = true
+	}
+	s.malformed += malformed
+}
+
+// timerMetrics считает статистику таймера. Перцентиль — верхняя граница
+// ближайшего ранга, как в StatsD: upper_90 — наибольшее из 90% меньших значений.
+func (s *Statsd) timerMetrics(t *statsdTimer) []entity.Metric {
+	id := func(suffix string) string {
+		return entity.TaggedID(t.name+suffix, t.tags)
+	}
+
+	values := t.values
+	sort.Float64s(values)
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	count := int64(math.Round(t.count))
+	mean := sum / float64(len(values))
+	upper := values[len(values)-1]
+
+	metrics := []entity.Metric{
+		{ID: id(".count"), MType: entity.Counter, Delta: &count},
+		{ID: id(".mean"), MType: entity.Gauge, Value: &mean},
+		{ID: id(".upper"), MType: entity.Gauge, Value: &upper},
+	}
+	for _, p := range s.percentiles {
+		rank := int(math.Round(p / 100 * float64(len(values))))
+		if rank < 1 {
+			rank = 1
+		}
+		value := values[rank-1]
+		suffix := ".upper_" + strings.ReplaceAll(strconv.FormatFloat(p, 'f', -1, 64), ".", "_")
+		metrics = append(metrics, entity.Metric{ID: id(suffix), MType: entity.Gauge, Value: &value})
+	}
+	return metrics
+}
@@ -0,0 +1,182 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+// lastMetric возвращает последнее вхождение метрики во всех пакетах.
+func (w *failingWriter) lastMetric(id, mType string) (entity.Metric, bool) {
+	var found entity.Metric
+	var ok bool
+	for _, batch := range w.batches {
+		for _, m := range batch {
+			if m.ID == id && m.MType == mType {
+				found, ok = m, true
+			}
+		}
+	}
+	return found, ok
+}
+
+func (w *failingWriter) gauge(t *testing.T, id string) float64 {
+	m, ok := w.lastMetric(id, entity.Gauge)
+	require.True(t, ok, id)
+	return *m.Value
+}
+
+// brokenConn отдаёт ошибку на первом же чтении.
+type brokenConn struct {
+	net.PacketConn
+	err error
+}
+
+func (c brokenConn) ReadFrom([]byte) (int, net.Addr, error) { return 0, nil, c.err }
+func (c brokenConn) Close() error                           { return nil }
+
+func TestStatsdListener(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	writer := &failingWriter{}
+	s, err := NewStatsd(zap.NewNop(), writer, pc.LocalAddr().String(), time.Hour, []float64{50, 90})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.serve(ctx, pc) }()
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("api.requests:1|c\napi.requests:3|c|@0.5\nqueue.depth:10|g\nqueue.depth:-4|g\n" +
+		"db.query:10|ms\ndb.query:30|ms\ndb.query:20|ms\ndb.query:40|ms|#shard:a\n" +
+		"users:alice|s\nusers:bob|s\nusers:alice|s\nbroken line\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	// Битая строка последняя в датаграмме: когда она учтена, разобрано всё.
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.malformed == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+	require.Len(t, writer.batches, 1)
+
+	assert.Equal(t, int64(7), writer.counter("api.requests"))
+	assert.Equal(t, 6.0, writer.gauge(t, "queue.depth"))
+	assert.Equal(t, int64(3), writer.counter("db.query.count"))
+	assert.Equal(t, 20.0, writer.gauge(t, "db.query.mean"))
+	assert.Equal(t, 30.0, writer.gauge(t, "db.query.upper"))
+	assert.Equal(t, 20.0, writer.gauge(t, "db.query.upper_50"))
+	assert.Equal(t, 30.0, writer.gauge(t, "db.query.upper_90"))
+	assert.Equal(t, int64(1), writer.counter("db.query.count;shard=a"))
+	users, ok := writer.lastMetric("users", entity.Set)
+	require.True(t, ok)
+	assert.ElementsMatch(t, []string{"alice", "bob"}, users.Elements)
+	assert.Equal(t, int64(1), writer.counter(StatsdMalformedID))
+}
+
+func TestStatsdReadError(t *testing.T) {
+	writer := &failingWriter{}
+	s, err := NewStatsd(zap.NewNop(), writer, "", time.Hour, nil)
+	require.NoError(t, err)
+	s.handleLine("hits:2|c")
+
+	readErr := errors.New("read failed")
+	err = s.serve(context.Background(), brokenConn{err: readErr})
+	require.ErrorIs(t, err, readErr)
+	// Накопленное окно всё равно записано.
+	assert.Equal(t, int64(2), writer.counter("hits"))
+}
+
+func TestStatsdFlush(t *testing.T) {
+	t.Run("failed window is kept", func(t *testing.T) {
+		writer := &failingWriter{err: errors.New("storage unavailable")}
+		s, err := NewStatsd(zap.NewNop(), writer, "", time.Hour, nil)
+		require.NoError(t, err)
+
+		for _, line := range []string{"hits:2|c", "load:5|g", "lat:10|ms", "lat:30|ms", "users:alice|s", "broken"} {
+			s.handleLine(line)
+		}
+		s.Flush()
+		require.Empty(t, writer.batches)
+
+		for _, line := range []string{"hits:3|c", "lat:20|ms", "users:bob|s", "broken"} {
+			s.handleLine(line)
+		}
+		writer.err = nil
+		s.Flush()
+		require.Len(t, writer.batches, 1)
+
+		assert.Equal(t, int64(5), writer.counter("hits"))
+		assert.Equal(t, 5.0, writer.gauge(t, "load"))
+		assert.Equal(t, int64(3), writer.counter("lat.count"))
+		assert.Equal(t, 20.0, writer.gauge(t, "lat.mean"))
+		assert.Equal(t, 30.0, writer.gauge(t, "lat.upper"))
+		users, ok := writer.lastMetric("users", entity.Set)
+		require.True(t, ok)
+		assert.ElementsMatch(t, []string{"alice", "bob"}, users.Elements)
+		assert.Equal(t, int64(2), writer.counter(StatsdMalformedID))
+	})
+
+	t.Run("rejected window is dropped", func(t *testing.T) {
+		writer := &failingWriter{err: entity.ErrInvalidMetric}
+		s, err := NewStatsd(zap.NewNop(), writer, "", time.Hour, nil)
+		require.NoError(t, err)
+
+		s.handleLine("hits:2|c")
+		s.Flush()
+		writer.err = nil
+		s.Flush()
+		assert.Empty(t, writer.batches)
+	})
+}
+
+func TestStatsdGaugeEviction(t *testing.T) {
+	writer := &failingWriter{}
+	s, err := NewStatsd(zap.NewNop(), writer, "", time.Hour, nil)
+	require.NoError(t, err)
+
+	s.handleLine("queue:10|g")
+	s.Flush()
+	for i := 0; i < statsdGaugeIdleWindows-1; i++ {
+		s.Flush()
+	}
+	s.handleLine("queue:+1|g")
+	s.Flush()
+	assert.Equal(t, 11.0, writer.gauge(t, "queue"), "gauge updated within the idle limit keeps its value")
+
+	for i := 0; i < statsdGaugeIdleWindows; i++ {
+		s.Flush()
+	}
+	s.mu.Lock()
+	assert.Empty(t, s.gauges)
+	s.mu.Unlock()
+	s.handleLine("queue:+1|g")
+	s.Flush()
+	assert.Equal(t, 1.0, writer.gauge(t, "queue"), "evicted gauge starts from zero")
+}
+
+func TestStatsdEmptyTagKey(t *testing.T) {
+	writer := &failingWriter{}
+	s, err := NewStatsd(zap.NewNop(), writer, "", time.Hour, nil)
+	require.NoError(t, err)
+
+	s.handleLine("hits:1|c|#:web")
+	s.handleLine("hits:1|c|#host:a,:b")
+	s.handleLine("hits:1|c|#host:a")
+	s.Flush()
+	assert.Equal(t, int64(2), writer.counter(StatsdMalformedID))
+	assert.Equal(t, int64(1), writer.counter("hits;host=a"))
+}
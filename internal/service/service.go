@@ -1,7 +1,9 @@
 package service
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/WPGe/go-yandex-advanced/internal/entity"
@@ -12,14 +14,69 @@ type Repository interface {
 	AddMetrics(metric []entity.Metric) error
 	GetMetric(id, metricType string) (*entity.Metric, error)
 	GetAllMetrics() (entity.MetricsStore, error)
+	EachMetric(fn func(entity.Metric) error) error
 }
 
+// History хранит принятые обновления гейджей и счётчиков с временем приёма
+// и удаляет устаревшие.
+type History interface {
+	Record(samples []entity.Sample)
+	Samples(id, mType string, from, to time.Time) ([]entity.Sample, error)
+	Compact(now time.Time) error
+}
+
+// Rollups хранит агрегаты обновлений по уровням разрешения и удаляет
+// корзины старше срока хранения уровня.
+type Rollups interface {
+	Tiers() []entity.RollupTier
+	Record(samples []entity.Sample)
+	Rollups(id, mType string, tier entity.RollupTier, from, to time.Time) ([]entity.Rollup, error)
+	Compact(now time.Time) error
+}
+
+// maxRollupPoints — сколько корзин допустимо отдать, прежде чем перейти на уровень крупнее.
+const maxRollupPoints = 1000
+
 type Service struct {
-	repo Repository
+	repo    Repository
+	bounds  []float64
+	history History
+	rollups Rollups
+}
+
+type Option func(s *Service)
+
+// WithHistogramBounds задаёт границы корзин для гистограмм, которые
+// приходят одиночными наблюдениями.
+func WithHistogramBounds(bounds []float64) Option {
+	return func(s *Service) {
+		s.bounds = bounds
+	}
+}
+
+// WithHistory включает запись истории обновлений.
+func WithHistory(history History) Option {
+	return func(s *Service) {
+		s.history = history
+	}
 }
 
-func New(repo Repository) *Service {
-	return &Service{repo: repo}
+// WithRollups включает запись агрегатов по уровням разрешения.
+func WithRollups(rollups Rollups) Option {
+	return func(s *Service) {
+		s.rollups = rollups
+	}
+}
+
+func New(repo Repository, opts ...Option) *Service {
+	s := &Service{
+		repo:   repo,
+		bounds: entity.DefaultHistogramBounds,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *Service) GetMetric(id, mType string) (*entity.Metric, error) {
@@ -38,6 +95,15 @@ func (s *Service) GetMetric(id, mType string) (*entity.Metric, error) {
 	return m, nil
 }
 
+// EachMetric обходит метрики по порядку ID и типа. Без повторов: fn мог уже
+// получить часть метрик.
+func (s *Service) EachMetric(fn func(entity.Metric) error) error {
+	if err := s.repo.EachMetric(fn); err != nil {
+		return fmt.Errorf("failed to iterate metrics: %w", err)
+	}
+	return nil
+}
+
 func (s *Service) GetAllMetrics() (entity.MetricsStore, error) {
 	var m entity.MetricsStore
 	var err error
@@ -55,7 +121,13 @@ func (s *Service) GetAllMetrics() (entity.MetricsStore, error) {
 }
 
 func (s *Service) AddMetric(m entity.Metric) error {
-	err := s.Retry(3, func() error {
+	m, err := s.prepare(m)
+	if err != nil {
+		return err
+	}
+	samples := s.samples([]entity.Metric{m})
+
+	err = s.Retry(3, func() error {
 		if err := s.repo.AddMetric(m); err != nil {
 			return err
 		}
@@ -64,10 +136,21 @@ func (s *Service) AddMetric(m entity.Metric) error {
 	if err != nil {
 		return fmt.Errorf("failed to add metric: %w", err)
 	}
+	s.record(samples)
 	return nil
 }
 
-func (s *Service) AddMetrics(m []entity.Metric) error {
+func (s *Service) AddMetrics(metrics []entity.Metric) error {
+	m := make([]entity.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		metric, err := s.prepare(metric)
+		if err != nil {
+			return err
+		}
+		m = append(m, metric)
+	}
+	samples := s.samples(m)
+
 	err := s.Retry(3, func() error {
 		if err := s.repo.AddMetrics(m); err != nil {
 			return err
@@ -77,19 +160,212 @@ func (s *Service) AddMetrics(m []entity.Metric) error {
 	if err != nil {
 		return fmt.Errorf("failed to add metrics: %w", err)
 	}
+	s.record(samples)
 	return nil
 }
 
+// History отдаёт сэмплы метрики за [from, to]. С ненулевым step сэмплы
+// сворачиваются в интервалы от from: гейджи усредняются, приращения счётчиков суммируются.
+func (s *Service) History(id, mType string, from, to time.Time, step time.Duration) ([]entity.Sample, error) {
+	if s.history == nil {
+		return nil, nil
+	}
+	samples, err := s.history.Samples(id, mType, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history of %s: %w", id, err)
+	}
+	if step <= 0 {
+		return samples, nil
+	}
+	return downsample(samples, from, step), nil
+}
+
+// Rollups отдаёт агрегаты метрики за [from, to] и разрешение, на котором они посчитаны.
+// Без resolution берётся самый мелкий уровень, который ещё хранит from и даёт
+// не больше maxRollupPoints корзин, иначе — самый крупный.
+func (s *Service) Rollups(id, mType string, from, to time.Time, resolution time.Duration) ([]entity.Rollup, time.Duration, error) {
+	if s.rollups == nil || len(s.rollups.Tiers()) == 0 {
+		return nil, 0, nil
+	}
+	tier, err := selectTier(s.rollups.Tiers(), from, to, resolution, time.Now())
+	if err != nil {
+		return nil, 0, err
+	}
+	rollups, err := s.rollups.Rollups(id, mType, tier, from, to)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get rollups of %s: %w", id, err)
+	}
+	return rollups, tier.Resolution, nil
+}
+
+// CompactHistory удаляет устаревшую историю.
+func (s *Service) CompactHistory(now time.Time) error {
+	if s.history == nil {
+		return nil
+	}
+	return s.history.Compact(now)
+}
+
+// CompactRollups удаляет устаревшие корзины.
+func (s *Service) CompactRollups(now time.Time) error {
+	if s.rollups == nil {
+		return nil
+	}
+	return s.rollups.Compact(now)
+}
+
+func selectTier(tiers []entity.RollupTier, from, to time.Time, resolution time.Duration, now time.Time) (entity.RollupTier, error) {
+	if resolution > 0 {
+		for _, tier := range tiers {
+			if tier.Resolution == resolution {
+				return tier, nil
+			}
+		}
+		return entity.RollupTier{}, fmt.Errorf("%w: %s", entity.ErrUnknownTier, resolution)
+	}
+	for _, tier := range tiers {
+		if from.Before(now.Add(-tier.Retention)) {
+			continue
+		}
+		if to.Sub(from)/tier.Resolution <= maxRollupPoints {
+			return tier, nil
+		}
+	}
+	return tiers[len(tiers)-1], nil
+}
+
+func (s *Service) record(samples []entity.Sample) {
+	if s.history != nil {
+		s.history.Record(samples)
+	}
+	if s.rollups != nil {
+		s.rollups.Record(samples)
+	}
+}
+
+// samples снимает значения до записи: хранилище меняет Delta по указателю.
+func (s *Service) samples(metrics []entity.Metric) []entity.Sample {
+	if s.history == nil && s.rollups == nil {
+		return nil
+	}
+
+	now := time.Now()
+	samples := make([]entity.Sample, 0, len(metrics))
+	for _, m := range metrics {
+		if m.MType != entity.Gauge && m.MType != entity.Counter {
+			continue
+		}
+		sample := entity.Sample{ID: m.ID, MType: m.MType, Timestamp: now}
+		if m.Delta != nil {
+			delta := *m.Delta
+			sample.Delta = &delta
+		}
+		if m.Value != nil {
+			value := *m.Value
+			sample.Value = &value
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+func downsample(samples []entity.Sample, from time.Time, step time.Duration) []entity.Sample {
+	var result []entity.Sample
+	var sum float64
+	var n int
+	for _, sample := range samples {
+		bucket := from.Add(sample.Timestamp.Sub(from) / step * step)
+		if len(result) == 0 || !result[len(result)-1].Timestamp.Equal(bucket) {
+			result = append(result, entity.Sample{ID: sample.ID, MType: sample.MType, Timestamp: bucket})
+			sum, n = 0, 0
+		}
+
+		last := &result[len(result)-1]
+		if sample.Delta != nil {
+			if last.Delta == nil {
+				last.Delta = new(int64)
+			}
+			*last.Delta += *sample.Delta
+		}
+		if sample.Value != nil {
+			sum += *sample.Value
+			n++
+			avg := sum / float64(n)
+			last.Value = &avg
+		}
+	}
+	return result
+}
+
+// prepare проверяет метрику до записи. Гистограмма и summary могут прийти
+// одиночным наблюдением в Value, set — сырыми элементами или хешами, тогда
+// из них строится значение нужного типа. NaN и бесконечности отвергаются:
+// они ломают агрегаты и индексы корзин summary.
+func (s *Service) prepare(m entity.Metric) (entity.Metric, error) {
+	if m.Value != nil && (math.IsNaN(*m.Value) || math.IsInf(*m.Value, 0)) {
+		return m, fmt.Errorf("%w: %s has non-finite value %g", entity.ErrInvalidMetric, m.ID, *m.Value)
+	}
+	switch m.MType {
+	case entity.Histogram:
+		if m.Histogram == nil {
+			if m.Value == nil {
+				return m, fmt.Errorf("%w: histogram %s has neither histogram nor value", entity.ErrInvalidMetric, m.ID)
+			}
+			h := entity.NewHistogram(s.bounds)
+			h.Observe(*m.Value)
+			m.Histogram = h
+			m.Value = nil
+		}
+		if err := m.Histogram.Validate(); err != nil {
+			return m, fmt.Errorf("%w: histogram %s: %v", entity.ErrInvalidMetric, m.ID, err)
+		}
+	case entity.Summary:
+		if m.Summary == nil {
+			if m.Value == nil {
+				return m, fmt.Errorf("%w: summary %s has neither summary nor value", entity.ErrInvalidMetric, m.ID)
+			}
+			summary := entity.NewSummary(entity.DefaultSummaryAccuracy)
+			summary.Observe(*m.Value)
+			m.Summary = summary
+			m.Value = nil
+		}
+		if err := m.Summary.Validate(); err != nil {
+			return m, fmt.Errorf("%w: summary %s: %v", entity.ErrInvalidMetric, m.ID, err)
+		}
+	case entity.Set:
+		if m.Set == nil {
+			if len(m.Elements) == 0 && len(m.Hashes) == 0 {
+				return m, fmt.Errorf("%w: set %s has neither set nor elements", entity.ErrInvalidMetric, m.ID)
+			}
+			m.Set = entity.NewSet(entity.DefaultSetPrecision)
+		} else {
+			m.Set = m.Set.Clone()
+		}
+		if err := m.Set.Validate(); err != nil {
+			return m, fmt.Errorf("%w: set %s: %v", entity.ErrInvalidMetric, m.ID, err)
+		}
+		for _, element := range m.Elements {
+			m.Set.Add(element)
+		}
+		for _, hash := range m.Hashes {
+			m.Set.AddHash(hash)
+		}
+		m.Elements = nil
+		m.Hashes = nil
+	}
+	return m, nil
+}
+
 func (s *Service) Retry(maxRetries int, fn func() error, intervals ...time.Duration) error {
 	var err error
 	err = fn()
-	if err == nil {
-		return nil
+	if err == nil || errors.Is(err, entity.ErrInvalidMetric) {
+		return err
 	}
 	for i := 0; i < maxRetries; i++ {
 		time.Sleep(intervals[i])
-		if err = fn(); err == nil {
-			return nil
+		if err = fn(); err == nil || errors.Is(err, entity.ErrInvalidMetric) {
+			return err
 		}
 	}
 	return err
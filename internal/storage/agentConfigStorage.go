@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+const DefaultAgentProfile = "default"
+
+var ErrAgentConfigNotFound = errors.New("agent config not found")
+
+// AgentConfigStorage хранит профили конфигурации агентов. Если задан путь,
+// профили читаются из JSON-файла при старте и сохраняются в него при изменении.
+type AgentConfigStorage struct {
+	mu       sync.RWMutex
+	profiles map[string]entity.AgentProfile
+	applied  map[string]entity.AgentConfigStatus
+	path     string
+	logger   *zap.Logger
+}
+
+func NewAgentConfigStorage(path string, logger *zap.Logger) (*AgentConfigStorage, error) {
+	s := &AgentConfigStorage{
+		profiles: make(map[string]entity.AgentProfile),
+		applied:  make(map[string]entity.AgentConfigStatus),
+		path:     path,
+		logger:   logger,
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) || (err == nil && len(data) == 0) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent profiles: %w", err)
+	}
+
+	var profiles []entity.AgentProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to decode agent profiles: %w", err)
+	}
+	for _, profile := range profiles {
+		if profile.Name == "" {
+			return nil, errors.New("agent profile without name")
+		}
+		profile.Config.Version = configVersion(profile.Config)
+		s.profiles[profile.Name] = profile
+	}
+
+	return s, nil
+}
+
+// AgentConfig подбирает профиль: сначала по идентичности, затем по группе, затем default.
+func (s *AgentConfigStorage) AgentConfig(instance, group string) (*entity.AgentConfig, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var byGroup, byDefault *entity.AgentProfile
+	for _, name := range s.sortedNames() {
+		profile := s.profiles[name]
+		if instance != "" && contains(profile.Instances, instance) {
+			return &profile.Config, nil
+		}
+		if byGroup == nil && group != "" && contains(profile.Groups, group) {
+			byGroup = &profile
+		}
+		if name == DefaultAgentProfile {
+			byDefault = &profile
+		}
+	}
+
+	if byGroup != nil {
+		return &byGroup.Config, nil
+	}
+	if byDefault != nil {
+		return &byDefault.Config, nil
+	}
+	return nil, ErrAgentConfigNotFound
+}
+
+func (s *AgentConfigStorage) Profiles() ([]entity.AgentProfile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	profiles := make([]entity.AgentProfile, 0, len(s.profiles))
+	for _, name := range s.sortedNames() {
+		profiles = append(profiles, s.profiles[name])
+	}
+	return profiles, nil
+}
+
+func (s *AgentConfigStorage) SaveProfile(profile entity.AgentProfile) (*entity.AgentProfile, error) {
+	if profile.Name == "" {
+		return nil, fmt.Errorf("%w: no name", entity.ErrInvalidAgentProfile)
+	}
+	if err := profile.Config.Validate(); err != nil {
+		return nil, fmt.Errorf("%w %s: %v", entity.ErrInvalidAgentProfile, profile.Name, err)
+	}
+	profile.Config.Version = configVersion(profile.Config)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Профили в памяти меняются только после записи файла, иначе при ошибке
+	// сервер раздавал бы профиль, который потеряется при перезапуске.
+	profiles := make(map[string]entity.AgentProfile, len(s.profiles)+1)
+	for name, p := range s.profiles {
+		profiles[name] = p
+	}
+	profiles[profile.Name] = profile
+	if err := s.persist(profiles); err != nil {
+		return nil, err
+	}
+	s.profiles = profiles
+	return &profile, nil
+}
+
+func (s *AgentConfigStorage) ReportApplied(instance, version string) error {
+	if instance == "" {
+		return errors.New("instance is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.applied[instance] = entity.AgentConfigStatus{
+		Instance:  instance,
+		Version:   version,
+		AppliedAt: time.Now(),
+	}
+	return nil
+}
+
+func (s *AgentConfigStorage) AppliedConfigs() ([]entity.AgentConfigStatus, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	statuses := make([]entity.AgentConfigStatus, 0, len(s.applied))
+	for _, status := range s.applied {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Instance < statuses[j].Instance
+	})
+	return statuses, nil
+}
+
+func (s *AgentConfigStorage) persist(byName map[string]entity.AgentProfile) error {
+	if s.path == "" {
+		return nil
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	profiles := make([]entity.AgentProfile, 0, len(byName))
+	for _, name := range names {
+		profiles = append(profiles, byName[name])
+	}
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode agent profiles: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write agent profiles: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write agent profiles: %w", err)
+	}
+	return nil
+}
+
+func (s *AgentConfigStorage) sortedNames() []string {
+	names := make([]string, 0, len(s.profiles))
+	for name := range s.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// configVersion — хеш содержимого конфигурации, сервер отдаёт его как ETag.
+func configVersion(cfg entity.AgentConfig) string {
+	cfg.Version = ""
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
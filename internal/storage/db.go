@@ -2,6 +2,8 @@ package storage
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"sync"
 
 	"github.com/pkg/errors"
@@ -26,6 +28,9 @@ func NewDBStorage(logger *zap.Logger, db *sql.DB) *DBStorage {
 }
 
 func add(tx *sql.Tx, logger *zap.Logger, metric entity.Metric) error {
+	if entity.IsSketch(metric.MType) {
+		return addSketch(tx, logger, metric)
+	}
 
 	var mID, mType string
 	var mDelta sql.NullInt64
@@ -78,6 +83,63 @@ func add(tx *sql.Tx, logger *zap.Logger, metric entity.Metric) error {
 	return nil
 }
 
+// addSketch сливает значение с сохранённым, строка блокируется до конца транзакции.
+// Гистограмма хранится в JSONB-колонке histogram, остальные скетчи — в бинарной sketch.
+func addSketch(tx *sql.Tx, logger *zap.Logger, metric entity.Metric) error {
+	var rawHistogram, rawSketch []byte
+	row := tx.QueryRow("SELECT histogram, sketch FROM metrics WHERE id = $1 AND type = $2 FOR UPDATE", metric.ID, metric.MType)
+	err := row.Scan(&rawHistogram, &rawSketch)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.Error("Add: scan sketch error", zap.Error(err))
+		return err
+	}
+	exists := err == nil
+
+	// Нечитаемое сохранённое значение нельзя молча заменить входящим: данные потеряются.
+	var existing *entity.Metric
+	if exists {
+		stored, err := buildMetric(metric.ID, metric.MType, sql.NullInt64{}, sql.NullFloat64{}, rawHistogram, rawSketch)
+		if err != nil {
+			logger.Error("Add: decode stored sketch error", zap.String("id", metric.ID), zap.Error(err))
+			return err
+		}
+		existing = &stored
+	}
+	merged, err := entity.MergeSketch(existing, metric)
+	if err != nil {
+		return err
+	}
+
+	var histogram, sketch []byte
+	if merged.Histogram != nil {
+		if histogram, err = json.Marshal(merged.Histogram); err != nil {
+			return err
+		}
+	}
+	if merged.Summary != nil {
+		if sketch, err = merged.Summary.MarshalBinary(); err != nil {
+			return err
+		}
+	}
+	if merged.Set != nil {
+		if sketch, err = merged.Set.MarshalBinary(); err != nil {
+			return err
+		}
+	}
+
+	if exists {
+		_, err = tx.Exec("UPDATE metrics SET histogram = $1, sketch = $2 WHERE id = $3 AND type = $4", histogram, sketch, metric.ID, metric.MType)
+	} else {
+		_, err = tx.Exec("INSERT INTO metrics (id, type, histogram, sketch) VALUES ($1, $2, $3, $4)", metric.ID, metric.MType, histogram, sketch)
+	}
+	if err != nil {
+		logger.Error("add: error to add sketch", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
 func (storage *DBStorage) AddMetric(metric entity.Metric) error {
 	tx, err := storage.db.Begin()
 	if err != nil {
@@ -122,24 +184,25 @@ func (storage *DBStorage) GetMetric(id, metricType string) (*entity.Metric, erro
 	var mID, mType string
 	var mDelta sql.NullInt64
 	var mValue sql.NullFloat64
+	var mHistogram, mSketch []byte
 
-	row := storage.db.QueryRow("SELECT id, type, delta, value FROM metrics WHERE id = $1 AND type = $2", id, metricType)
-	err := row.Scan(&mID, &mType, &mDelta, &mValue)
+	row := storage.db.QueryRow("SELECT id, type, delta, value, histogram, sketch FROM metrics WHERE id = $1 AND type = $2", id, metricType)
+	err := row.Scan(&mID, &mType, &mDelta, &mValue, &mHistogram, &mSketch)
 	if err != nil {
 		storage.logger.Error("Get: scan row error", zap.Error(err))
 		return nil, err
 	}
 
-	return &entity.Metric{
-		ID:    mID,
-		MType: mType,
-		Delta: parseDelta(mDelta),
-		Value: parseValue(mValue),
-	}, nil
+	metric, err := buildMetric(mID, mType, mDelta, mValue, mHistogram, mSketch)
+	if err != nil {
+		storage.logger.Error("Get: decode error", zap.Error(err))
+		return nil, err
+	}
+	return &metric, nil
 }
 
 func (storage *DBStorage) GetAllMetrics() (entity.MetricsStore, error) {
-	rows, err := storage.db.Query("SELECT id, type, delta, value FROM metrics")
+	rows, err := storage.db.Query("SELECT id, type, delta, value, histogram, sketch FROM metrics")
 	if err != nil {
 		storage.logger.Error("GetAll: select error", zap.Error(err))
 		return nil, err
@@ -156,30 +219,22 @@ func (storage *DBStorage) GetAllMetrics() (entity.MetricsStore, error) {
 		var mID, mType string
 		var mDelta sql.NullInt64
 		var mValue sql.NullFloat64
+		var mHistogram, mSketch []byte
 
-		err := rows.Scan(&mID, &mType, &mDelta, &mValue)
+		err := rows.Scan(&mID, &mType, &mDelta, &mValue, &mHistogram, &mSketch)
 		if err != nil {
 			storage.logger.Error("GetAll: scan row error", zap.Error(err))
 			return nil, err
 		}
-		_, ok := metrics[mType]
-		if !ok {
-			metrics[mType] = map[string]entity.Metric{
-				mID: {
-					ID:    mID,
-					MType: mType,
-					Delta: parseDelta(mDelta),
-					Value: parseValue(mValue),
-				},
-			}
-		} else {
-			metrics[mType][mID] = entity.Metric{
-				ID:    mID,
-				MType: mType,
-				Delta: parseDelta(mDelta),
-				Value: parseValue(mValue),
-			}
+		metric, err := buildMetric(mID, mType, mDelta, mValue, mHistogram, mSketch)
+		if err != nil {
+			storage.logger.Error("GetAll: decode error", zap.Error(err))
+			return nil, err
 		}
+		if _, ok := metrics[mType]; !ok {
+			metrics[mType] = make(map[string]entity.Metric)
+		}
+		metrics[mType][mID] = metric
 	}
 
 	if err := rows.Err(); err != nil {
@@ -190,6 +245,37 @@ func (storage *DBStorage) GetAllMetrics() (entity.MetricsStore, error) {
 	return metrics, err
 }
 
+// EachMetric передаёт fn метрики по порядку ID и типа прямо из курсора,
+// не собирая всю таблицу в памяти.
+func (storage *DBStorage) EachMetric(fn func(entity.Metric) error) error {
+	rows, err := storage.db.Query("SELECT id, type, delta, value, histogram, sketch FROM metrics ORDER BY id, type")
+	if err != nil {
+		storage.logger.Error("Each: select error", zap.Error(err))
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mID, mType string
+		var mDelta sql.NullInt64
+		var mValue sql.NullFloat64
+		var mHistogram, mSketch []byte
+		if err := rows.Scan(&mID, &mType, &mDelta, &mValue, &mHistogram, &mSketch); err != nil {
+			storage.logger.Error("Each: scan row error", zap.Error(err))
+			return err
+		}
+		metric, err := buildMetric(mID, mType, mDelta, mValue, mHistogram, mSketch)
+		if err != nil {
+			storage.logger.Error("Each: decode error", zap.Error(err))
+			return err
+		}
+		if err := fn(metric); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
 func (storage *DBStorage) ClearMetrics() error {
 	storage.mu.Lock()
 	defer storage.mu.Unlock()
@@ -211,3 +297,62 @@ func parseValue(v sql.NullFloat64) *float64 {
 	}
 	return nil
 }
+
+// buildMetric собирает метрику из колонок строки. Ошибка означает, что
+// сохранённый скетч повреждён.
+func buildMetric(id, mType string, delta sql.NullInt64, value sql.NullFloat64, rawHistogram, rawSketch []byte) (entity.Metric, error) {
+	histogram, err := parseHistogram(rawHistogram)
+	if err != nil {
+		return entity.Metric{}, fmt.Errorf("metric %s: %w", id, err)
+	}
+	summary, err := parseSummary(mType, rawSketch)
+	if err != nil {
+		return entity.Metric{}, fmt.Errorf("metric %s: %w", id, err)
+	}
+	set, err := parseSet(mType, rawSketch)
+	if err != nil {
+		return entity.Metric{}, fmt.Errorf("metric %s: %w", id, err)
+	}
+	return entity.Metric{
+		ID:        id,
+		MType:     mType,
+		Delta:     parseDelta(delta),
+		Value:     parseValue(value),
+		Histogram: histogram,
+		Summary:   summary,
+		Set:       set,
+	}, nil
+}
+
+func parseHistogram(raw []byte) (*entity.HistogramValue, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var h entity.HistogramValue
+	if err := json.Unmarshal(raw, &h); err != nil {
+		return nil, fmt.Errorf("failed to decode histogram: %w", err)
+	}
+	return &h, nil
+}
+
+func parseSummary(mType string, raw []byte) (*entity.SummaryValue, error) {
+	if mType != entity.Summary || len(raw) == 0 {
+		return nil, nil
+	}
+	var s entity.SummaryValue
+	if err := s.UnmarshalBinary(raw); err != nil {
+		return nil, fmt.Errorf("failed to decode summary: %w", err)
+	}
+	return &s, nil
+}
+
+func parseSet(mType string, raw []byte) (*entity.SetValue, error) {
+	if mType != entity.Set || len(raw) == 0 {
+		return nil, nil
+	}
+	var s entity.SetValue
+	if err := s.UnmarshalBinary(raw); err != nil {
+		return nil, fmt.Errorf("failed to decode set: %w", err)
+	}
+	return &s, nil
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+// DefaultHistoryRetention — сколько хранятся сэмплы в metric_samples.
+const DefaultHistoryRetention = 7 * 24 * time.Hour
+
+// DBHistory хранит историю обновлений в таблице metric_samples. Сэмплы старше
+// retention удаляет Compact, Samples отдаёт не больше limit последних сэмплов.
+type DBHistory struct {
+	db        *sql.DB
+	logger    *zap.Logger
+	retention time.Duration
+	limit     int
+}
+
+func NewDBHistory(logger *zap.Logger, db *sql.DB, retention time.Duration, limit int) *DBHistory {
+	if retention <= 0 {
+		retention = DefaultHistoryRetention
+	}
+	if limit <= 0 {
+		limit = DefaultHistorySize
+	}
+	return &DBHistory{
+		db:        db,
+		logger:    logger,
+		retention: retention,
+		limit:     limit,
+	}
+}
+
+// Record пишет сэмплы одной транзакцией. Метрики к этому моменту уже сохранены,
+// поэтому ошибка истории только логируется.
+func (h *DBHistory) Record(samples []entity.Sample) {
+	if len(samples) == 0 {
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		h.logger.Error("History: begin transaction error", zap.Error(err))
+		return
+	}
+	stmt, err := tx.Prepare("INSERT INTO metric_samples (id, type, ts, delta, value) VALUES ($1, $2, $3, $4, $5)")
+	if err != nil {
+		h.logger.Error("History: prepare error", zap.Error(err))
+		tx.Rollback()
+		return
+	}
+	defer stmt.Close()
+
+	for _, sample := range samples {
+		if _, err := stmt.Exec(sample.ID, sample.MType, sample.Timestamp, sample.Delta, sample.Value); err != nil {
+			h.logger.Error("History: insert error", zap.Error(err))
+			tx.Rollback()
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		h.logger.Error("History: commit transaction error", zap.Error(err))
+	}
+}
+
+func (h *DBHistory) Samples(id, mType string, from, to time.Time) ([]entity.Sample, error) {
+	rows, err := h.db.Query(
+		`SELECT ts, delta, value FROM (
+			SELECT ts, delta, value FROM metric_samples
+			WHERE id = $1 AND type = $2 AND ts BETWEEN $3 AND $4
+			ORDER BY ts DESC LIMIT $5
+		) latest ORDER BY ts`,
+		id, mType, from, to, h.limit,
+	)
+	if err != nil {
+		h.logger.Error("History: select error", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []entity.Sample
+	for rows.Next() {
+		var ts time.Time
+		var delta sql.NullInt64
+		var value sql.NullFloat64
+		if err := rows.Scan(&ts, &delta, &value); err != nil {
+			h.logger.Error("History: scan row error", zap.Error(err))
+			return nil, err
+		}
+		samples = append(samples, entity.Sample{
+			ID:        id,
+			MType:     mType,
+			Timestamp: ts,
+			Delta:     parseDelta(delta),
+			Value:     parseValue(value),
+		})
+	}
+	return samples, rows.Err()
+}
+
+func (h *DBHistory) Compact(now time.Time) error {
+	if _, err := h.db.Exec("DELETE FROM metric_samples WHERE ts < $1", now.Add(-h.retention)); err != nil {
+		h.logger.Error("History: compact error", zap.Error(err))
+		return err
+	}
+	return nil
+}
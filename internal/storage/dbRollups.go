@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+// DBRollups хранит корзины всех уровней в таблице metric_rollups, уровень — resolution_seconds.
+type DBRollups struct {
+	db     *sql.DB
+	tiers  []entity.RollupTier
+	logger *zap.Logger
+}
+
+func NewDBRollups(logger *zap.Logger, db *sql.DB, tiers []entity.RollupTier) *DBRollups {
+	return &DBRollups{
+		db:     db,
+		tiers:  tiers,
+		logger: logger,
+	}
+}
+
+func (r *DBRollups) Tiers() []entity.RollupTier {
+	return r.tiers
+}
+
+type rollupUpsert struct {
+	sample     entity.Sample
+	resolution int64
+	bucket     time.Time
+}
+
+func (u rollupUpsert) less(other rollupUpsert) bool {
+	if u.sample.ID != other.sample.ID {
+		return u.sample.ID < other.sample.ID
+	}
+	if u.sample.MType != other.sample.MType {
+		return u.sample.MType < other.sample.MType
+	}
+	if u.resolution != other.resolution {
+		return u.resolution < other.resolution
+	}
+	return u.bucket.Before(other.bucket)
+}
+
+// Record сливает сэмплы с корзинами одной транзакцией. Метрики к этому моменту
+// уже сохранены, поэтому ошибка только логируется.
+func (r *DBRollups) Record(samples []entity.Sample) {
+	if len(samples) == 0 || len(r.tiers) == 0 {
+		return
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		r.logger.Error("Rollups: begin transaction error", zap.Error(err))
+		return
+	}
+	stmt, err := tx.Prepare(`INSERT INTO metric_rollups (id, type, resolution_seconds, bucket_start, count, min, max, sum, last, delta)
+		VALUES ($1, $2, $3, $4, 1, $5, $5, $5, $5, $6)
+		ON CONFLICT (id, type, resolution_seconds, bucket_start) DO UPDATE SET
+			count = metric_rollups.count + 1,
+			min = LEAST(metric_rollups.min, EXCLUDED.min),
+			max = GREATEST(metric_rollups.max, EXCLUDED.max),
+			sum = coalesce(metric_rollups.sum, 0) + coalesce(EXCLUDED.sum, 0),
+			last = coalesce(EXCLUDED.last, metric_rollups.last),
+			delta = coalesce(metric_rollups.delta, 0) + coalesce(EXCLUDED.delta, 0)`)
+	if err != nil {
+		r.logger.Error("Rollups: prepare error", zap.Error(err))
+		tx.Rollback()
+		return
+	}
+	defer stmt.Close()
+
+	// Строки блокируются в одном порядке во всех транзакциях, иначе параллельные
+	// пачки с теми же метриками могут взаимно заблокироваться. Сортировка
+	// устойчивая: в корзине остаётся последнее значение пачки.
+	upserts := make([]rollupUpsert, 0, len(samples)*len(r.tiers))
+	for _, tier := range r.tiers {
+		for _, sample := range samples {
+			upserts = append(upserts, rollupUpsert{
+				sample:     sample,
+				resolution: int64(tier.Resolution / time.Second),
+				bucket:     sample.Timestamp.Truncate(tier.Resolution),
+			})
+		}
+	}
+	sort.SliceStable(upserts, func(i, j int) bool { return upserts[i].less(upserts[j]) })
+
+	for _, u := range upserts {
+		if _, err := stmt.Exec(u.sample.ID, u.sample.MType, u.resolution, u.bucket, u.sample.Value, u.sample.Delta); err != nil {
+			r.logger.Error("Rollups: upsert error", zap.Error(err))
+			tx.Rollback()
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		r.logger.Error("Rollups: commit transaction error", zap.Error(err))
+	}
+}
+
+func (r *DBRollups) Rollups(id, mType string, tier entity.RollupTier, from, to time.Time) ([]entity.Rollup, error) {
+	rows, err := r.db.Query(
+		`SELECT bucket_start, count, min, max, sum, last, delta FROM metric_rollups
+		WHERE id = $1 AND type = $2 AND resolution_seconds = $3 AND bucket_start BETWEEN $4 AND $5
+		ORDER BY bucket_start`,
+		id, mType, int64(tier.Resolution/time.Second), from.Truncate(tier.Resolution), to,
+	)
+	if err != nil {
+		r.logger.Error("Rollups: select error", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []entity.Rollup
+	for rows.Next() {
+		var rollup entity.Rollup
+		var min, max, sum, last sql.NullFloat64
+		var delta sql.NullInt64
+		if err := rows.Scan(&rollup.Start, &rollup.Count, &min, &max, &sum, &last, &delta); err != nil {
+			r.logger.Error("Rollups: scan row error", zap.Error(err))
+			return nil, err
+		}
+		if mType == entity.Counter {
+			rollup.Sum = parseDelta(delta)
+		} else if sum.Valid && rollup.Count > 0 {
+			avg := sum.Float64 / float64(rollup.Count)
+			rollup.Min, rollup.Max, rollup.Avg, rollup.Last = parseValue(min), parseValue(max), &avg, parseValue(last)
+		}
+		result = append(result, rollup)
+	}
+	return result, rows.Err()
+}
+
+func (r *DBRollups) Compact(now time.Time) error {
+	for _, tier := range r.tiers {
+		_, err := r.db.Exec(
+			"DELETE FROM metric_rollups WHERE resolution_seconds = $1 AND bucket_start < $2",
+			int64(tier.Resolution/time.Second), now.Add(-tier.Retention),
+		)
+		if err != nil {
+			r.logger.Error("Rollups: compact error", zap.Error(err))
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"sync"
+	"time"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+const (
+	DefaultHistorySize = 1000
+	// DefaultHistoryIdle — история метрики без обновлений дольше этого срока удаляется.
+	DefaultHistoryIdle = 24 * time.Hour
+)
+
+// historyRing хранит последние size сэмплов метрики, новые вытесняют старые.
+// Буфер растёт по мере записи и занимает size только у метрик с длинной историей.
+type historyRing struct {
+	samples []entity.Sample
+	next    int
+	updated time.Time
+}
+
+// MemHistory хранит историю обновлений в памяти, по кольцевому буферу на метрику.
+// Метрики, которые не обновлялись дольше idle, удаляются при очередной записи,
+// проверка выполняется не чаще раза в idle.
+type MemHistory struct {
+	mu    sync.RWMutex
+	size  int
+	idle  time.Duration
+	swept time.Time
+	rings map[metricKey]*historyRing
+}
+
+func NewMemHistory(size int, idle time.Duration) *MemHistory {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	if idle <= 0 {
+		idle = DefaultHistoryIdle
+	}
+	return &MemHistory{
+		size:  size,
+		idle:  idle,
+		swept: time.Now(),
+		rings: make(map[metricKey]*historyRing),
+	}
+}
+
+func (h *MemHistory) Record(samples []entity.Sample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	for _, sample := range samples {
+		key := metricKey{id: sample.ID, mType: sample.MType}
+		ring, ok := h.rings[key]
+		if !ok {
+			ring = &historyRing{}
+			h.rings[key] = ring
+		}
+		ring.updated = now
+		if len(ring.samples) < h.size {
+			ring.samples = append(ring.samples, sample)
+			continue
+		}
+		ring.samples[ring.next] = sample
+		ring.next = (ring.next + 1) % h.size
+	}
+
+	if now.Sub(h.swept) >= h.idle {
+		h.sweep(now)
+	}
+}
+
+// Compact удаляет историю метрик, которые не обновлялись дольше idle.
+func (h *MemHistory) Compact(now time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sweep(now)
+	return nil
+}
+
+func (h *MemHistory) sweep(now time.Time) {
+	for key, ring := range h.rings {
+		if now.Sub(ring.updated) > h.idle {
+			delete(h.rings, key)
+		}
+	}
+	h.swept = now
+}
+
+func (h *MemHistory) Samples(id, mType string, from, to time.Time) ([]entity.Sample, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	ring, ok := h.rings[metricKey{id: id, mType: mType}]
+	if !ok {
+		return nil, nil
+	}
+
+	var result []entity.Sample
+	for i := range ring.samples {
+		sample := ring.samples[(ring.next+i)%len(ring.samples)]
+		if sample.Timestamp.Before(from) || sample.Timestamp.After(to) {
+			continue
+		}
+		result = append(result, sample)
+	}
+	return result, nil
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/WPGe/go-yandex-advanced/internal/entity"
+)
+
+type rollupBucket struct {
+	count int64
+	min   float64
+	max   float64
+	sum   float64
+	last  float64
+	delta int64
+	gauge bool
+}
+
+func (b *rollupBucket) add(sample entity.Sample) {
+	if sample.Value != nil {
+		v := *sample.Value
+		if !b.gauge || v < b.min {
+			b.min = v
+		}
+		if !b.gauge || v > b.max {
+			b.max = v
+		}
+		b.sum += v
+		b.last = v
+		b.gauge = true
+	}
+	if sample.Delta != nil {
+		b.delta += *sample.Delta
+	}
+	b.count++
+}
+
+func (b *rollupBucket) rollup(start time.Time, mType string) entity.Rollup {
+	r := entity.Rollup{Start: start, Count: b.count}
+	if mType == entity.Counter {
+		delta := b.delta
+		r.Sum = &delta
+		return r
+	}
+	min, max, last := b.min, b.max, b.last
+	avg := b.sum / float64(b.count)
+	r.Min, r.Max, r.Avg, r.Last = &min, &max, &avg, &last
+	return r
+}
+
+// MemRollups хранит корзины всех уровней в памяти.
+type MemRollups struct {
+	mu      sync.RWMutex
+	tiers   []entity.RollupTier
+	buckets []map[metricKey]map[int64]*rollupBucket
+}
+
+func NewMemRollups(tiers []entity.RollupTier) *MemRollups {
+	r := &MemRollups{
+		tiers:   tiers,
+		buckets: make([]map[metricKey]map[int64]*rollupBucket, len(tiers)),
+	}
+	for i := range r.buckets {
+		r.buckets[i] = make(map[metricKey]map[int64]*rollupBucket)
+	}
+	return r
+}
+
+func (r *MemRollups) Tiers() []entity.RollupTier {
+	return r.tiers
+}
+
+func (r *MemRollups) Record(samples []entity.Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, tier := range r.tiers {
+		for _, sample := range samples {
+			key := metricKey{id: sample.ID, mType: sample.MType}
+			if r.buckets[i][key] == nil {
+				r.buckets[i][key] = make(map[int64]*rollupBucket)
+			}
+			start := sample.Timestamp.Truncate(tier.Resolution).UnixNano()
+			bucket, ok := r.buckets[i][key][start]
+			if !ok {
+				bucket = &rollupBucket{}
+				r.buckets[i][key][start] = bucket
+			}
+			bucket.add(sample)
+		}
+	}
+}
+
+func (r *MemRollups) Rollups(id, mType string, tier entity.RollupTier, from, to time.Time) ([]entity.Rollup, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var result []entity.Rollup
+	for i, t := range r.tiers {
+		if t.Resolution != tier.Resolution {
+			continue
+		}
+		for start, bucket := range r.buckets[i][metricKey{id: id, mType: mType}] {
+			ts := time.Unix(0, start)
+			if ts.Before(from.Truncate(t.Resolution)) || ts.After(to) {
+				continue
+			}
+			result = append(result, bucket.rollup(ts, mType))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Start.Before(result[j].Start) })
+	return result, nil
+}
+
+// Compact удаляет корзины старше срока хранения своего уровня.
+func (r *MemRollups) Compact(now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, tier := range r.tiers {
+		cutoff := now.Add(-tier.Retention).UnixNano()
+		for key, buckets := range r.buckets[i] {
+			for start := range buckets {
+				if start < cutoff {
+					delete(buckets, start)
+				}
+			}
+			if len(buckets) == 0 {
+				delete(r.buckets[i], key)
+			}
+		}
+	}
+	return nil
+}
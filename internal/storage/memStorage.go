@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"sync"
 
 	"github.com/pkg/errors"
@@ -13,6 +14,21 @@ import (
 	"github.com/WPGe/go-yandex-advanced/internal/entity"
 )
 
+type metricKey struct {
+	id    string
+	mType string
+}
+
+// sortMetricKeys упорядочивает ключи по ID, затем по типу.
+func sortMetricKeys(keys []metricKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].id != keys[j].id {
+			return keys[i].id < keys[j].id
+		}
+		return keys[i].mType < keys[j].mType
+	})
+}
+
 type MemStorage struct {
 	mu      sync.RWMutex
 	metrics entity.MetricsStore
@@ -66,54 +82,64 @@ func NewMemStorageFromFile(fileStoragePath string, logger *zap.Logger) *MemStora
 }
 
 func (m *MemStorage) AddMetric(metric entity.Metric) error {
+	return m.AddMetrics([]entity.Metric{metric})
+}
+
+// AddMetrics применяет пакет целиком или не применяет ничего: сначала все
+// метрики сливаются с текущими значениями, и только если ни одна не отклонена,
+// результат записывается в хранилище.
+func (m *MemStorage) AddMetrics(metrics []entity.Metric) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	var existingMetric entity.Metric
-
-	_, ok := m.metrics[metric.MType]
-	if !ok {
-		m.metrics[metric.MType] = map[string]entity.Metric{
-			metric.ID: metric,
+	merged := make(map[metricKey]entity.Metric, len(metrics))
+	order := make([]metricKey, 0, len(metrics))
+	for _, metric := range metrics {
+		key := metricKey{id: metric.ID, mType: metric.MType}
+		existing, ok := merged[key]
+		if !ok {
+			existing, ok = m.metrics[metric.MType][metric.ID]
+			order = append(order, key)
+		}
+		var current *entity.Metric
+		if ok {
+			current = &existing
+		}
+		result, err := mergeMetric(current, metric)
+		if err != nil {
+			return err
 		}
-		return nil
+		merged[key] = result
 	}
 
-	if metric.MType == entity.Gauge {
-		m.metrics[metric.MType][metric.ID] = metric
-		return nil
+	for _, key := range order {
+		if _, ok := m.metrics[key.mType]; !ok {
+			m.metrics[key.mType] = make(map[string]entity.Metric)
+		}
+		m.metrics[key.mType][key.id] = merged[key]
 	}
+	return nil
+}
 
-	if metric.Delta == nil {
-		return errors.New("delta cannot be nil for counter metric")
+// mergeMetric вычисляет новое значение метрики, не трогая existing: гейдж
+// заменяется, приращение счётчика складывается, скетчи сливаются.
+func mergeMetric(existing *entity.Metric, metric entity.Metric) (entity.Metric, error) {
+	if entity.IsSketch(metric.MType) {
+		return entity.MergeSketch(existing, metric)
 	}
-
-	_, ok = m.metrics[metric.MType][metric.ID]
-	if !ok {
-		m.metrics[metric.MType][metric.ID] = metric
-		return nil
+	if metric.MType == entity.Gauge {
+		return metric, nil
 	}
 
-	existingMetric = m.metrics[metric.MType][metric.ID]
-	if existingMetric.Delta == nil {
-		existingMetric.Delta = new(int64)
+	if metric.Delta == nil {
+		return entity.Metric{}, fmt.Errorf("%w: counter %s has no delta", entity.ErrInvalidMetric, metric.ID)
 	}
-
-	*existingMetric.Delta += *metric.Delta
-	m.metrics[metric.MType][metric.ID] = existingMetric
-
-	return nil
-}
-
-func (m *MemStorage) AddMetrics(metrics []entity.Metric) error {
-	for _, metric := range metrics {
-		err := m.AddMetric(metric)
-		if err != nil {
-			return err
-		}
+	delta := *metric.Delta
+	if existing != nil && existing.Delta != nil {
+		delta += *existing.Delta
 	}
-
-	return nil
+	metric.Delta = &delta
+	return metric, nil
 }
 
 func (m *MemStorage) GetMetric(id, metricType string) (*entity.Metric, error) {
@@ -131,10 +157,63 @@ func (m *MemStorage) GetMetric(id, metricType string) (*entity.Metric, error) {
 	return &metric, nil
 }
 
+// GetAllMetrics возвращает копию хранилища: вызывающий может читать её,
+// пока метрики обновляются.
 func (m *MemStorage) GetAllMetrics() (entity.MetricsStore, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.metrics, nil
+
+	metrics := make(entity.MetricsStore, len(m.metrics))
+	for mType, byID := range m.metrics {
+		metrics[mType] = make(map[string]entity.Metric, len(byID))
+		for id, metric := range byID {
+			metrics[mType][id] = metric.Clone()
+		}
+	}
+	return metrics, nil
+}
+
+// EachMetric передаёт fn копии метрик по порядку ID и типа. Блокировка берётся
+// на каждую метрику отдельно, поэтому медленный fn не задерживает запись,
+// а удалённые за время обхода метрики пропускаются.
+func (m *MemStorage) EachMetric(fn func(entity.Metric) error) error {
+	m.mu.RLock()
+	var keys []metricKey
+	for mType, byID := range m.metrics {
+		for id := range byID {
+			keys = append(keys, metricKey{id: id, mType: mType})
+		}
+	}
+	m.mu.RUnlock()
+	sortMetricKeys(keys)
+
+	for _, key := range keys {
+		m.mu.RLock()
+		metric, ok := m.metrics[key.mType][key.id]
+		if ok {
+			metric = metric.Clone()
+		}
+		m.mu.RUnlock()
+		if !ok {
+			continue
+		}
+		if err := fn(metric); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemStorage) DeleteMetric(id, metricType string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.metrics[metricType], id)
+	if len(m.metrics[metricType]) == 0 {
+		delete(m.metrics, metricType)
+	}
+
+	return nil
 }
 
 func (m *MemStorage) ClearMetrics() error {
@@ -16,7 +16,8 @@ func WithGzip(h http.HandlerFunc) http.HandlerFunc {
 		acceptEncoding := r.Header.Get("Accept-Encoding")
 		supportsGzip := strings.Contains(acceptEncoding, "gzip")
 		acceptType := r.Header.Get("Accept")
-		supportType := strings.Contains(acceptType, "application/json") || strings.Contains(acceptType, "html/text") || strings.Contains(acceptType, "text/html")
+		supportType := strings.Contains(acceptType, "application/json") || strings.Contains(acceptType, "html/text") || strings.Contains(acceptType, "text/html") ||
+			strings.Contains(acceptType, "text/plain") || strings.Contains(acceptType, "application/openmetrics-text")
 		if supportsGzip && supportType {
 			w.Header().Set("Content-Encoding", "gzip")
 			cw := newCompressWriter(w)
@@ -0,0 +1,219 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertReloader перечитывает пару сертификат/ключ при изменении файла сертификата,
+// поэтому ротация сертификатов не требует перезапуска процесса.
+type CertReloader struct {
+	mu       sync.Mutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if _, err := r.Certificate(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *CertReloader) Certificate() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stat, err := os.Stat(r.certFile)
+	if err != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("failed to stat certificate: %w", err)
+	}
+	if r.cert != nil && !stat.ModTime().After(r.modTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load key pair: %w", err)
+	}
+	r.cert = &cert
+	r.modTime = stat.ModTime()
+
+	return r.cert, nil
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Certificate()
+}
+
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.Certificate()
+}
+
+// CAReloader перечитывает бандл CA при изменении файла.
+type CAReloader struct {
+	mu      sync.Mutex
+	caFile  string
+	pool    *x509.CertPool
+	modTime time.Time
+}
+
+func NewCAReloader(caFile string) (*CAReloader, error) {
+	r := &CAReloader{caFile: caFile}
+	if _, err := r.Pool(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *CAReloader) Pool() (*x509.CertPool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stat, err := os.Stat(r.caFile)
+	if err != nil {
+		if r.pool != nil {
+			return r.pool, nil
+		}
+		return nil, fmt.Errorf("failed to stat CA bundle: %w", err)
+	}
+	if r.pool != nil && !stat.ModTime().After(r.modTime) {
+		return r.pool, nil
+	}
+
+	data, err := os.ReadFile(r.caFile)
+	if err != nil {
+		if r.pool != nil {
+			return r.pool, nil
+		}
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		if r.pool != nil {
+			return r.pool, nil
+		}
+		return nil, errors.New("no certificates found in CA bundle")
+	}
+	r.pool = pool
+	r.modTime = stat.ModTime()
+
+	return r.pool, nil
+}
+
+// NewServerTLSConfig возвращает конфигурацию сервера, которая на каждое
+// подключение берёт актуальные сертификат и CA клиентов.
+func NewServerTLSConfig(certFile, keyFile, clientCAFile string, requireClientCert bool) (*tls.Config, error) {
+	certs, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var clientCAs *CAReloader
+	if clientCAFile != "" {
+		if clientCAs, err = NewCAReloader(clientCAFile); err != nil {
+			return nil, err
+		}
+	}
+	if requireClientCert && clientCAs == nil {
+		return nil, errors.New("client CA is required to verify client certificates")
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: certs.GetCertificate,
+			}
+			if clientCAs != nil {
+				pool, err := clientCAs.Pool()
+				if err != nil {
+					return nil, err
+				}
+				cfg.ClientCAs = pool
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+				if requireClientCert {
+					cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				}
+			}
+			return cfg, nil
+		},
+	}, nil
+}
+
+// NewClientTLSConfig возвращает конфигурацию клиента. Если задан caFile,
+// сервер проверяется по актуальному содержимому бандла при каждом подключении.
+// Имя сервера берётся из SNI, а при подключении по IP, когда SNI не отправляется,
+// — из serverName; без имени подключение отклоняется.
+func NewClientTLSConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+
+	if certFile != "" || keyFile != "" {
+		certs, err := NewCertReloader(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetClientCertificate = certs.GetClientCertificate
+	}
+
+	if caFile != "" {
+		roots, err := NewCAReloader(caFile)
+		if err != nil {
+			return nil, err
+		}
+		// Стандартная проверка не умеет подменять RootCAs на лету,
+		// поэтому проверяем цепочку сами в VerifyConnection.
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = func(state tls.ConnectionState) error {
+			pool, err := roots.Pool()
+			if err != nil {
+				return err
+			}
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("server did not present a certificate")
+			}
+			dnsName := state.ServerName
+			if dnsName == "" {
+				dnsName = serverName
+			}
+			if dnsName == "" {
+				return errors.New("server name is unknown: set the TLS server name to connect by IP")
+			}
+			opts := x509.VerifyOptions{
+				Roots:         pool,
+				DNSName:       dnsName,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range state.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err = state.PeerCertificates[0].Verify(opts)
+			return err
+		}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,252 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, isCA bool, dnsNames ...string) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     dnsNames,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if isCA {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// writePair пишет сертификат и ключ и сдвигает время изменения вперёд,
+// чтобы перезапись в пределах одной секунды тоже была заметна.
+func (c *testCert) writePair(t *testing.T, certFile, keyFile string, age time.Duration) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	writePEM(t, certFile, age, c)
+}
+
+func writePEM(t *testing.T, path string, age time.Duration, certs ...*testCert) {
+	t.Helper()
+	var data []byte
+	for _, c := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})...)
+	}
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	mtime := time.Now().Add(age)
+	require.NoError(t, os.Chtimes(path, mtime, mtime))
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ca := newTestCert(t, "ca", nil, true)
+	first := newTestCert(t, "first", ca, false)
+	first.writePair(t, certFile, keyFile, -time.Minute)
+
+	r, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	cert, err := r.Certificate()
+	require.NoError(t, err)
+	assert.Equal(t, first.der, cert.Certificate[0])
+
+	second := newTestCert(t, "second", ca, false)
+	second.writePair(t, certFile, keyFile, 0)
+	cert, err = r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, second.der, cert.Certificate[0], "changed certificate must be reloaded")
+
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0644))
+	mtime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, mtime, mtime))
+	cert, err = r.GetClientCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, second.der, cert.Certificate[0], "broken file must keep the last good certificate")
+
+	_, err = NewCertReloader(filepath.Join(dir, "missing.pem"), keyFile)
+	assert.Error(t, err)
+}
+
+func TestCAReloader(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	first := newTestCert(t, "first-ca", nil, true)
+	second := newTestCert(t, "second-ca", nil, true)
+	writePEM(t, caFile, -time.Minute, first)
+
+	r, err := NewCAReloader(caFile)
+	require.NoError(t, err)
+	pool, err := r.Pool()
+	require.NoError(t, err)
+	assert.True(t, pool.Equal(certPool(first)))
+
+	writePEM(t, caFile, 0, first, second)
+	pool, err = r.Pool()
+	require.NoError(t, err)
+	assert.True(t, pool.Equal(certPool(first, second)), "changed bundle must be reloaded")
+
+	require.NoError(t, os.WriteFile(caFile, []byte("no certificates"), 0644))
+	mtime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(caFile, mtime, mtime))
+	pool, err = r.Pool()
+	require.NoError(t, err)
+	assert.True(t, pool.Equal(certPool(first, second)), "broken bundle must keep the last good pool")
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	require.NoError(t, os.WriteFile(empty, nil, 0644))
+	_, err = NewCAReloader(empty)
+	assert.Error(t, err)
+}
+
+func certPool(certs ...*testCert) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, c := range certs {
+		pool.AddCert(c.cert)
+	}
+	return pool
+}
+
+func TestTLSConfigs(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil, true)
+	otherCA := newTestCert(t, "other-ca", nil, true)
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	writePEM(t, path("ca.pem"), -time.Minute, ca)
+	writePEM(t, path("other-ca.pem"), -time.Minute, otherCA)
+	newTestCert(t, "server", ca, false, "metrics.local").writePair(t, path("server.pem"), path("server-key.pem"), -time.Minute)
+	newTestCert(t, "client", ca, false).writePair(t, path("client.pem"), path("client-key.pem"), -time.Minute)
+	newTestCert(t, "stranger", otherCA, false).writePair(t, path("stranger.pem"), path("stranger-key.pem"), -time.Minute)
+
+	serverConfig, err := NewServerTLSConfig(path("server.pem"), path("server-key.pem"), path("ca.pem"), true)
+	require.NoError(t, err)
+	addr := serveTLS(t, serverConfig)
+
+	tests := []struct {
+		name       string
+		caFile     string
+		certFile   string
+		keyFile    string
+		serverName string
+		wantErr    bool
+	}{
+		{name: "trusted", caFile: "ca.pem", certFile: "client.pem", keyFile: "client-key.pem", serverName: "metrics.local"},
+		{name: "unknown server CA", caFile: "other-ca.pem", certFile: "client.pem", keyFile: "client-key.pem", serverName: "metrics.local", wantErr: true},
+		{name: "wrong server name", caFile: "ca.pem", certFile: "client.pem", keyFile: "client-key.pem", serverName: "other.local", wantErr: true},
+		{name: "no client certificate", caFile: "ca.pem", serverName: "metrics.local", wantErr: true},
+		{name: "untrusted client certificate", caFile: "ca.pem", certFile: "stranger.pem", keyFile: "stranger-key.pem", serverName: "metrics.local", wantErr: true},
+		// addr — 127.0.0.1: SNI не отправляется, а IP в сертификате нет.
+		{name: "dial by IP, cert without IP SAN", caFile: "ca.pem", certFile: "client.pem", keyFile: "client-key.pem", wantErr: true},
+		{name: "IP server name, cert without IP SAN", caFile: "ca.pem", certFile: "client.pem", keyFile: "client-key.pem", serverName: "127.0.0.1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certFile, keyFile := "", ""
+			if tt.certFile != "" {
+				certFile, keyFile = path(tt.certFile), path(tt.keyFile)
+			}
+			clientConfig, err := NewClientTLSConfig(path(tt.caFile), certFile, keyFile, tt.serverName)
+			require.NoError(t, err)
+
+			err = handshake(addr, clientConfig)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	t.Run("rotated client CA bundle", func(t *testing.T) {
+		clientConfig, err := NewClientTLSConfig(path("other-ca.pem"), path("client.pem"), path("client-key.pem"), "metrics.local")
+		require.NoError(t, err)
+		require.Error(t, handshake(addr, clientConfig))
+
+		writePEM(t, path("other-ca.pem"), 0, otherCA, ca)
+		assert.NoError(t, handshake(addr, clientConfig), "VerifyConnection must use the reloaded bundle")
+	})
+
+	_, err = NewServerTLSConfig(path("server.pem"), path("server-key.pem"), "", true)
+	assert.Error(t, err, "client certificates cannot be required without a CA")
+}
+
+// serveTLS принимает соединения и завершает рукопожатие до закрытия слушателя в конце теста.
+func serveTLS(t *testing.T, cfg *tls.Config) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				tc := tls.Server(conn, cfg)
+				if tc.Handshake() == nil {
+					tc.Write([]byte{1})
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// handshake ждёт байт от сервера: в TLS 1.3 отказ в клиентском сертификате
+// приходит только после завершения рукопожатия на стороне клиента.
+func handshake(addr string, cfg *tls.Config) error {
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	return err
+}
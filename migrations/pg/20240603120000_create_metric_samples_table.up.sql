@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS metric_samples (
+     id VARCHAR NOT NULL,
+     type VARCHAR NOT NULL,
+     ts TIMESTAMPTZ NOT NULL,
+     delta BIGINT,
+     value DOUBLE PRECISION
+);
+CREATE INDEX IF NOT EXISTS metric_samples_id_type_ts_idx ON metric_samples (id, type, ts);
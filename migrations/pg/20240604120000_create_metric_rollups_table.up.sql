@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS metric_rollups (
+     id VARCHAR NOT NULL,
+     type VARCHAR NOT NULL,
+     resolution_seconds BIGINT NOT NULL,
+     bucket_start TIMESTAMPTZ NOT NULL,
+     count BIGINT NOT NULL,
+     min DOUBLE PRECISION,
+     max DOUBLE PRECISION,
+     sum DOUBLE PRECISION,
+     last DOUBLE PRECISION,
+     delta BIGINT,
+     PRIMARY KEY (id, type, resolution_seconds, bucket_start)
+);
+CREATE INDEX IF NOT EXISTS metric_rollups_expiry_idx ON metric_rollups (resolution_seconds, bucket_start);
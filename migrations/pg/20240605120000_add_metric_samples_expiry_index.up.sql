@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS metric_samples_expiry_idx ON metric_samples (ts);
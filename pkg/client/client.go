@@ -0,0 +1,432 @@
+// Package client отправляет метрики на сервер и читает их обратно.
+//
+// Значения копятся в клиенте: гейджи перезаписываются, счётчики суммируются,
+// а накопленная пачка уходит на /updates/ по таймеру, при достижении размера
+// пачки или при явном Flush.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TypeGauge   = "gauge"
+	TypeCounter = "counter"
+
+	// HashHeader содержит HMAC-SHA256 несжатого тела запроса, если задан ключ.
+	HashHeader = "HashSHA256"
+
+	defaultFlushInterval = 10 * time.Second
+	defaultBatchSize     = 1000
+)
+
+var (
+	ErrNotFound = errors.New("metric not found")
+	// ErrInvalidValue возвращает Gauge для NaN и бесконечностей: сервер их не примет.
+	ErrInvalidValue = errors.New("invalid metric value")
+)
+
+// retryableError — ошибка, после которой повтор может пройти: сетевая ошибка или 5xx.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+type Metric struct {
+	ID    string   `json:"id"`
+	MType string   `json:"type"`
+	Delta *int64   `json:"delta,omitempty"`
+	Value *float64 `json:"value,omitempty"`
+}
+
+type Option func(c *Client)
+
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.http = httpClient
+	}
+}
+
+// WithFlushInterval задаёт период фоновой отправки, 0 отключает фоновую отправку.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(c *Client) {
+		c.flushInterval = interval
+	}
+}
+
+// WithBatchSize задаёт число разных метрик, при котором пачка отправляется сразу.
+func WithBatchSize(size int) Option {
+	return func(c *Client) {
+		c.batchSize = size
+	}
+}
+
+// WithRetries задаёт паузы между повторами при сетевых ошибках и ответах 5xx.
+func WithRetries(intervals ...time.Duration) Option {
+	return func(c *Client) {
+		c.retries = intervals
+	}
+}
+
+func WithKey(key string) Option {
+	return func(c *Client) {
+		c.key = key
+	}
+}
+
+func WithGzip(enabled bool) Option {
+	return func(c *Client) {
+		c.gzip = enabled
+	}
+}
+
+type Client struct {
+	baseURL       string
+	http          *http.Client
+	flushInterval time.Duration
+	batchSize     int
+	retries       []time.Duration
+	key           string
+	gzip          bool
+
+	mu       sync.Mutex
+	gauges   map[string]float64
+	counters map[string]int64
+
+	flushMu sync.Mutex
+	full    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	closed  bool
+}
+
+// New создаёт клиент для сервера по адресу вида http://localhost:8080
+// и запускает фоновую отправку.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:       strings.TrimSuffix(baseURL, "/"),
+		http:          &http.Client{Timeout: 10 * time.Second},
+		flushInterval: defaultFlushInterval,
+		batchSize:     defaultBatchSize,
+		retries:       []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second},
+		gzip:          true,
+		gauges:        make(map[string]float64),
+		counters:      make(map[string]int64),
+		full:          make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	go c.run()
+
+	return c
+}
+
+func (c *Client) Gauge(id string, value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("%w: gauge %s is %g", ErrInvalidValue, id, value)
+	}
+
+	c.mu.Lock()
+	c.gauges[id] = value
+	size := len(c.gauges) + len(c.counters)
+	c.mu.Unlock()
+
+	c.notifyIfFull(size)
+	return nil
+}
+
+func (c *Client) Counter(id string, delta int64) {
+	c.mu.Lock()
+	c.counters[id] += delta
+	size := len(c.gauges) + len(c.counters)
+	c.mu.Unlock()
+
+	c.notifyIfFull(size)
+}
+
+// Flush отправляет накопленные метрики. После сетевой ошибки, ответа 5xx или
+// отмены ctx они возвращаются в буфер и уйдут со следующей пачкой. Пачку,
+// которую сервер отверг, повтор не исправит, поэтому она отбрасывается.
+func (c *Client) Flush(ctx context.Context) error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	c.mu.Lock()
+	gauges, counters := c.gauges, c.counters
+	c.gauges = make(map[string]float64)
+	c.counters = make(map[string]int64)
+	c.mu.Unlock()
+
+	if len(gauges) == 0 && len(counters) == 0 {
+		return nil
+	}
+
+	metrics := make([]Metric, 0, len(gauges)+len(counters))
+	for id, value := range gauges {
+		value := value
+		metrics = append(metrics, Metric{ID: id, MType: TypeGauge, Value: &value})
+	}
+	for id, delta := range counters {
+		delta := delta
+		metrics = append(metrics, Metric{ID: id, MType: TypeCounter, Delta: &delta})
+	}
+
+	err := c.Send(ctx, metrics)
+	if err == nil {
+		return nil
+	}
+	var retryable *retryableError
+	if errors.As(err, &retryable) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		c.restore(gauges, counters)
+		return err
+	}
+	return fmt.Errorf("%d metrics dropped: %w", len(metrics), err)
+}
+
+// Close останавливает фоновую отправку и отправляет остаток буфера.
+func (c *Client) Close(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.stop)
+	<-c.done
+
+	return c.Flush(ctx)
+}
+
+// Send сразу отправляет пачку метрик на /updates/ без буферизации.
+func (c *Client) Send(ctx context.Context, metrics []Metric) error {
+	body, err := json.Marshal(metrics)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+
+	headers := http.Header{}
+	headers.Set("Content-Type", "application/json")
+	if c.key != "" {
+		headers.Set(HashHeader, sign(c.key, body))
+	}
+	if c.gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return fmt.Errorf("failed to gzip metrics: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("failed to gzip metrics: %w", err)
+		}
+		body = buf.Bytes()
+		headers.Set("Content-Encoding", "gzip")
+	}
+
+	_, err = c.do(ctx, http.MethodPost, "/updates/", headers, body)
+	return err
+}
+
+// Get читает значение метрики через GET /value/{type}/{id}.
+func (c *Client) Get(ctx context.Context, mType, id string) (*Metric, error) {
+	res, err := c.do(ctx, http.MethodGet, "/value/"+url.PathEscape(mType)+"/"+url.PathEscape(id), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	metric, err := parseValue(mType, id, strings.TrimSpace(string(res)))
+	if err != nil {
+		return nil, err
+	}
+	return &metric, nil
+}
+
+// GetAll читает все метрики со страницы GET /. Типы, которые клиент
+// не знает, пропускаются.
+func (c *Client) GetAll(ctx context.Context) ([]Metric, error) {
+	res, err := c.do(ctx, http.MethodGet, "/", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var metrics []Metric
+	scanner := bufio.NewScanner(bytes.NewReader(res))
+	for scanner.Scan() {
+		parts := strings.Split(scanner.Text(), ": ")
+		if len(parts) != 3 {
+			continue
+		}
+		for i := range parts {
+			parts[i] = strings.TrimSuffix(strings.TrimPrefix(parts[i], "{{"), "}}")
+		}
+		metric, err := parseValue(parts[0], parts[1], parts[2])
+		if err != nil {
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read metrics: %w", err)
+	}
+	return metrics, nil
+}
+
+func (c *Client) run() {
+	defer close(c.done)
+
+	var tick <-chan time.Time
+	if c.flushInterval > 0 {
+		ticker := time.NewTicker(c.flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+		case <-c.full:
+		case <-c.stop:
+			return
+		}
+		// Временную ошибку Flush переживёт сам, вернув метрики в буфер,
+		// а отвергнутую пачку повторять бессмысленно.
+		_ = c.Flush(context.Background())
+	}
+}
+
+func (c *Client) notifyIfFull(size int) {
+	if c.batchSize <= 0 || size < c.batchSize {
+		return
+	}
+	select {
+	case c.full <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Client) restore(gauges map[string]float64, counters map[string]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, value := range gauges {
+		if _, ok := c.gauges[id]; !ok {
+			c.gauges[id] = value
+		}
+	}
+	for id, delta := range counters {
+		c.counters[id] += delta
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, headers http.Header, body []byte) ([]byte, error) {
+	var err error
+	for attempt := 0; ; attempt++ {
+		var res []byte
+		var retry bool
+		res, retry, err = c.doOnce(ctx, method, path, headers, body)
+		if err == nil {
+			return res, nil
+		}
+		if !retry {
+			return nil, err
+		}
+		if attempt >= len(c.retries) {
+			return nil, &retryableError{err: err}
+		}
+
+		timer := time.NewTimer(c.retries[attempt])
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *Client) doOnce(ctx context.Context, method, path string, headers http.Header, body []byte) ([]byte, bool, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to build request: %w", err)
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, ctx.Err() == nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	switch {
+	case res.StatusCode == http.StatusOK:
+		return data, false, nil
+	case res.StatusCode == http.StatusNotFound:
+		return nil, false, ErrNotFound
+	case res.StatusCode >= http.StatusInternalServerError:
+		return nil, true, fmt.Errorf("server error: %s", res.Status)
+	default:
+		return nil, false, fmt.Errorf("unexpected status: %s", res.Status)
+	}
+}
+
+func parseValue(mType, id, raw string) (Metric, error) {
+	metric := Metric{ID: id, MType: mType}
+	switch mType {
+	case TypeGauge:
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return Metric{}, fmt.Errorf("invalid gauge value %q: %w", raw, err)
+		}
+		metric.Value = &value
+	case TypeCounter:
+		delta, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return Metric{}, fmt.Errorf("invalid counter value %q: %w", raw, err)
+		}
+		metric.Delta = &delta
+	default:
+		return Metric{}, fmt.Errorf("unsupported metric type: %s", mType)
+	}
+	return metric, nil
+}
+
+func sign(key string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,255 @@
+package client_test
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/WPGe/go-yandex-advanced/internal/handler"
+	"github.com/WPGe/go-yandex-advanced/internal/service"
+	"github.com/WPGe/go-yandex-advanced/internal/storage"
+	"github.com/WPGe/go-yandex-advanced/internal/utils"
+	"github.com/WPGe/go-yandex-advanced/pkg/client"
+)
+
+func TestClient_FlushAndRead(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		log.Fatalf("can't initialize zap logger: %v", err)
+	}
+
+	srv := service.New(storage.NewMemStorage(logger))
+	r := chi.NewRouter()
+	r.Post("/updates/", utils.WithGzip(handler.MetricUpdatesHandler(srv, logger)))
+	r.Get("/value/{type}/{name}", handler.MetricGetHandler(srv, logger))
+	r.Get("/", handler.MetricGetAllHandler(srv, logger))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	c := client.New(server.URL, client.WithFlushInterval(0), client.WithRetries())
+	c.Gauge("Temperature", 1.5)
+	c.Gauge("Temperature", 2.5)
+	c.Counter("Requests", 2)
+	c.Counter("Requests", 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, c.Close(ctx))
+
+	gauge, err := c.Get(ctx, client.TypeGauge, "Temperature")
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, *gauge.Value)
+
+	counter, err := c.Get(ctx, client.TypeCounter, "Requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *counter.Delta)
+
+	all, err := c.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+}
+
+// recordedRequest — то, что тестовый сервер получил, с распакованным телом.
+type recordedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// fakeServer отвечает статусами из statuses по очереди, затем 200.
+type fakeServer struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []recordedRequest
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var reader io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reader = zr
+	}
+	body, _ := io.ReadAll(reader)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, recordedRequest{header: r.Header.Clone(), body: body})
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (s *fakeServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+// metrics разбирает тело запроса номер i.
+func (s *fakeServer) metrics(t *testing.T, i int) map[string]client.Metric {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	require.Greater(t, len(s.requests), i)
+	var metrics []client.Metric
+	require.NoError(t, json.Unmarshal(s.requests[i].body, &metrics))
+	byID := make(map[string]client.Metric, len(metrics))
+	for _, m := range metrics {
+		byID[m.ID] = m
+	}
+	return byID
+}
+
+func newFakeServer(t *testing.T, statuses ...int) (*fakeServer, string) {
+	t.Helper()
+	fake := &fakeServer{statuses: statuses}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server.URL
+}
+
+func gauge(v float64) client.Metric {
+	return client.Metric{ID: "Temperature", MType: client.TypeGauge, Value: &v}
+}
+
+func TestClient_Retries(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("5xx is retried", func(t *testing.T) {
+		fake, url := newFakeServer(t, http.StatusServiceUnavailable, http.StatusBadGateway)
+		c := client.New(url, client.WithFlushInterval(0), client.WithRetries(time.Millisecond, time.Millisecond, time.Millisecond))
+		require.NoError(t, c.Send(ctx, []client.Metric{gauge(1)}))
+		assert.Equal(t, 3, fake.count())
+	})
+
+	t.Run("retries run out", func(t *testing.T) {
+		fake, url := newFakeServer(t, http.StatusInternalServerError, http.StatusInternalServerError)
+		c := client.New(url, client.WithFlushInterval(0), client.WithRetries(time.Millisecond))
+		require.Error(t, c.Send(ctx, []client.Metric{gauge(1)}))
+		assert.Equal(t, 2, fake.count())
+	})
+
+	t.Run("4xx is not retried", func(t *testing.T) {
+		fake, url := newFakeServer(t, http.StatusBadRequest)
+		c := client.New(url, client.WithFlushInterval(0), client.WithRetries(time.Millisecond, time.Millisecond))
+		require.Error(t, c.Send(ctx, []client.Metric{gauge(1)}))
+		assert.Equal(t, 1, fake.count())
+	})
+}
+
+func TestClient_FlushFailure(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("restored after a server error", func(t *testing.T) {
+		fake, url := newFakeServer(t, http.StatusServiceUnavailable)
+		c := client.New(url, client.WithFlushInterval(0), client.WithRetries())
+		require.NoError(t, c.Gauge("Temperature", 1.5))
+		c.Counter("Requests", 2)
+		require.Error(t, c.Flush(ctx))
+
+		require.NoError(t, c.Gauge("Temperature", 2.5))
+		c.Counter("Requests", 3)
+		require.NoError(t, c.Flush(ctx))
+
+		metrics := fake.metrics(t, 1)
+		assert.Equal(t, 2.5, *metrics["Temperature"].Value, "newer gauge wins")
+		assert.Equal(t, int64(5), *metrics["Requests"].Delta, "counters are summed")
+	})
+
+	t.Run("rejected batch is dropped", func(t *testing.T) {
+		fake, url := newFakeServer(t, http.StatusBadRequest)
+		c := client.New(url, client.WithFlushInterval(0), client.WithRetries())
+		c.Counter("Requests", 2)
+		require.Error(t, c.Flush(ctx))
+		require.NoError(t, c.Flush(ctx))
+		assert.Equal(t, 1, fake.count(), "nothing left to send")
+
+		c.Counter("Requests", 1)
+		require.NoError(t, c.Flush(ctx))
+		assert.Equal(t, int64(1), *fake.metrics(t, 1)["Requests"].Delta)
+	})
+
+	t.Run("non-finite gauge is rejected", func(t *testing.T) {
+		fake, url := newFakeServer(t)
+		c := client.New(url, client.WithFlushInterval(0), client.WithRetries())
+		assert.ErrorIs(t, c.Gauge("Broken", math.NaN()), client.ErrInvalidValue)
+		assert.ErrorIs(t, c.Gauge("Broken", math.Inf(1)), client.ErrInvalidValue)
+		require.NoError(t, c.Gauge("Temperature", 1.5))
+		require.NoError(t, c.Flush(ctx))
+
+		metrics := fake.metrics(t, 0)
+		assert.NotContains(t, metrics, "Broken")
+		assert.Equal(t, 1.5, *metrics["Temperature"].Value)
+	})
+}
+
+func TestClient_Encoding(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("signed with key", func(t *testing.T) {
+		fake, url := newFakeServer(t)
+		c := client.New(url, client.WithFlushInterval(0), client.WithKey("secret"))
+		require.NoError(t, c.Send(ctx, []client.Metric{gauge(1.5)}))
+
+		require.Equal(t, 1, fake.count())
+		req := fake.requests[0]
+		assert.Equal(t, `[{"id":"Temperature","type":"gauge","value":1.5}]`, string(req.body))
+		// HMAC-SHA256 ключа "secret" от несжатого тела выше.
+		assert.Equal(t, "6d7e1bc447c99db651bb7ac7c22c534d080b41270c1a84c46e6cd7a79b09b6f7", req.header.Get(client.HashHeader))
+	})
+
+	t.Run("gzip by default", func(t *testing.T) {
+		fake, url := newFakeServer(t)
+		c := client.New(url, client.WithFlushInterval(0))
+		require.NoError(t, c.Send(ctx, []client.Metric{gauge(1.5)}))
+		assert.Equal(t, "gzip", fake.requests[0].header.Get("Content-Encoding"))
+		assert.Empty(t, fake.requests[0].header.Get(client.HashHeader), "no key, no signature")
+	})
+
+	t.Run("gzip disabled", func(t *testing.T) {
+		fake, url := newFakeServer(t)
+		c := client.New(url, client.WithFlushInterval(0), client.WithGzip(false))
+		require.NoError(t, c.Send(ctx, []client.Metric{gauge(1.5)}))
+		assert.Empty(t, fake.requests[0].header.Get("Content-Encoding"))
+		assert.Equal(t, `[{"id":"Temperature","type":"gauge","value":1.5}]`, string(fake.requests[0].body))
+	})
+}
+
+func TestClient_BatchSize(t *testing.T) {
+	fake, url := newFakeServer(t)
+	c := client.New(url, client.WithFlushInterval(0), client.WithBatchSize(2))
+	defer c.Close(context.Background())
+
+	require.NoError(t, c.Gauge("Temperature", 1.5))
+	assert.Never(t, func() bool { return fake.count() > 0 }, 50*time.Millisecond, 10*time.Millisecond)
+	c.Counter("Requests", 1)
+	require.Eventually(t, func() bool { return fake.count() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	metrics := fake.metrics(t, 0)
+	assert.Len(t, metrics, 2)
+}
+
+func TestClient_GetNotFound(t *testing.T) {
+	_, url := newFakeServer(t, http.StatusNotFound)
+	c := client.New(url, client.WithFlushInterval(0), client.WithRetries())
+	_, err := c.Get(context.Background(), client.TypeGauge, "Missing")
+	assert.ErrorIs(t, err, client.ErrNotFound)
+}